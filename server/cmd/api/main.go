@@ -7,11 +7,19 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Guillem96/portfolio-analyzer-server/internal/account"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/assets"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/buys"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/cash"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/corporateactions"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/dividends"
-	"github.com/Guillem96/portfolio-analyzer-server/internal/infra_http"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/export"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/history"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/imports"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/marketdata"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/portfolios"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/reports"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/server"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
@@ -90,29 +98,46 @@ func setupRouter(l *slog.Logger, host string) http.Handler {
 		sql.InitDB()
 	}
 
-	tickerInfoUrl, present := os.LookupEnv("TICKER_INFO_API")
-	if !present {
-		log.Fatal("TICKER_INFO_API not found")
-	}
-
 	cr := sql.NewExchangeRatesRepository(db, l)
-	tr := infra_http.NewTickerRepository(tickerInfoUrl, cr, l)
+	mtr := sql.NewManualTickersRepository(db, l)
+	tr, err := marketdata.NewChainFromEnv(cr, mtr, l)
+	if err != nil {
+		log.Fatal(err)
+	}
 	sqltr := sql.NewTickersRepository(db, l)
 	br := sql.NewBuysRepository(db, sqltr, l)
 	dr := sql.NewDividendsRepository(db, sqltr, l)
 	ur := sql.NewUsersRepository(db, l)
 	sr := sql.NewSellsRepository(db, sqltr, l)
 	ar := sql.NewAssetsRepository(db, ur, sqltr, sr, br, l)
+	ir := sql.NewImportsRepository(db, l)
+	acr := sql.NewAccountRepository(db, l)
+	car := sql.NewCorporateActionsRepository(db, l)
+	cashr := sql.NewCashRepository(db, l)
+	pr := sql.NewPortfoliosRepository(db, l)
+	rr := sql.NewReportsRepository(db, cr, l)
 
 	// Tickers Cache Manager
-	tcm := tickers.NewCacheManager(tr, sqltr)
+	freshness, err := tickers.FreshnessPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tcm := tickers.NewCacheManager(tr, sqltr, freshness, l)
 
 	// Handlers
-	ah := auth.New(ur, host, l)
+	ah := auth.New(ur, tcm, host, l)
 	bh := buys.New(br, tcm, l)
-	sh := sells.New(sr, br, l)
-	dh := dividends.New(dr, l)
-	assetsHandler := assets.New(ar, l)
-
-	return server.SetupRouter(ah, bh, dh, assetsHandler, sh)
+	sh := sells.New(sr, br, car, ur, l)
+	dh := dividends.New(dr, ar, l)
+	assetsHandler := assets.New(ar, cashr, l)
+	ih := imports.New(imports.NewImporter(br, sr, dr, sqltr, ir, tcm, history.NewBackfiller(ar, sqltr, l), l), l)
+	eh := export.New(br, sr, dr, ur, cr, l)
+	acch := account.New(acr, l)
+	cah := corporateactions.New(car, tcm, l)
+	cashh := cash.New(cashr, l)
+	ph := portfolios.New(pr, l)
+	rh := reports.New(rr, ur, l)
+	th := tickers.New(mtr, sqltr, tcm, l)
+
+	return server.SetupRouter(ah, bh, dh, assetsHandler, sh, ih, eh, acch, cah, cashh, ph, rh, th)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/history"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+)
+
+// Event of the lambda, the scheduled runs carry no user and backfill every user
+type Event struct {
+	User string `json:"user"`
+}
+
+// This script rebuilds the daily value of the portfolios of a user, or of every user, from
+// its transactions and the daily prices stored by the cache task, filling the days the daily
+// task missed.
+//
+//	go run cmd/backfill_history_task/main.go -user me@mail.com
+func main() {
+	err := godotenv.Load()
+	if os.IsNotExist(err) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	if utils.IsRunningInLambdaEnv() {
+		lambda.Start(func(event Event) error {
+			return task(event.User)
+		})
+		return
+	}
+
+	userEmail := flag.String("user", "", "email of the user to backfill, every user when empty")
+	flag.Parse()
+
+	if err := task(*userEmail); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func task(userEmail string) error {
+	l := slog.Default()
+	db := sql.GetDB()
+	sql.InitDB()
+
+	emails := []string{userEmail}
+	if userEmail == "" {
+		if err := db.Model(&sql.User{}).Pluck("email", &emails).Error; err != nil {
+			l.Error("Failed to fetch users", "error", err.Error())
+			return errors.New("failed to fetch users")
+		}
+	}
+
+	sqltr := sql.NewTickersRepository(db, l)
+	ur := sql.NewUsersRepository(db, l)
+	sr := sql.NewSellsRepository(db, sqltr, l)
+	br := sql.NewBuysRepository(db, sqltr, l)
+	ar := sql.NewAssetsRepository(db, ur, sqltr, sr, br, l)
+	backfiller := history.NewBackfiller(ar, sqltr, l)
+
+	// A user failing does not stop the backfill of the rest
+	var errs []error
+	for _, email := range emails {
+		written, err := backfiller.Backfill(email)
+		if err != nil {
+			l.Error("Failed to backfill history", "user", email, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", email, err))
+			continue
+		}
+		l.Info("Backfilled history", "user", email, "snapshots", written)
+	}
+	return errors.Join(errs...)
+}
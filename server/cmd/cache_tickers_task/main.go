@@ -5,9 +5,10 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
-	"github.com/Guillem96/portfolio-analyzer-server/internal/infra_http"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/marketdata"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -38,16 +39,15 @@ func task() error {
 	db := sql.GetDB()
 	sql.InitDB()
 
-	// Curre
-	tickerInfoUrl, present := os.LookupEnv("TICKER_INFO_API")
-	if !present {
-		log.Fatal("TICKER_INFO_API not found")
-	}
-
 	cr := sql.NewExchangeRatesRepository(db, l)
-	tr := infra_http.NewTickerRepository(tickerInfoUrl, cr, l)
+	tr, err := marketdata.NewChainFromEnv(cr, sql.NewManualTickersRepository(db, l), l)
+	if err != nil {
+		l.Error("Failed to set up the market data providers", "error", err.Error())
+		return err
+	}
 	sqltr := sql.NewTickersRepository(db, l)
 	br := sql.NewBuysRepository(db, sqltr, l)
+	ur := sql.NewUsersRepository(db, l)
 
 	tickers, err := br.FindAllTickers()
 	if err != nil {
@@ -55,6 +55,13 @@ func task() error {
 		return err
 	}
 
+	benchmarks, err := ur.FindAllBenchmarks()
+	if err != nil {
+		l.Error("Failed to fetch benchmarks", "error", err.Error())
+		return err
+	}
+	tickers = utils.ArrayUnique(append(tickers, benchmarks...))
+
 	bs, err := getBatchSizeOrDefaut()
 	if err != nil {
 		l.Error("Failed to get batch size", "error", err.Error())
@@ -72,9 +79,45 @@ func task() error {
 		}
 	}
 
+	firstBuys, err := br.FindFirstBuyDates()
+	if err != nil {
+		l.Error("Failed to fetch first buy dates", "error", err.Error())
+		return err
+	}
+
+	for _, ticker := range tickers {
+		if err := fillPrices(ticker, firstBuys, tr, sqltr); err != nil {
+			l.Warn("Failed to fill daily prices", "ticker", ticker, "error", err.Error())
+		}
+	}
+
 	return nil
 }
 
+// fillPrices fetches the daily prices of the ticker since the last one stored, fetching it
+// again as it may have been stored before the market closed, or since its first buy, or a
+// year ago for the tickers never bought
+func fillPrices(ticker string, firstBuys map[string]domain.Date, s domain.PriceHistoryRepository, d domain.TickerPricesRepository) error {
+	start, present := firstBuys[ticker]
+	if !present {
+		start = domain.Date(time.Now().AddDate(-1, 0, 0))
+	}
+
+	last, err := d.FindLastPriceDate(ticker)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		start = *last
+	}
+
+	history, err := s.FindPriceHistory(ticker, start)
+	if err != nil {
+		return err
+	}
+	return d.SavePrices(ticker, history.Currency, history.HistoricalData)
+}
+
 func getBatchSizeOrDefaut() (int, error) {
 	batchSizeStr, present := os.LookupEnv("TICKER_BATCH_SIZE")
 	if !present {
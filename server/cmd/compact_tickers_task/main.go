@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+)
+
+// This script deletes the ticker snapshots the retention no longer keeps. The retention is
+// read from TICKER_SNAPSHOTS_KEEP_ALL_DAYS and TICKER_SNAPSHOTS_KEEP_DAILY_DAYS.
+//
+//	go run cmd/compact_tickers_task/main.go -dry-run
+func main() {
+	err := godotenv.Load()
+	if os.IsNotExist(err) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	if utils.IsRunningInLambdaEnv() {
+		lambda.Start(func() error {
+			return task(false)
+		})
+		return
+	}
+
+	dryRun := flag.Bool("dry-run", false, "report the snapshots to delete without deleting them")
+	flag.Parse()
+
+	if err := task(*dryRun); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func task(dryRun bool) error {
+	l := slog.Default()
+	db := sql.GetDB()
+	sql.InitDB()
+
+	retention, err := getRetentionOrDefault()
+	if err != nil {
+		l.Error("Failed to get the snapshots retention", "error", err.Error())
+		return err
+	}
+
+	sqltr := sql.NewTickersRepository(db, l)
+	report, err := sqltr.Compact(retention, time.Now(), dryRun)
+	if err != nil {
+		l.Error("Failed to compact ticker snapshots", "error", err.Error())
+		return err
+	}
+
+	l.Info("Compacted ticker snapshots",
+		"dryRun", dryRun,
+		"tickers", report.Tickers,
+		"rowsDeleted", report.RowsDeleted,
+		"bytesReclaimed", report.BytesReclaimed,
+	)
+	return nil
+}
+
+func getRetentionOrDefault() (domain.SnapshotRetention, error) {
+	retention := domain.DefaultSnapshotRetention
+	if value, present := os.LookupEnv("TICKER_SNAPSHOTS_KEEP_ALL_DAYS"); present {
+		days, err := strconv.Atoi(value)
+		if err != nil {
+			return domain.SnapshotRetention{}, err
+		}
+		retention.KeepAll = time.Duration(days) * 24 * time.Hour
+	}
+
+	if value, present := os.LookupEnv("TICKER_SNAPSHOTS_KEEP_DAILY_DAYS"); present {
+		days, err := strconv.Atoi(value)
+		if err != nil {
+			return domain.SnapshotRetention{}, err
+		}
+		retention.KeepDaily = time.Duration(days) * 24 * time.Hour
+	}
+
+	if retention.KeepAll < 0 || retention.KeepDaily < retention.KeepAll {
+		return domain.SnapshotRetention{}, errors.New("the daily snapshots must be kept at least as long as all of them")
+	}
+	return retention, nil
+}
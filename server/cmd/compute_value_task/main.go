@@ -54,32 +54,28 @@ func task() error {
 
 	historics := make([]*sql.PortfolioHistoric, 0)
 	for _, user := range users {
-		assets, err := ar.FindAll(user.Email)
+		// A snapshot of the whole account and one of each of its portfolios
+		historic, err := snapshot(ar, user.Email, nil, user.PreferredCurrency)
 		if err != nil {
 			l.Error("Failed to fetch assets", "error", err.Error())
 			return err
 		}
+		historics = append(historics, historic)
 
-		totalValue := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
-			return agg + asset.Value
-		})
-
-		totalValueWithoutReinvest := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
-			return agg + asset.ValueWithoutReinvest
-		})
-
-		totalBuyValue := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
-			return agg + asset.BuyValue
-		})
-
-		historics = append(historics, &sql.PortfolioHistoric{
-			UserEmail:            user.Email,
-			Value:                totalValue,
-			ValueWithoutReinvest: totalValueWithoutReinvest,
-			BuyValue:             totalBuyValue,
-			Currency:             user.PreferredCurrency,
-			ID:                   uuid.New().String(),
-		})
+		var portfolios []sql.Portfolio
+		if err := db.Where("user_email = ?", user.Email).Find(&portfolios).Error; err != nil {
+			l.Error("Failed to fetch portfolios", "error", err.Error())
+			return err
+		}
+
+		for _, portfolio := range portfolios {
+			historic, err := snapshot(ar, user.Email, &portfolio.ID, portfolio.Currency)
+			if err != nil {
+				l.Error("Failed to fetch portfolio assets", "portfolio", portfolio.ID, "error", err.Error())
+				return err
+			}
+			historics = append(historics, historic)
+		}
 	}
 
 	if err := db.Clauses(clause.OnConflict{
@@ -90,3 +86,36 @@ func task() error {
 	}
 	return nil
 }
+
+// snapshot values the assets of the user, optionally only the ones of a portfolio
+func snapshot(ar *sql.AssetsRepository, userEmail string, portfolioId *string, currency string) (*sql.PortfolioHistoric, error) {
+	assets, err := ar.FindAll(userEmail, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	totalValue := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
+		return agg + asset.Value
+	})
+
+	totalValueWithoutReinvest := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
+		return agg + asset.ValueWithoutReinvest
+	})
+
+	totalBuyValue := arrayutils.Reduce(assets, 0, func(agg float32, asset domain.Asset) float32 {
+		return agg + asset.BuyValue
+	})
+
+	historic := &sql.PortfolioHistoric{
+		UserEmail:            userEmail,
+		Value:                totalValue,
+		ValueWithoutReinvest: totalValueWithoutReinvest,
+		BuyValue:             totalBuyValue,
+		Currency:             currency,
+		ID:                   uuid.New().String(),
+	}
+	if portfolioId != nil {
+		historic.PortfolioID = *portfolioId
+	}
+	return historic, nil
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/infra_http"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
@@ -52,6 +53,7 @@ func task() error {
 		return err
 	}
 
+	today := time.Now().UTC().Truncate(24 * time.Hour)
 	for sc, rates := range aer {
 		for tc, rate := range rates {
 			ner := sql.ExchangeRate{
@@ -63,6 +65,18 @@ func task() error {
 				Columns:   []clause.Column{{Name: "source_currency"}, {Name: "target_currency"}},
 				DoUpdates: clause.AssignmentColumns([]string{"rate"}),
 			}).Create(&ner)
+
+			// The rate of the day is kept to convert past movements
+			nerh := sql.ExchangeRateHistoric{
+				SourceCurrency: sc,
+				TargetCurrency: tc,
+				Date:           today,
+				Rate:           rate,
+			}
+			db.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "source_currency"}, {Name: "target_currency"}, {Name: "date"}},
+				DoUpdates: clause.AssignmentColumns([]string{"rate"}),
+			}).Create(&nerh)
 		}
 	}
 	db.Commit()
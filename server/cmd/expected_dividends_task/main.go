@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+)
+
+// This script creates daily the pending dividends of the holdings that went ex-dividend,
+// so users only have to confirm them with the real figures once paid.
+func main() {
+	err := godotenv.Load()
+	if os.IsNotExist(err) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	if utils.IsRunningInLambdaEnv() {
+		lambda.Start(task)
+		return
+	}
+
+	if err := task(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func task() error {
+	l := slog.Default()
+	db := sql.GetDB()
+	sql.InitDB()
+
+	var users []sql.User
+	if err := db.Find(&users).Error; err != nil {
+		l.Error("Failed to fetch users", "error", err.Error())
+		return errors.New("failed to fetch users")
+	}
+
+	sqltr := sql.NewTickersRepository(db, l)
+	dr := sql.NewDividendsRepository(db, sqltr, l)
+
+	now := time.Now()
+	for _, user := range users {
+		created, err := dr.GeneratePending(user.Email, now)
+		if err != nil {
+			l.Error("Failed to generate pending dividends", "user", user.Email, "error", err.Error())
+			return err
+		}
+		l.Info("Generated pending dividends", "user", user.Email, "dividends", created)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/history"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/imports"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/marketdata"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/tickers"
+	"github.com/joho/godotenv"
+)
+
+// This script imports a broker statement into the portfolio of a user. By default it only
+// prints the preview, pass -commit to store the movements.
+//
+//	go run cmd/import/main.go -broker degiro -file Transactions.csv -user me@mail.com -symbols US0378331005=AAPL
+func main() {
+	err := godotenv.Load()
+	if os.IsNotExist(err) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	broker := flag.String("broker", "", "broker of the statement: "+strings.Join(imports.SupportedBrokers(), ", "))
+	file := flag.String("file", "", "path to the CSV statement")
+	userEmail := flag.String("user", "", "email of the user owning the movements")
+	portfolioId := flag.String("portfolio", "", "portfolio receiving the movements, the default one when empty")
+	symbols := flag.String("symbols", "", "comma separated ISIN=TICKER or SYMBOL=TICKER mappings")
+	commit := flag.Bool("commit", false, "store the movements instead of only previewing them")
+	flag.Parse()
+
+	if err := task(*broker, *file, *userEmail, *portfolioId, *symbols, *commit); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func task(broker, file, userEmail, portfolioId, symbols string, commit bool) error {
+	l := slog.Default()
+	if broker == "" || file == "" || userEmail == "" {
+		flag.Usage()
+		return errors.New("broker, file and user are required")
+	}
+
+	symbolsMapping := map[string]string{}
+	for _, s := range strings.Split(symbols, ",") {
+		if s == "" {
+			continue
+		}
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("invalid symbols mapping " + s)
+		}
+		symbolsMapping[parts[0]] = parts[1]
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	db := sql.GetDB()
+	sql.InitDB()
+
+	cr := sql.NewExchangeRatesRepository(db, l)
+	tr, err := marketdata.NewChainFromEnv(cr, sql.NewManualTickersRepository(db, l), l)
+	if err != nil {
+		return err
+	}
+	sqltr := sql.NewTickersRepository(db, l)
+	br := sql.NewBuysRepository(db, sqltr, l)
+	sr := sql.NewSellsRepository(db, sqltr, l)
+	dr := sql.NewDividendsRepository(db, sqltr, l)
+	ir := sql.NewImportsRepository(db, l)
+	ur := sql.NewUsersRepository(db, l)
+	ar := sql.NewAssetsRepository(db, ur, sqltr, sr, br, l)
+	freshness, err := tickers.FreshnessPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	tcm := tickers.NewCacheManager(tr, sqltr, freshness, l)
+
+	importer := imports.NewImporter(br, sr, dr, sqltr, ir, tcm, nil, l)
+	preview, err := importer.Import(broker, f, symbolsMapping, userEmail, portfolioId, commit)
+	if err != nil {
+		return err
+	}
+
+	if err := preview.ToJSON(os.Stdout); err != nil {
+		return err
+	}
+
+	if commit && !preview.Committed {
+		return errors.New("statement not imported, fix the errors listed in the preview")
+	}
+
+	// The script exits once imported, so the history is rebuilt here instead of in the background
+	if commit {
+		if _, err := history.NewBackfiller(ar, sqltr, l).Backfill(userEmail); err != nil {
+			l.Error("Failed to backfill history", "error", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sql"
+	"github.com/joho/godotenv"
+)
+
+// This script leaves every user with a single default portfolio, moving to it the movements
+// registered before portfolios existed. It is run once, when upgrading a database created
+// before default portfolios were unique.
+//
+//	go run cmd/migrate_portfolios/main.go
+func main() {
+	err := godotenv.Load()
+	if os.IsNotExist(err) {
+		slog.Warn("No .env file found")
+	} else if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	if err := task(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func task() error {
+	l := slog.Default()
+	db := sql.GetDB()
+	sql.InitDB()
+
+	if err := sql.MigrateDefaultPortfolios(db); err != nil {
+		l.Error("Failed to migrate default portfolios", "error", err.Error())
+		return err
+	}
+
+	l.Info("Migrated default portfolios")
+	return nil
+}
@@ -63,6 +63,21 @@ locals {
       entry_point = "/cache-tickers-task"
       rate        = "rate(6 hours)"
     },
+    {
+      name        = "expected-dividends-task"
+      entry_point = "/expected-dividends-task"
+      rate        = "rate(24 hours)"
+    },
+    {
+      name        = "backfill-history-task"
+      entry_point = "/backfill-history-task"
+      rate        = "rate(24 hours)"
+    },
+    {
+      name        = "compact-tickers-task"
+      entry_point = "/compact-tickers-task"
+      rate        = "rate(7 days)"
+    },
   ]
 }
 
@@ -177,6 +192,7 @@ resource "aws_lambda_function" "handler" {
       ENVIRONMENT               = "prod"
       DATABASE_URL              = var.database_url
       TICKER_INFO_API           = "https://wcou3sszabchl2bemt7sxwbjey0cbkmx.lambda-url.eu-west-2.on.aws"
+      MARKET_DATA_PROVIDERS     = "ticker_info_api,yahoo,manual"
     }
   }
 }
@@ -205,6 +221,7 @@ resource "aws_lambda_function" "tasks" {
       DATABASE_URL                = var.database_url
       CURRENCY_EXCHANGE_RATES_API = "https://v6.exchangerate-api.com/v6/83a609d5f4903a781a8462fc/latest"
       TICKER_INFO_API             = "https://wcou3sszabchl2bemt7sxwbjey0cbkmx.lambda-url.eu-west-2.on.aws"
+      MARKET_DATA_PROVIDERS       = "ticker_info_api,yahoo,manual"
     }
   }
 }
@@ -32,4 +32,28 @@ output "task_cache_tickers_lambda_arn" {
 
 output "task_cache_tickers_lambda_name" {
   value = aws_lambda_function.tasks["cache-tickers-task"].function_name
-}
\ No newline at end of file
+}
+
+output "task_expected_dividends_lambda_arn" {
+  value = aws_lambda_function.tasks["expected-dividends-task"].arn
+}
+
+output "task_expected_dividends_lambda_name" {
+  value = aws_lambda_function.tasks["expected-dividends-task"].function_name
+}
+
+output "task_backfill_history_lambda_arn" {
+  value = aws_lambda_function.tasks["backfill-history-task"].arn
+}
+
+output "task_backfill_history_lambda_name" {
+  value = aws_lambda_function.tasks["backfill-history-task"].function_name
+}
+
+output "task_compact_tickers_lambda_arn" {
+  value = aws_lambda_function.tasks["compact-tickers-task"].arn
+}
+
+output "task_compact_tickers_lambda_name" {
+  value = aws_lambda_function.tasks["compact-tickers-task"].function_name
+}
@@ -0,0 +1,85 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+)
+
+type Handler struct {
+	repo domain.AccountRepository
+	l    *slog.Logger
+}
+
+func New(repo domain.AccountRepository, logger *slog.Logger) *Handler {
+	return &Handler{
+		repo: repo,
+		l:    logger,
+	}
+}
+
+// BackupHandler downloads the whole state of the user as a versioned JSON archive
+func (h *Handler) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	backup, err := h.repo.Backup(user.Email)
+	if err != nil {
+		h.l.Error("Failed to backup account", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to backup account")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="portfolio-analyzer-backup.json"`)
+	if err := backup.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize backup", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize backup")
+		return
+	}
+}
+
+// RestoreHandler loads an archive produced by the backup endpoint. Archives can only be
+// restored into the account of the same user.
+func (h *Handler) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	backup := &domain.AccountBackup{}
+	err := backup.FromJSON(r.Body)
+	defer r.Body.Close()
+	if errors.Is(err, domain.ErrUnsupportedBackupVersion) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, fmt.Sprintf(
+			"Unsupported backup, only versions %d to %d can be restored: %s",
+			domain.MinAccountBackupVersion, domain.AccountBackupVersion, err.Error()))
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+
+	if backup.User.Email != user.Email {
+		utils.SendHTTPMessage(w, http.StatusForbidden, "The backup belongs to another user")
+		return
+	}
+
+	err = h.repo.Restore(*backup, user.Email)
+	if errors.Is(err, domain.ErrBackupForeignRows) {
+		utils.SendHTTPMessage(w, http.StatusForbidden, "The backup contains movements of another user")
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to restore account", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to restore account")
+		return
+	}
+
+	utils.SendHTTPMessage(w, http.StatusOK, "Account restored successfully")
+}
@@ -1,24 +1,30 @@
 package assets
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/performance"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 )
 
 type Handler struct {
-	repo domain.AssetsRepository
-	l    *slog.Logger
+	repo     domain.AssetsRepository
+	cashRepo domain.CashRepository
+	l        *slog.Logger
 }
 
-func New(repo domain.AssetsRepository, logger *slog.Logger) *Handler {
+func New(repo domain.AssetsRepository, cashRepo domain.CashRepository, logger *slog.Logger) *Handler {
 	return &Handler{
-		repo: repo,
-		l:    logger,
+		repo:     repo,
+		cashRepo: cashRepo,
+		l:        logger,
 	}
 }
 
@@ -26,14 +32,37 @@ func (bh *Handler) ListAssetsHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	assets, err := bh.repo.FindAll(user.Email)
-
+	assets, err := bh.repo.FindAll(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
 	if err != nil {
 		bh.l.Error("Failed to retrieve assets", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve assets")
 		return
 	}
 
+	// With includeCash the assets are wrapped together with the cash balances
+	if r.URL.Query().Get("includeCash") == "true" {
+		balances, err := bh.cashRepo.FindBalances(user.Email, utils.PortfolioQuery(r))
+		if err != nil {
+			bh.l.Error("Failed to retrieve cash balances", "error", err.Error())
+			utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve cash balances")
+			return
+		}
+
+		assetsWithCash := domain.AssetsWithCash{Assets: assets, Cash: balances}
+		if err := assetsWithCash.ToJSON(w); err != nil {
+			bh.l.Error("Failed to serialize assets", "error", err.Error())
+			utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize assets")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+
 	if err := assets.ToJSON(w); err != nil {
 		bh.l.Error("Failed to serialize assets", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize assets")
@@ -48,8 +77,11 @@ func (bh *Handler) ListEventsHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	events, err := bh.repo.FindEvents(user.Email)
-
+	events, err := bh.repo.FindEvents(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
 	if err != nil {
 		bh.l.Error("Failed to retrieve events", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve events")
@@ -98,7 +130,8 @@ func (bh *Handler) RetrieveHistoricDataHandler(w http.ResponseWriter, r *http.Re
 
 	hist, err := bh.repo.FindHistoric(user.Email,
 		domain.Date(parsedStartDate),
-		domain.Date(parsedEndDate))
+		domain.Date(parsedEndDate),
+		utils.PortfolioQuery(r))
 
 	if err != nil {
 		bh.l.Error("Failed to retrieve historic data", "error", err.Error())
@@ -114,3 +147,186 @@ func (bh *Handler) RetrieveHistoricDataHandler(w http.ResponseWriter, r *http.Re
 
 	w.Header().Set("Content-Type", "application/json")
 }
+
+// PerformanceHandler returns the time-weighted and money-weighted returns of the holdings
+// and of each ticker over the year to date, one year, three years and since inception
+func (bh *Handler) PerformanceHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	inputs, err := bh.repo.FindPerformanceInputs(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+	if err != nil {
+		bh.l.Error("Failed to retrieve performance data", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve performance data")
+		return
+	}
+
+	perf := performance.Compute(*inputs, time.Now())
+	if err := perf.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize performance", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize performance")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// RiskHandler returns the volatility, maximum drawdown, Sharpe and Sortino ratios and beta
+// of the holdings and of each ticker held. The riskFreeRate query parameter is an annual
+// rate, 0 by default, and beta is computed against the benchmark query parameter or, when
+// missing, the first benchmark of the user.
+func (bh *Handler) RiskHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	query := r.URL.Query()
+	riskFreeRate := 0.0
+	if value := query.Get("riskFreeRate"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= -1 {
+			utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid risk-free rate")
+			return
+		}
+		riskFreeRate = parsed
+	}
+
+	inputs, err := bh.repo.FindPerformanceInputs(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+	if err != nil {
+		bh.l.Error("Failed to retrieve risk data", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve risk data")
+		return
+	}
+
+	benchmark, err := bh.repo.FindBenchmarkPrices(user.Email, query.Get("benchmark"), inputs.Currency)
+	if err != nil {
+		bh.l.Error("Failed to retrieve benchmark prices", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve benchmark prices")
+		return
+	}
+
+	risk := performance.ComputeRisk(*inputs, benchmark, riskFreeRate)
+	if err := risk.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize risk", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize risk")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// AllocationHandler groups the value of the assets by the dimension of the by query
+// parameter, sector by default, and reports the drift from the targets of the user
+func (bh *Handler) AllocationHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	by, ok := allocationDimension(r)
+	if !ok {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid allocation dimension")
+		return
+	}
+
+	allocation, err := bh.repo.FindAllocation(user.Email, by, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+	if err != nil {
+		bh.l.Error("Failed to retrieve allocation", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve allocation")
+		return
+	}
+
+	if err := allocation.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize allocation", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize allocation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+func (bh *Handler) ListAllocationTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	by, ok := allocationDimension(r)
+	if !ok {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid allocation dimension")
+		return
+	}
+
+	targets, err := bh.repo.FindAllocationTargets(user.Email, by)
+	if err != nil {
+		bh.l.Error("Failed to retrieve allocation targets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve allocation targets")
+		return
+	}
+
+	if err := targets.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize allocation targets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize allocation targets")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdateAllocationTargetsHandler replaces the targets of the user for the dimension of the
+// by query parameter
+func (bh *Handler) UpdateAllocationTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	by, ok := allocationDimension(r)
+	if !ok {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid allocation dimension")
+		return
+	}
+
+	targets := domain.AllocationTargets{}
+	if err := targets.FromJSON(r.Body); err != nil {
+		bh.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := targets.Validate(); err != nil {
+		bh.l.Error("Invalid allocation targets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updatedTargets, err := bh.repo.UpdateAllocationTargets(targets, by, user.Email)
+	if err != nil {
+		bh.l.Error("Failed to update allocation targets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update allocation targets")
+		return
+	}
+
+	if err := updatedTargets.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize allocation targets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize allocation targets")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// allocationDimension returns the by query parameter, sector when missing
+func allocationDimension(r *http.Request) (string, bool) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		return domain.AllocationBySector, true
+	}
+	return by, slices.Contains(domain.AllocationDimensions, by)
+}
@@ -15,22 +15,29 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// tickersCache caches the benchmarks, the tickers package depends on auth for its handlers
+type tickersCache interface {
+	WriteToCache(ticker string) error
+}
+
 type Handler struct {
-	ur          domain.UserRepository
-	redirectUrl string
-	l           *slog.Logger
+	ur           domain.UserRepository
+	tickersCache tickersCache
+	redirectUrl  string
+	l            *slog.Logger
 }
 
-func New(ur domain.UserRepository, host string, logger *slog.Logger) *Handler {
+func New(ur domain.UserRepository, tickersCache tickersCache, host string, logger *slog.Logger) *Handler {
 	schema := "http://"
 	if utils.IsProdEnvironment() {
 		schema = "https://"
 	}
 
 	return &Handler{
-		ur:          ur,
-		redirectUrl: fmt.Sprintf("%s%s/auth/google/callback", schema, host),
-		l:           logger,
+		ur:           ur,
+		tickersCache: tickersCache,
+		redirectUrl:  fmt.Sprintf("%s%s/auth/google/callback", schema, host),
+		l:            logger,
 	}
 }
 
@@ -184,7 +191,9 @@ func (ah *Handler) UpdateUserPreferences(w http.ResponseWriter, r *http.Request)
 	}
 
 	var preferencesToUpdate struct {
-		PreferredCurrency string `json:"preferredCurrency" validate:"eq=$|eq=€|eq=£"`
+		PreferredCurrency string   `json:"preferredCurrency" validate:"omitempty,eq=$|eq=€|eq=£"`
+		CostBasisMethod   string   `json:"costBasisMethod" validate:"omitempty,oneof=fifo lifo weighted_average specific_lot section_104"`
+		Benchmarks        []string `json:"benchmarks" validate:"max=5,dive,required"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&preferencesToUpdate); err != nil {
@@ -200,7 +209,16 @@ func (ah *Handler) UpdateUserPreferences(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := ah.ur.UpdatePreferences(claims.User.Id, preferencesToUpdate.PreferredCurrency); err != nil {
+	// Benchmarks are compared with their cached price history
+	for _, benchmark := range preferencesToUpdate.Benchmarks {
+		if err := ah.tickersCache.WriteToCache(benchmark); err != nil {
+			ah.l.Error("Failed to write benchmark to cache", "benchmark", benchmark, "error", err.Error())
+			utils.SendHTTPMessage(w, http.StatusBadRequest, fmt.Sprintf("Benchmark %s not found", benchmark))
+			return
+		}
+	}
+
+	if err := ah.ur.UpdatePreferences(claims.User.Id, preferencesToUpdate.PreferredCurrency, preferencesToUpdate.CostBasisMethod, preferencesToUpdate.Benchmarks); err != nil {
 		ah.l.Error("Failed to update user preferences", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update user preferences")
 		return
@@ -1,11 +1,13 @@
 package buys
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/tickers"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/gorilla/mux"
@@ -50,6 +52,10 @@ func (bh *Handler) CreateBuyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	newBuy, err := bh.repo.Create(*buy, user.Email)
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		bh.l.Error("Failed to create buy", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create buy")
@@ -70,7 +76,7 @@ func (bh *Handler) ListBuysHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	buys, err := bh.repo.FindAll(user.Email)
+	buys, err := bh.repo.FindAll(user.Email, utils.PortfolioQuery(r))
 
 	if err != nil {
 		bh.l.Error("Failed to retrieve buys", "error", err.Error())
@@ -97,10 +103,75 @@ func (bh *Handler) DeleteBuyHandler(w http.ResponseWriter, r *http.Request) {
 		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
 		return
 	}
-	if err := bh.repo.Delete(id, user.Email); err != nil {
+	err := bh.repo.Delete(id, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The buy is required by later sells: "+err.Error())
+		return
+	}
+	if err != nil {
 		bh.l.Error("Failed to delete buy", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete buy")
 		return
 	}
 	utils.SendHTTPMessage(w, http.StatusOK, "Buy deleted successfully")
 }
+
+func (bh *Handler) UpdateBuyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	buy := &domain.Buy{}
+	if err := buy.FromJSON(r.Body); err != nil {
+		bh.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := buy.Validate(); err != nil {
+		bh.l.Error("Invalid buy", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := bh.tickersCache.WriteToCache(buy.Ticker); err != nil {
+		bh.l.Error("Failed to write ticker to cache", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to process ticker information")
+		return
+	}
+
+	updatedBuy, err := bh.repo.Update(id, *buy, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The buy is required by later sells: "+err.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		bh.l.Error("Failed to update buy", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update buy")
+		return
+	}
+
+	if updatedBuy == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Buy not found")
+		return
+	}
+
+	if err := updatedBuy.ToJSON(w); err != nil {
+		bh.l.Error("Failed to serialize buy", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize buy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
@@ -0,0 +1,175 @@
+package cash
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	repository domain.CashRepository
+	l          *slog.Logger
+}
+
+func New(repository domain.CashRepository, logger *slog.Logger) *Handler {
+	return &Handler{
+		repository: repository,
+		l:          logger,
+	}
+}
+
+// CreateCashTransactionHandler creates a new cash transaction
+func (h *Handler) CreateCashTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	transaction := &domain.CashTransaction{}
+	if err := transaction.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := transaction.Validate(); err != nil {
+		h.l.Error("Invalid cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	newTransaction, err := h.repository.Create(*transaction, user.Email)
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to create cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create cash transaction")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := newTransaction.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize cash transaction", "error", err.Error())
+	}
+}
+
+// ListCashTransactionsHandler returns all the cash transactions of the user
+func (h *Handler) ListCashTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	transactions, err := h.repository.FindAll(user.Email, utils.PortfolioQuery(r))
+	if err != nil {
+		h.l.Error("Failed to get cash transactions", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get cash transactions")
+		return
+	}
+
+	if err := transactions.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize cash transactions", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize cash transactions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// ListBalancesHandler returns the balance of the cash account of every currency
+func (h *Handler) ListBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	balances, err := h.repository.FindBalances(user.Email, utils.PortfolioQuery(r))
+	if err != nil {
+		h.l.Error("Failed to get cash balances", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get cash balances")
+		return
+	}
+
+	if err := balances.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize cash balances", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize cash balances")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdateCashTransactionHandler replaces all the fields of a cash transaction
+func (h *Handler) UpdateCashTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	transaction := &domain.CashTransaction{}
+	if err := transaction.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := transaction.Validate(); err != nil {
+		h.l.Error("Invalid cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updatedTransaction, err := h.repository.Update(id, *transaction, user.Email)
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to update cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update cash transaction")
+		return
+	}
+
+	if updatedTransaction == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Cash transaction not found")
+		return
+	}
+
+	if err := updatedTransaction.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize cash transaction")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// DeleteCashTransactionHandler deletes a cash transaction
+func (h *Handler) DeleteCashTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	if err := h.repository.Delete(id, user.Email); err != nil {
+		h.l.Error("Failed to delete cash transaction", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete cash transaction")
+		return
+	}
+
+	utils.SendHTTPMessage(w, http.StatusOK, "Cash transaction deleted successfully")
+}
@@ -0,0 +1,186 @@
+package corporateactions
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/tickers"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	repository   domain.CorporateActionsRepository
+	tickersCache *tickers.CacheManager
+	l            *slog.Logger
+}
+
+func New(repository domain.CorporateActionsRepository, tickersCache *tickers.CacheManager, logger *slog.Logger) *Handler {
+	return &Handler{
+		repository:   repository,
+		tickersCache: tickersCache,
+		l:            logger,
+	}
+}
+
+// CreateCorporateActionHandler creates a new corporate action
+func (h *Handler) CreateCorporateActionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	action := &domain.CorporateAction{}
+	if err := action.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := action.Validate(); err != nil {
+		h.l.Error("Invalid corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.cacheNewTicker(w, *action) {
+		return
+	}
+
+	newAction, err := h.repository.Create(*action, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to create corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create corporate action")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := newAction.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize corporate action", "error", err.Error())
+	}
+}
+
+// ListCorporateActionsHandler returns all the corporate actions of the user
+func (h *Handler) ListCorporateActionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	actions, err := h.repository.FindAll(user.Email)
+	if err != nil {
+		h.l.Error("Failed to get corporate actions", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get corporate actions")
+		return
+	}
+
+	if err := actions.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize corporate actions", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize corporate actions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdateCorporateActionHandler replaces all the fields of a corporate action
+func (h *Handler) UpdateCorporateActionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	action := &domain.CorporateAction{}
+	if err := action.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := action.Validate(); err != nil {
+		h.l.Error("Invalid corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.cacheNewTicker(w, *action) {
+		return
+	}
+
+	updatedAction, err := h.repository.Update(id, *action, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to update corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update corporate action")
+		return
+	}
+
+	if updatedAction == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Corporate action not found")
+		return
+	}
+
+	if err := updatedAction.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize corporate action")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// DeleteCorporateActionHandler deletes a corporate action
+func (h *Handler) DeleteCorporateActionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	err := h.repository.Delete(id, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to delete corporate action", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete corporate action")
+		return
+	}
+
+	utils.SendHTTPMessage(w, http.StatusOK, "Corporate action deleted successfully")
+}
+
+// cacheNewTicker stores the information of the ticker receiving the lots, so the assets
+// can be valued right away. Returns false when the response has already been sent.
+func (h *Handler) cacheNewTicker(w http.ResponseWriter, action domain.CorporateAction) bool {
+	if action.NewTicker == "" {
+		return true
+	}
+
+	if err := h.tickersCache.WriteToCache(action.NewTicker); err != nil {
+		h.l.Error("Failed to write ticker to cache", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to process ticker information")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package dividends
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// ForecastMonths is the number of months projected, starting with the current one
+const ForecastMonths = 12
+
+// defaultPaymentsPerYear is assumed for the holdings without past dividends
+const defaultPaymentsPerYear = 4
+
+// BuildForecast projects the dividends of the holdings month by month. The yearly
+// dividend of each ticker is split in the payments seen in the year before its last
+// dividend, falling in the same months, and the withholding rates are the average of the
+// ones of its past dividends, or of the dividends from the same country.
+func BuildForecast(assets domain.Assets, history domain.Dividends, currency string, from time.Time) domain.DividendForecast {
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	forecast := domain.DividendForecast{
+		Currency: currency,
+		Months:   make([]domain.MonthDividendForecast, ForecastMonths),
+		Tickers:  []domain.TickerDividendForecast{},
+	}
+	for i := range forecast.Months {
+		forecast.Months[i] = domain.MonthDividendForecast{
+			Month:   start.AddDate(0, i, 0).Format("2006-01"),
+			Tickers: []domain.TickerMonthDividendForecast{},
+		}
+	}
+
+	byTicker := map[string]domain.Dividends{}
+	byCountry := map[string]domain.Dividends{}
+	for _, d := range history {
+		if d.Status == domain.DividendPending {
+			continue
+		}
+		byTicker[d.Company] = append(byTicker[d.Company], d)
+		byCountry[d.Country] = append(byCountry[d.Country], d)
+	}
+
+	for _, asset := range assets {
+		yearlyValue := asset.Units * asset.Ticker.YearlyDividendValue
+		if yearlyValue <= 0 {
+			continue
+		}
+
+		tickerHistory := byTicker[asset.Ticker.Ticker]
+		months := paymentMonths(tickerHistory)
+		if len(months) == 0 {
+			months = assumedPaymentMonths(asset.Ticker, start)
+		}
+
+		rates := tickerHistory
+		if len(rates) == 0 {
+			rates = byCountry[asset.Country]
+		}
+		origin, destination := averageWithholding(rates)
+
+		tf := domain.TickerDividendForecast{
+			Ticker:                    asset.Ticker.Ticker,
+			Name:                      asset.Name,
+			Units:                     asset.Units,
+			PaymentsPerYear:           len(months),
+			DoubleTaxationOrigin:      origin,
+			DoubleTaxationDestination: destination,
+		}
+
+		gross := yearlyValue / float32(len(months))
+		net := gross * (1 - origin/100) * (1 - destination/100)
+		payment := domain.DividendForecastAmounts{Gross: gross, Taxes: gross - net, Net: net}
+		for i := range forecast.Months {
+			month := &forecast.Months[i]
+			if !months[start.AddDate(0, i, 0).Month()] {
+				continue
+			}
+			month.Tickers = append(month.Tickers, domain.TickerMonthDividendForecast{
+				Ticker:                  asset.Ticker.Ticker,
+				DividendForecastAmounts: payment,
+			})
+			addForecastAmounts(&month.DividendForecastAmounts, payment)
+			addForecastAmounts(&tf.DividendForecastAmounts, payment)
+			addForecastAmounts(&forecast.Totals, payment)
+		}
+		forecast.Tickers = append(forecast.Tickers, tf)
+	}
+
+	sort.SliceStable(forecast.Tickers, func(i, j int) bool {
+		return forecast.Tickers[i].Net > forecast.Tickers[j].Net
+	})
+	return forecast
+}
+
+func addForecastAmounts(totals *domain.DividendForecastAmounts, amounts domain.DividendForecastAmounts) {
+	totals.Gross += amounts.Gross
+	totals.Taxes += amounts.Taxes
+	totals.Net += amounts.Net
+}
+
+// paymentMonths returns the months a ticker paid dividends in the year up to its last
+// dividend, so the cuts or changes of frequency of older years are left out
+func paymentMonths(history domain.Dividends) map[time.Month]bool {
+	if len(history) == 0 {
+		return nil
+	}
+
+	last := time.Time(history[0].Date)
+	for _, d := range history {
+		if time.Time(d.Date).After(last) {
+			last = time.Time(d.Date)
+		}
+	}
+
+	yearAgo := last.AddDate(-1, 0, 0)
+	months := map[time.Month]bool{}
+	for _, d := range history {
+		if time.Time(d.Date).After(yearAgo) {
+			months[time.Time(d.Date).Month()] = true
+		}
+	}
+	return months
+}
+
+// assumedPaymentMonths spreads the default payments over the year starting with the month
+// of the next payment announced for the ticker, or with the current month
+func assumedPaymentMonths(ticker domain.Ticker, start time.Time) map[time.Month]bool {
+	first := start.Month()
+	if ticker.DividendPaymentDate != nil {
+		first = time.Time(*ticker.DividendPaymentDate).Month()
+	} else if ticker.ExDividendDate != nil {
+		first = time.Time(*ticker.ExDividendDate).Month()
+	}
+
+	months := map[time.Month]bool{}
+	step := 12 / defaultPaymentsPerYear
+	for i := 0; i < defaultPaymentsPerYear; i++ {
+		months[time.Month((int(first)-1+i*step)%12+1)] = true
+	}
+	return months
+}
+
+// averageWithholding returns the average rates withheld at origin and destination
+func averageWithholding(dividends domain.Dividends) (float32, float32) {
+	if len(dividends) == 0 {
+		return 0, 0
+	}
+
+	var origin, destination float32
+	for _, d := range dividends {
+		origin += d.DoubleTaxationOrigin
+		destination += d.DoubleTaxationDestination
+	}
+	n := float32(len(dividends))
+	return origin / n, destination / n
+}
@@ -0,0 +1,131 @@
+package dividends
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// DividendCutTolerance is how much less per share a payment can pay than the one of a year
+// before without being flagged as a cut
+const DividendCutTolerance = 0.05
+
+// comparableDays is how far from a year before the payment compared with can be
+const comparableDays = 45
+
+// BuildGrowth groups the payments per ticker and computes the dividends per share of each
+// year, their growth rates up to the last complete year and the yield on cost over time
+func BuildGrowth(payments domain.PaidDividends, currency string, now time.Time) domain.DividendGrowth {
+	growth := domain.DividendGrowth{
+		Currency: currency,
+		Tickers:  []domain.TickerDividendGrowth{},
+	}
+
+	byTicker := map[string]domain.PaidDividends{}
+	tickers := []string{}
+	for _, p := range payments {
+		if _, present := byTicker[p.Ticker]; !present {
+			tickers = append(tickers, p.Ticker)
+		}
+		byTicker[p.Ticker] = append(byTicker[p.Ticker], p)
+	}
+	sort.Strings(tickers)
+
+	for _, ticker := range tickers {
+		growth.Tickers = append(growth.Tickers, tickerGrowth(ticker, byTicker[ticker], now))
+	}
+	return growth
+}
+
+func tickerGrowth(ticker string, payments domain.PaidDividends, now time.Time) domain.TickerDividendGrowth {
+	sort.SliceStable(payments, func(i, j int) bool {
+		return time.Time(payments[i].Date).Before(time.Time(payments[j].Date))
+	})
+
+	tg := domain.TickerDividendGrowth{
+		Ticker:   ticker,
+		Years:    []domain.DividendYear{},
+		Payments: make([]domain.DividendGrowthPayment, len(payments)),
+	}
+
+	for i, p := range payments {
+		tg.Payments[i] = domain.DividendGrowthPayment{PaidDividend: p}
+		if p.Units > 0 {
+			tg.Payments[i].DividendPerShare = p.Amount / p.Units
+		}
+	}
+
+	byYear := map[int]int{}
+	for i := range tg.Payments {
+		gp := &tg.Payments[i]
+		date := time.Time(gp.Date)
+
+		var trailing float32
+		for _, other := range tg.Payments[:i+1] {
+			if time.Time(other.Date).After(date.AddDate(-1, 0, 0)) {
+				trailing += other.DividendPerShare
+			}
+		}
+		if gp.CostBasis > 0 {
+			gp.YieldOnCost = trailing / (gp.CostBasis / gp.Units)
+		}
+
+		if previous := paymentAYearBefore(tg.Payments[:i], date); previous != nil {
+			gp.Cut = gp.DividendPerShare < previous.DividendPerShare*(1-DividendCutTolerance)
+		}
+
+		y, present := byYear[date.Year()]
+		if !present {
+			y = len(tg.Years)
+			byYear[date.Year()] = y
+			tg.Years = append(tg.Years, domain.DividendYear{Year: date.Year()})
+		}
+		year := &tg.Years[y]
+		year.Payments++
+		year.Amount += gp.Amount
+		year.DividendPerShare += gp.DividendPerShare
+	}
+
+	if len(tg.Payments) > 0 {
+		tg.Cut = tg.Payments[len(tg.Payments)-1].Cut
+	}
+
+	lastYear := now.Year() - 1
+	perShare := map[int]float32{}
+	for _, y := range tg.Years {
+		perShare[y.Year] = y.DividendPerShare
+	}
+	tg.CAGR1Y = cagr(perShare, lastYear, 1)
+	tg.CAGR3Y = cagr(perShare, lastYear, 3)
+	tg.CAGR5Y = cagr(perShare, lastYear, 5)
+	return tg
+}
+
+// paymentAYearBefore returns the payment closest to a year before the date, if any is
+// close enough to be comparable
+func paymentAYearBefore(payments []domain.DividendGrowthPayment, date time.Time) *domain.DividendGrowthPayment {
+	target := date.AddDate(-1, 0, 0)
+	var closest *domain.DividendGrowthPayment
+	closestDays := float64(comparableDays)
+	for i := range payments {
+		days := math.Abs(time.Time(payments[i].Date).Sub(target).Hours() / 24)
+		if days <= closestDays && payments[i].DividendPerShare > 0 {
+			closest = &payments[i]
+			closestDays = days
+		}
+	}
+	return closest
+}
+
+// cagr returns the compound annual growth rate of the dividends per share over the years
+// ending in the last one
+func cagr(perShare map[int]float32, last int, years int) *float32 {
+	end, start := perShare[last], perShare[last-years]
+	if end <= 0 || start <= 0 {
+		return nil
+	}
+	rate := float32(math.Pow(float64(end/start), 1/float64(years)) - 1)
+	return &rate
+}
@@ -1,30 +1,28 @@
 package dividends
 
 import (
-	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/gorilla/mux"
-	"github.com/judedaryl/go-arrayutils"
 )
 
-type updateDividend struct {
-	ID         string `json:"id"`
-	Reinvested bool   `json:"reinvested"`
-}
-
 type Handler struct {
 	repository domain.DividendsRepository
+	assetsRepo domain.AssetsRepository
 	l          *slog.Logger
 }
 
-func New(repository domain.DividendsRepository, logger *slog.Logger) *Handler {
+func New(repository domain.DividendsRepository, assetsRepo domain.AssetsRepository, logger *slog.Logger) *Handler {
 	return &Handler{
 		repository: repository,
+		assetsRepo: assetsRepo,
 		l:          logger,
 	}
 }
@@ -49,6 +47,10 @@ func (dh *Handler) CreateDividendHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	newDividend, err := dh.repository.Create(*dividend, user.Email)
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		dh.l.Error("Failed to create dividend", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create dividend")
@@ -70,7 +72,7 @@ func (dh *Handler) ListDividendsHandler(w http.ResponseWriter, r *http.Request)
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	dividends, err := dh.repository.FindAll(user.Email)
+	dividends, err := dh.repository.FindAll(user.Email, utils.PortfolioQuery(r))
 	if err != nil {
 		dh.l.Error("Failed to get dividends", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get dividends")
@@ -91,7 +93,7 @@ func (dh *Handler) ListPreferredCurrencyDividendsHandler(w http.ResponseWriter,
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	dividends, err := dh.repository.FindAllPreferredCurrency(user.Email)
+	dividends, err := dh.repository.FindAllPreferredCurrency(user.Email, utils.PortfolioQuery(r))
 	if err != nil {
 		dh.l.Error("Failed to get dividends", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get dividends")
@@ -120,6 +122,10 @@ func (dh *Handler) DeleteDividendHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	err := dh.repository.Delete(id, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The reinvestment buy is required by later sells: "+err.Error())
+		return
+	}
 	if err != nil {
 		dh.l.Error("Failed to delete dividend", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete dividend")
@@ -129,47 +135,244 @@ func (dh *Handler) DeleteDividendHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
-// UpdateDividendsHandler updates the reinvestment status of the dividends
-func (dh *Handler) UpdateDividendsHandler(w http.ResponseWriter, r *http.Request) {
+// UpdateDividendHandler replaces all the fields of a dividend
+func (dh *Handler) UpdateDividendHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	var dividends []updateDividend
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&dividends); err != nil {
+	dividend := &domain.Dividend{}
+	if err := dividend.FromJSON(r.Body); err != nil {
 		dh.l.Error("Failed to parse request body", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
 		return
 	}
+	defer r.Body.Close()
+
+	if err := dividend.Validate(); err != nil {
+		dh.l.Error("Invalid dividend", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	updatedDividend, err := dh.repository.Update(id, *dividend, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The reinvestment buy is required by later sells: "+err.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		dh.l.Error("Failed to update dividend", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update dividend")
+		return
+	}
+
+	if updatedDividend == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Dividend not found")
+		return
+	}
+
+	if err := updatedDividend.ToJSON(w); err != nil {
+		dh.l.Error("Failed to serialize dividend", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize dividend")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// ConfirmDividendHandler sets the real figures of a pending dividend
+func (dh *Handler) ConfirmDividendHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	confirmation := &domain.DividendConfirmation{}
+	if err := confirmation.FromJSON(r.Body); err != nil {
+		dh.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
 	defer r.Body.Close()
 
-	markAsTrue := arrayutils.Filter(dividends, func(d updateDividend) bool {
-		return d.Reinvested
-	})
-	markAsTrueIDs := arrayutils.Map(markAsTrue, func(d updateDividend) string {
-		return d.ID
-	})
-
-	markAsFalse := arrayutils.Filter(dividends, func(d updateDividend) bool {
-		return !d.Reinvested
-	})
-	markAsFalseIDs := arrayutils.Map(markAsFalse, func(d updateDividend) string {
-		return d.ID
-	})
-
-	errTrue := dh.repository.UpdateDividends(user.Email, markAsTrueIDs, true)
-	if errTrue != nil {
-		dh.l.Error("Failed to update dividends", "error", errTrue.Error())
-		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update dividends")
+	if err := confirmation.Validate(); err != nil {
+		dh.l.Error("Invalid dividend confirmation", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	confirmedDividend, err := dh.repository.Confirm(id, *confirmation, user.Email)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The reinvestment buy is required by later sells: "+err.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrDividendNotPending) {
+		utils.SendHTTPMessage(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		dh.l.Error("Failed to confirm dividend", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to confirm dividend")
+		return
+	}
+
+	if confirmedDividend == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Dividend not found")
+		return
+	}
+
+	if err := confirmedDividend.ToJSON(w); err != nil {
+		dh.l.Error("Failed to serialize dividend", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize dividend")
 		return
 	}
-	errFalse := dh.repository.UpdateDividends(user.Email, markAsFalseIDs, false)
-	if errFalse != nil {
-		dh.l.Error("Failed to update dividends", "error", errFalse.Error())
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdateDividendsHandler updates the reinvestment status of the dividends, creating the
+// reinvestment buys when the units and price are given
+func (dh *Handler) UpdateDividendsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	reinvestments := domain.DividendReinvestments{}
+	if err := reinvestments.FromJSON(r.Body); err != nil {
+		dh.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := reinvestments.Validate(); err != nil {
+		dh.l.Error("Invalid dividend reinvestments", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := dh.repository.UpdateDividends(user.Email, reinvestments)
+	if errors.Is(err, sells.ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The reinvestment buy is required by later sells: "+err.Error())
+		return
+	}
+	if err != nil {
+		dh.l.Error("Failed to update dividends", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update dividends")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// ListOrphanedReinvestmentsHandler returns the reinvestment buys and reinvested dividends
+// that are no longer linked to each other
+func (dh *Handler) ListOrphanedReinvestmentsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	orphans, err := dh.repository.FindOrphanedReinvestments(user.Email)
+	if err != nil {
+		dh.l.Error("Failed to get orphaned reinvestments", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get orphaned reinvestments")
+		return
+	}
+
+	if err := orphans.ToJSON(w); err != nil {
+		dh.l.Error("Failed to serialize orphaned reinvestments", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize orphaned reinvestments")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// ForecastDividendsHandler projects the dividend income of the current holdings for the
+// next twelve months
+func (dh *Handler) ForecastDividendsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	assets, err := dh.assetsRepo.FindAll(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+	if err != nil {
+		dh.l.Error("Failed to retrieve assets", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve assets")
+		return
+	}
+
+	// The payment frequency of a ticker does not depend on the portfolio it is held in
+	history, err := dh.repository.FindAll(user.Email, nil)
+	if err != nil {
+		dh.l.Error("Failed to get dividends", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get dividends")
+		return
+	}
+
+	currency := ""
+	if len(assets) > 0 {
+		currency = assets[0].Currency
+	} else if user.PreferredCurrency != nil {
+		currency = *user.PreferredCurrency
+	}
+
+	forecast := BuildForecast(assets, history, currency, time.Now())
+	if err := forecast.ToJSON(w); err != nil {
+		dh.l.Error("Failed to serialize dividend forecast", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize dividend forecast")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// DividendGrowthHandler returns the growth of the dividends per share and the yield on
+// cost of every ticker that paid dividends
+func (dh *Handler) DividendGrowthHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	payments, err := dh.repository.FindPayments(user.Email, utils.PortfolioQuery(r))
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+	if err != nil {
+		dh.l.Error("Failed to get dividend payments", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get dividend payments")
+		return
+	}
+
+	currency := ""
+	if len(payments) > 0 {
+		currency = payments[0].Currency
+	} else if user.PreferredCurrency != nil {
+		currency = *user.PreferredCurrency
+	}
+
+	growth := BuildGrowth(payments, currency, time.Now())
+	if err := growth.ToJSON(w); err != nil {
+		dh.l.Error("Failed to serialize dividend growth", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize dividend growth")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
@@ -13,3 +13,121 @@ const (
 	DividendPayment string = "Dividend Payment"
 	Earning         string = "Earning"
 )
+
+// Transaction types
+const (
+	BuyTransaction      string = "buy"
+	SellTransaction     string = "sell"
+	DividendTransaction string = "dividend"
+)
+
+// Corporate action types
+const (
+	Split           string = "split"
+	SymbolChange    string = "symbol_change"
+	Merger          string = "merger"
+	CashAcquisition string = "cash_acquisition"
+	SpinOff         string = "spin_off"
+)
+
+// Cash transaction types
+const (
+	Deposit      string = "deposit"
+	Withdrawal   string = "withdrawal"
+	Interest     string = "interest"
+	Fee          string = "fee"
+	FXConversion string = "fx_conversion"
+)
+
+// Cost basis methods, used to match the units of a sell with the buys they come from
+const (
+	FIFO            string = "fifo"
+	LIFO            string = "lifo"
+	WeightedAverage string = "weighted_average"
+	SpecificLot     string = "specific_lot"
+	Section104      string = "section_104"
+)
+
+// Dividend statuses. Pending dividends are estimated from the ticker data and wait for the
+// user to confirm the amounts received.
+const (
+	DividendPending   string = "pending"
+	DividendConfirmed string = "confirmed"
+)
+
+// Holding periods of a realized gain. Lots held for more than a year are long term.
+const (
+	ShortTerm string = "short_term"
+	LongTerm  string = "long_term"
+)
+
+// Dimensions the value of the assets can be allocated by
+const (
+	AllocationBySector   string = "sector"
+	AllocationByCountry  string = "country"
+	AllocationByIndustry string = "industry"
+	AllocationByCurrency string = "currency"
+	AllocationByType     string = "type"
+)
+
+// AllocationDimensions are the dimensions an allocation can be requested by
+var AllocationDimensions = []string{
+	AllocationBySector,
+	AllocationByCountry,
+	AllocationByIndustry,
+	AllocationByCurrency,
+	AllocationByType,
+}
+
+// Asset types of the allocation by type
+const (
+	StockAsset string = "stock"
+	EtfAsset   string = "etf"
+)
+
+// UnknownAllocationKey groups the assets without a value for the dimension
+const UnknownAllocationKey string = "Unknown"
+
+// Market data providers, in the default order of the chain
+const (
+	TickerInfoApiProvider string = "ticker_info_api"
+	YahooProvider         string = "yahoo"
+	FileProvider          string = "file"
+	ManualProvider        string = "manual"
+)
+
+var MarketDataProviders = []string{TickerInfoApiProvider, YahooProvider, FileProvider, ManualProvider}
+
+// Intervals of the price history of a ticker
+const (
+	PriceIntervalDay   string = "day"
+	PriceIntervalWeek  string = "week"
+	PriceIntervalMonth string = "month"
+)
+
+var PriceIntervals = []string{PriceIntervalDay, PriceIntervalWeek, PriceIntervalMonth}
+
+// DefaultTreatyRate caps the deduction of the countries missing in the treaty rates
+const DefaultTreatyRate float32 = 15
+
+// DefaultTreatyRates are the withholding rates at source of the double taxation treaties
+// signed by Spain, the maximum tax withheld abroad that can be deducted
+var DefaultTreatyRates = map[string]float32{
+	"US":             15,
+	"United Kingdom": 10,
+	"Ireland":        15,
+	"France":         15,
+	"Germany":        15,
+	"Netherlands":    15,
+	"Belgium":        15,
+	"Italy":          15,
+	"Portugal":       15,
+	"Switzerland":    15,
+	"Denmark":        15,
+	"Sweden":         15,
+	"Norway":         15,
+	"Finland":        15,
+	"Canada":         15,
+	"Japan":          15,
+	"Australia":      15,
+}
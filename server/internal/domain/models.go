@@ -1,8 +1,13 @@
 package domain
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 )
@@ -18,7 +23,12 @@ type Buy struct {
 	Amount         float32 `json:"amount" validate:"gte=0"`
 	Currency       string  `json:"currency" validate:"required,eq=$|eq=€|eq=£"`
 	IsReinvestment bool    `json:"isDividendReinvestment"`
+	UseCashAccount bool    `json:"useCashAccount"`
 	Date           Date    `json:"date" validate:"required"`
+	// PortfolioId defaults to the default portfolio of the user when empty
+	PortfolioId string `json:"portfolioId,omitempty"`
+	// DividendId is set when the buy reinvests a dividend
+	DividendId string `json:"dividendId,omitempty"`
 }
 
 func (b Buy) ToJSON(w io.Writer) error {
@@ -64,6 +74,18 @@ type Sell struct {
 	Date             Date    `json:"date" validate:"required"`
 	Fees             float32 `json:"fees" validate:"gte=0"`
 	AccumulatedFees  float32 `json:"accumulatedFees" validate:"gte=0"`
+	UseCashAccount   bool    `json:"useCashAccount"`
+	// CorporateActionId is set when the sell closes a position acquired for cash
+	CorporateActionId string `json:"corporateActionId,omitempty"`
+	PortfolioId       string `json:"portfolioId,omitempty"`
+	// Lots are the buys the units are taken from with the specific lot identification
+	// method. Units not covered by them are matched following the FIFO rule.
+	Lots []SellLot `json:"lots,omitempty" validate:"dive"`
+}
+
+type SellLot struct {
+	BuyId string  `json:"buyId" validate:"required"`
+	Units float32 `json:"units" validate:"gt=0"`
 }
 
 func (s Sell) ToJSON(w io.Writer) error {
@@ -108,7 +130,11 @@ type Dividend struct {
 	DoubleTaxationOrigin      float32 `json:"doubleTaxationOrigin" validate:"gte=0"`
 	DoubleTaxationDestination float32 `json:"doubleTaxationDestination" validate:"gte=0"`
 	IsReinvested              bool    `json:"isReinvested"`
+	UseCashAccount            bool    `json:"useCashAccount"`
 	Date                      Date    `json:"date" validate:"required"`
+	PortfolioId               string  `json:"portfolioId,omitempty"`
+	Status                    string  `json:"status,omitempty" validate:"omitempty,oneof=pending confirmed"`
+	ExDividendDate            *Date   `json:"exDividendDate,omitempty"`
 }
 
 func (d Dividend) ToJSON(w io.Writer) error {
@@ -127,6 +153,165 @@ func (d Dividend) Validate() error {
 	return validate.Struct(d)
 }
 
+// DividendConfirmation holds the real figures of a pending dividend once it is paid
+type DividendConfirmation struct {
+	Amount                    float32 `json:"amount" validate:"required,gt=0"`
+	DoubleTaxationOrigin      float32 `json:"doubleTaxationOrigin" validate:"gte=0"`
+	DoubleTaxationDestination float32 `json:"doubleTaxationDestination" validate:"gte=0"`
+	IsReinvested              bool    `json:"isReinvested"`
+	UseCashAccount            bool    `json:"useCashAccount"`
+	// Date is the payment date, the estimated one is kept when missing
+	Date *Date `json:"date"`
+}
+
+func (c *DividendConfirmation) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&c)
+}
+
+func (c DividendConfirmation) Validate() error {
+	validate = validator.New()
+	return validate.Struct(c)
+}
+
+// DividendReinvestment marks a dividend as reinvested or not. When the units and price
+// are given, the buy of the reinvested shares is created with it.
+type DividendReinvestment struct {
+	Id         string  `json:"id" validate:"required"`
+	Reinvested bool    `json:"reinvested"`
+	Units      float32 `json:"units,omitempty" validate:"gte=0,required_with=Price"`
+	Price      float32 `json:"price,omitempty" validate:"gte=0,required_with=Units"`
+}
+
+type DividendReinvestments []DividendReinvestment
+
+func (drs *DividendReinvestments) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&drs)
+}
+
+func (drs DividendReinvestments) Validate() error {
+	validate = validator.New()
+	for _, dr := range drs {
+		if err := validate.Struct(dr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DividendForecastAmounts are the dividends expected, the taxes withheld from them and
+// what is left
+type DividendForecastAmounts struct {
+	Gross float32 `json:"gross"`
+	Taxes float32 `json:"taxes"`
+	Net   float32 `json:"net"`
+}
+
+// TickerDividendForecast is the income expected from a holding over the forecast, with
+// the payment frequency and withholding rates it is estimated with
+type TickerDividendForecast struct {
+	Ticker                    string  `json:"ticker"`
+	Name                      string  `json:"name"`
+	Units                     float32 `json:"units"`
+	PaymentsPerYear           int     `json:"paymentsPerYear"`
+	DoubleTaxationOrigin      float32 `json:"doubleTaxationOrigin"`
+	DoubleTaxationDestination float32 `json:"doubleTaxationDestination"`
+	DividendForecastAmounts
+}
+
+type TickerMonthDividendForecast struct {
+	Ticker string `json:"ticker"`
+	DividendForecastAmounts
+}
+
+// MonthDividendForecast is the income expected in a month (YYYY-MM)
+type MonthDividendForecast struct {
+	Month   string                        `json:"month"`
+	Tickers []TickerMonthDividendForecast `json:"tickers"`
+	DividendForecastAmounts
+}
+
+type DividendForecast struct {
+	Currency string                   `json:"currency"`
+	Months   []MonthDividendForecast  `json:"months"`
+	Tickers  []TickerDividendForecast `json:"tickers"`
+	Totals   DividendForecastAmounts  `json:"totals"`
+}
+
+func (f DividendForecast) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(f)
+}
+
+// PaidDividend is a dividend received together with the units it was paid for and the
+// cost basis of those units
+type PaidDividend struct {
+	DividendId string  `json:"dividendId"`
+	Ticker     string  `json:"ticker"`
+	Date       Date    `json:"date"`
+	Currency   string  `json:"currency"`
+	Amount     float32 `json:"amount"`
+	Units      float32 `json:"units"`
+	CostBasis  float32 `json:"costBasis"`
+}
+
+type PaidDividends []PaidDividend
+
+// DividendYear adds up the dividends of a ticker paid in a calendar year
+type DividendYear struct {
+	Year             int     `json:"year"`
+	Payments         int     `json:"payments"`
+	Amount           float32 `json:"amount"`
+	DividendPerShare float32 `json:"dividendPerShare"`
+}
+
+// DividendGrowthPayment is a payment with the yield on cost of the trailing twelve months
+// dividends per share at its date. Cut is set when it pays less per share than the payment
+// of a year before.
+type DividendGrowthPayment struct {
+	PaidDividend
+	DividendPerShare float32 `json:"dividendPerShare"`
+	YieldOnCost      float32 `json:"yieldOnCost"`
+	Cut              bool    `json:"cut"`
+}
+
+// TickerDividendGrowth holds the growth of the dividends per share of a ticker. The growth
+// rates are null when there are no dividends in the years compared.
+type TickerDividendGrowth struct {
+	Ticker   string                  `json:"ticker"`
+	Years    []DividendYear          `json:"years"`
+	CAGR1Y   *float32                `json:"cagr1Y"`
+	CAGR3Y   *float32                `json:"cagr3Y"`
+	CAGR5Y   *float32                `json:"cagr5Y"`
+	Payments []DividendGrowthPayment `json:"payments"`
+	Cut      bool                    `json:"cut"`
+}
+
+type DividendGrowth struct {
+	Currency string                 `json:"currency"`
+	Tickers  []TickerDividendGrowth `json:"tickers"`
+}
+
+func (g DividendGrowth) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(g)
+}
+
+// OrphanedReinvestments are the reinvestment buys whose dividend is gone or no longer
+// reinvested, and the reinvested dividends without a buy
+type OrphanedReinvestments struct {
+	Buys      Buys      `json:"buys"`
+	Dividends Dividends `json:"dividends"`
+}
+
+func (o OrphanedReinvestments) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(o)
+}
+
 type DividendWithId struct {
 	Id         string            `json:"id"`
 	TickerData *SimplifiedTicker `json:"tickerData,omitempty"`
@@ -150,6 +335,9 @@ type User struct {
 	Name              string  `json:"name"`
 	Picture           string  `json:"picture"`
 	PreferredCurrency *string `json:"preferredCurrency"`
+	CostBasisMethod   string  `json:"costBasisMethod,omitempty"`
+	// Tickers the historic of the holdings is compared against
+	Benchmarks []string `json:"benchmarks,omitempty"`
 }
 
 type UserWithId struct {
@@ -212,6 +400,35 @@ type PriceRange struct {
 type HistoricalEntry struct {
 	Date  Date    `json:"date"`
 	Price float32 `json:"price"`
+	// The daily bar, when the provider has it, the price being its close
+	Open     float32 `json:"open,omitempty"`
+	High     float32 `json:"high,omitempty"`
+	Low      float32 `json:"low,omitempty"`
+	AdjClose float32 `json:"adj_close,omitempty"`
+	Volume   int64   `json:"volume,omitempty"`
+}
+
+// PriceBar is the price of a ticker over a day, a week or a month, dated its first day
+type PriceBar struct {
+	Date     Date    `json:"date"`
+	Open     float32 `json:"open"`
+	High     float32 `json:"high"`
+	Low      float32 `json:"low"`
+	Close    float32 `json:"close"`
+	AdjClose float32 `json:"adjClose"`
+	Volume   int64   `json:"volume"`
+}
+
+type PriceHistory struct {
+	Ticker   string     `json:"ticker"`
+	Currency string     `json:"currency"`
+	Interval string     `json:"interval"`
+	Prices   []PriceBar `json:"prices"`
+}
+
+func (h PriceHistory) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(h)
 }
 
 type Ticker struct {
@@ -235,6 +452,63 @@ type Ticker struct {
 	MonthlyPriceRange   PriceRange        `json:"monthly_price_range"`
 	YearlyPriceRange    PriceRange        `json:"yearly_price_range"`
 	HistoricalData      []HistoricalEntry `json:"historical_data"`
+	Source              string            `json:"source,omitempty"`
+	Isin                string            `json:"isin,omitempty"`
+	Exchange            string            `json:"exchange,omitempty"`
+}
+
+// TickerSearchResult is a ticker matching a search, Cached when its data is already stored
+type TickerSearchResult struct {
+	Ticker   string `json:"ticker"`
+	Name     string `json:"name"`
+	Isin     string `json:"isin,omitempty"`
+	Exchange string `json:"exchange,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Type     string `json:"type"`
+	Cached   bool   `json:"cached"`
+}
+
+type TickerSearchResults []TickerSearchResult
+
+// IsISIN tells whether the value is shaped as an ISIN, a country code followed by nine
+// alphanumeric characters and a check digit
+func IsISIN(value string) bool {
+	if len(value) != 12 {
+		return false
+	}
+	for i, c := range strings.ToUpper(value) {
+		isLetter, isDigit := c >= 'A' && c <= 'Z', c >= '0' && c <= '9'
+		if (i < 2 && !isLetter) || (i == 11 && !isDigit) || (!isLetter && !isDigit) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rs TickerSearchResults) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(rs)
+}
+
+// SnapshotRetention is how the snapshots of a ticker are thinned out as they age. Every
+// snapshot is kept for KeepAll, the newest of each day for KeepDaily and the newest of each
+// month after that.
+type SnapshotRetention struct {
+	KeepAll   time.Duration
+	KeepDaily time.Duration
+}
+
+var DefaultSnapshotRetention = SnapshotRetention{
+	KeepAll:   7 * 24 * time.Hour,
+	KeepDaily: 365 * 24 * time.Hour,
+}
+
+// CompactionReport is what a compaction of the snapshots removed. The bytes are the size of
+// the data of the rows deleted, the database file only shrinks once vacuumed.
+type CompactionReport struct {
+	Tickers        int   `json:"tickers"`
+	RowsDeleted    int64 `json:"rowsDeleted"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
 }
 
 type SimplifiedTicker struct {
@@ -245,6 +519,50 @@ type SimplifiedTicker struct {
 
 type Tickers []Ticker
 
+// ErrManualTickerNotFound is returned when deleting a ticker that was not entered by hand
+var ErrManualTickerNotFound = errors.New("manual ticker not found")
+
+// ManualTicker is a ticker whose data is entered by hand, for assets no market data
+// provider knows about
+type ManualTicker struct {
+	Ticker   string  `json:"ticker" validate:"required"`
+	Name     string  `json:"name" validate:"required"`
+	Price    float32 `json:"price" validate:"required,gt=0"`
+	Currency string  `json:"currency" validate:"required,eq=$|eq=€|eq=£"`
+	Sector   string  `json:"sector"`
+	Country  string  `json:"country"`
+	Industry string  `json:"industry"`
+	IsEtf    bool    `json:"is_etf"`
+	Website  string  `json:"website"`
+	Isin     string  `json:"isin" validate:"omitempty,len=12,alphanum"`
+	Exchange string  `json:"exchange"`
+
+	YearlyDividendValue float32 `json:"yearly_dividend_value" validate:"gte=0"`
+}
+
+func (t ManualTicker) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(t)
+}
+
+func (t *ManualTicker) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&t)
+}
+
+func (t ManualTicker) Validate() error {
+	validate = validator.New()
+	return validate.Struct(t)
+}
+
+type ManualTickers []ManualTicker
+
+func (ts ManualTickers) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ts)
+}
+
 func (t Ticker) ToJSON(w io.Writer) error {
 	encoder := json.NewEncoder(w)
 	return encoder.Encode(t)
@@ -285,6 +603,8 @@ type HistoricEntry struct {
 	Currency             string  `json:"currency"`
 	Rate                 float32 `json:"rate"`
 	RateWithoutReinvest  float32 `json:"rateWithoutReinvest"`
+	// Value the buys and sells would have on the date had they gone into each benchmark
+	Benchmarks map[string]float32 `json:"benchmarks,omitempty"`
 }
 
 type PortfolioHistoric []HistoricEntry
@@ -293,3 +613,665 @@ func (ph PortfolioHistoric) ToJSON(w io.Writer) error {
 	encoder := json.NewEncoder(w)
 	return encoder.Encode(ph)
 }
+
+// PerformanceFlow is money put in (negative) or taken out (positive) of a ticker
+type PerformanceFlow struct {
+	Ticker string  `json:"ticker"`
+	Date   Date    `json:"date"`
+	Amount float32 `json:"amount"`
+}
+
+// PerformanceValuation is the value of the holdings at a date
+type PerformanceValuation struct {
+	Date  Date    `json:"date"`
+	Value float32 `json:"value"`
+}
+
+// PerformanceInputs are the valuations of the whole holdings and of each ticker together
+// with the cash flows, all in the same currency
+type PerformanceInputs struct {
+	Currency         string
+	Valuations       []PerformanceValuation
+	TickerValuations map[string][]PerformanceValuation
+	Flows            []PerformanceFlow
+}
+
+// WindowReturn holds the returns since Start. TWR is the cumulative time-weighted return
+// and XIRR the annual money-weighted one, both null when they cannot be computed.
+type WindowReturn struct {
+	Window string   `json:"window"`
+	Start  Date     `json:"start"`
+	TWR    *float32 `json:"twr"`
+	XIRR   *float32 `json:"xirr"`
+}
+
+type TickerPerformance struct {
+	Ticker  string         `json:"ticker"`
+	Returns []WindowReturn `json:"returns"`
+}
+
+type Performance struct {
+	Currency string              `json:"currency"`
+	Returns  []WindowReturn      `json:"returns"`
+	Tickers  []TickerPerformance `json:"tickers"`
+}
+
+func (p Performance) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(p)
+}
+
+// RiskMetrics are annualized from the returns between valuations. MaxDrawdown is the
+// largest fall from a peak as a negative rate. Metrics are null when they cannot be
+// computed.
+type RiskMetrics struct {
+	Volatility     *float32 `json:"volatility"`
+	MaxDrawdown    *float32 `json:"maxDrawdown"`
+	DrawdownPeak   *Date    `json:"drawdownPeak"`
+	DrawdownTrough *Date    `json:"drawdownTrough"`
+	Sharpe         *float32 `json:"sharpe"`
+	Sortino        *float32 `json:"sortino"`
+	Beta           *float32 `json:"beta"`
+}
+
+// BenchmarkPrices is the price history of a benchmark in the currency of the valuations
+type BenchmarkPrices struct {
+	Ticker string
+	Prices []PerformanceValuation
+}
+
+type TickerRisk struct {
+	Ticker string `json:"ticker"`
+	RiskMetrics
+}
+
+type Risk struct {
+	Currency     string       `json:"currency"`
+	RiskFreeRate float32      `json:"riskFreeRate"`
+	Benchmark    string       `json:"benchmark,omitempty"`
+	Portfolio    RiskMetrics  `json:"portfolio"`
+	Tickers      []TickerRisk `json:"tickers"`
+}
+
+func (r Risk) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(r)
+}
+
+// DatedExchangeRates are the exchange rates of a past day, from source to target currency.
+// The pairs without rates back then hold the current rate and are flagged as approximate.
+type DatedExchangeRates struct {
+	Rates       map[string]map[string]float32
+	Approximate map[string]map[string]bool
+}
+
+// Rate returns the rate from source to target and whether it is the current rate instead
+// of the one of the day
+func (r DatedExchangeRates) Rate(source, target string) (float32, bool) {
+	if source == target {
+		return 1, false
+	}
+	return r.Rates[source][target], r.Approximate[source][target]
+}
+
+// LedgerEntry is a buy, sell or dividend flattened into a single row
+type LedgerEntry struct {
+	Type                    string  `json:"type"`
+	Ticker                  string  `json:"ticker"`
+	Units                   float32 `json:"units"`
+	Amount                  float32 `json:"amount"`
+	Fees                    float32 `json:"fees"`
+	Taxes                   float32 `json:"taxes"`
+	Currency                string  `json:"currency"`
+	Date                    Date    `json:"date"`
+	IsReinvestment          bool    `json:"isReinvestment"`
+	PreferredCurrencyAmount float32 `json:"preferredCurrencyAmount"`
+	PreferredCurrency       string  `json:"preferredCurrency"`
+	// The amount was converted at the current rate, the rate history starts later
+	ApproximateRate bool `json:"approximateRate"`
+}
+
+type Ledger []LedgerEntry
+
+func (l Ledger) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(l)
+}
+
+// RealizedGain is the gain or loss of the units of a sell held for the same term. A sell
+// matching lots of both terms is split into two gains, with the proceeds and fees split
+// by units. Amounts are in the currency of the sell and, with the Preferred prefix, in the
+// preferred currency of the user at the exchange rate of the sell date. The preferred cost
+// basis is converted at the rate of each buy date instead.
+type RealizedGain struct {
+	SellId          string  `json:"sellId"`
+	Ticker          string  `json:"ticker"`
+	Term            string  `json:"term"`
+	Units           float32 `json:"units"`
+	AcquisitionDate Date    `json:"acquisitionDate"`
+	Date            Date    `json:"date"`
+	Currency        string  `json:"currency"`
+	Proceeds        float32 `json:"proceeds"`
+	CostBasis       float32 `json:"costBasis"`
+	Fees            float32 `json:"fees"`
+	Gain            float32 `json:"gain"`
+
+	PreferredCurrency  string  `json:"preferredCurrency"`
+	ExchangeRate       float32 `json:"exchangeRate"`
+	PreferredProceeds  float32 `json:"preferredProceeds"`
+	PreferredCostBasis float32 `json:"preferredCostBasis"`
+	PreferredFees      float32 `json:"preferredFees"`
+	PreferredGain      float32 `json:"preferredGain"`
+	// The gain was converted at the current rate, the rate history starts later
+	ApproximateRate bool `json:"approximateRate"`
+}
+
+type RealizedGains []RealizedGain
+
+// RealizedGainsTotals adds up realized gains in the preferred currency of the user
+type RealizedGainsTotals struct {
+	Proceeds      float32 `json:"proceeds"`
+	CostBasis     float32 `json:"costBasis"`
+	Fees          float32 `json:"fees"`
+	Gain          float32 `json:"gain"`
+	ShortTermGain float32 `json:"shortTermGain"`
+	LongTermGain  float32 `json:"longTermGain"`
+}
+
+type TickerRealizedGains struct {
+	Ticker string `json:"ticker"`
+	RealizedGainsTotals
+}
+
+// RealizedGainsReport lists the realized gains of the sells of a tax year
+type RealizedGainsReport struct {
+	Year     int                   `json:"year"`
+	Currency string                `json:"currency"`
+	Gains    RealizedGains         `json:"gains"`
+	Tickers  []TickerRealizedGains `json:"tickers"`
+	Totals   RealizedGainsTotals   `json:"totals"`
+}
+
+func (r RealizedGainsReport) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(r)
+}
+
+// TreatyRate is the maximum percentage of the dividends of a country withheld at source
+// that can be deducted, as set by the double taxation treaty
+type TreatyRate struct {
+	Country string  `json:"country" validate:"required"`
+	Rate    float32 `json:"rate" validate:"gte=0,lte=100"`
+}
+
+type TreatyRates []TreatyRate
+
+func (tr TreatyRates) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(tr)
+}
+
+func (tr *TreatyRates) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&tr)
+}
+
+func (tr TreatyRates) Validate() error {
+	validate = validator.New()
+	for _, r := range tr {
+		if err := validate.Struct(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rate returns the treaty rate of the country, DefaultTreatyRate when it is missing
+func (tr TreatyRates) Rate(country string) float32 {
+	for _, r := range tr {
+		if r.Country == country {
+			return r.Rate
+		}
+	}
+	return DefaultTreatyRate
+}
+
+// AllocationTarget is the weight, as a percentage of the total value, the user aims for a
+// group of an allocation dimension
+type AllocationTarget struct {
+	Key    string  `json:"key" validate:"required"`
+	Weight float32 `json:"weight" validate:"gte=0,lte=100"`
+}
+
+type AllocationTargets []AllocationTarget
+
+func (at AllocationTargets) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(at)
+}
+
+func (at *AllocationTargets) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&at)
+}
+
+// Validate checks every target, that each group has a single one and that the weights do
+// not add up to more than 100
+func (at AllocationTargets) Validate() error {
+	validate = validator.New()
+	keys := map[string]bool{}
+	var total float32
+	for _, t := range at {
+		if err := validate.Struct(t); err != nil {
+			return err
+		}
+		if keys[t.Key] {
+			return fmt.Errorf("duplicated target for %s", t.Key)
+		}
+		keys[t.Key] = true
+		total += t.Weight
+	}
+	if total > 100.001 {
+		return fmt.Errorf("target weights add up to %.2f, more than 100", total)
+	}
+	return nil
+}
+
+// AllocationEntry is the value of a group of the dimension. Weights are percentages of the
+// total value and the drift is the current weight minus the target, both null when the
+// group has no target.
+type AllocationEntry struct {
+	Key          string   `json:"key"`
+	Value        float32  `json:"value"`
+	Weight       float32  `json:"weight"`
+	TargetWeight *float32 `json:"targetWeight"`
+	Drift        *float32 `json:"drift"`
+}
+
+type Allocation struct {
+	By       string            `json:"by"`
+	Currency string            `json:"currency"`
+	Total    float32           `json:"total"`
+	Entries  []AllocationEntry `json:"entries"`
+}
+
+func (a Allocation) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(a)
+}
+
+// DividendTax is a dividend with the taxes withheld, all of them in the preferred
+// currency of the user at the exchange rate of the payment date
+type DividendTax struct {
+	DividendId     string  `json:"dividendId"`
+	Company        string  `json:"company"`
+	Country        string  `json:"country"`
+	Date           Date    `json:"date"`
+	Currency       string  `json:"currency"`
+	ExchangeRate   float32 `json:"exchangeRate"`
+	Gross          float32 `json:"gross"`
+	SourceTax      float32 `json:"sourceTax"`
+	DestinationTax float32 `json:"destinationTax"`
+	Net            float32 `json:"net"`
+	// The dividend was converted at the current rate, the rate history starts later
+	ApproximateRate bool `json:"approximateRate"`
+}
+
+type DividendTaxes []DividendTax
+
+// DividendTaxesTotals adds up the dividends of a country. The recoverable deduction is
+// the tax withheld at source up to the treaty rate of the country. ApproximateRates counts
+// the dividends converted at the current rate.
+type DividendTaxesTotals struct {
+	Gross                float32 `json:"gross"`
+	SourceTax            float32 `json:"sourceTax"`
+	DestinationTax       float32 `json:"destinationTax"`
+	Net                  float32 `json:"net"`
+	RecoverableDeduction float32 `json:"recoverableDeduction"`
+	ApproximateRates     int     `json:"approximateRates"`
+}
+
+type CountryDividendTaxes struct {
+	Country    string  `json:"country"`
+	TreatyRate float32 `json:"treatyRate"`
+	Dividends  int     `json:"dividends"`
+	DividendTaxesTotals
+}
+
+// DividendTaxesReport groups by country the taxes withheld from the dividends of a tax year
+type DividendTaxesReport struct {
+	Year      int                    `json:"year"`
+	Currency  string                 `json:"currency"`
+	Countries []CountryDividendTaxes `json:"countries"`
+	Totals    DividendTaxesTotals    `json:"totals"`
+}
+
+func (r DividendTaxesReport) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(r)
+}
+
+// CorporateAction is an event of the company that changes the units or the ticker held
+// without a buy or a sell. Depending on the type:
+//
+//   - split: Ratio is the number of new units per old unit, so a 4:1 split has a ratio of 4
+//     and a 1:10 reverse split a ratio of 0.1.
+//   - symbol_change: the lots of Ticker move to NewTicker.
+//   - merger: the lots of Ticker move to NewTicker, receiving Ratio new units per old unit.
+//   - cash_acquisition: the position is closed as a sell of Price per unit in Currency.
+//   - spin_off: every unit of Ticker receives Ratio units of NewTicker, which take
+//     CostBasisPercentage percent of the cost basis of the lot.
+type CorporateAction struct {
+	Type                string  `json:"type" validate:"required,oneof=split symbol_change merger cash_acquisition spin_off"`
+	Ticker              string  `json:"ticker" validate:"required"`
+	NewTicker           string  `json:"newTicker,omitempty" validate:"nefield=Ticker"`
+	Ratio               float32 `json:"ratio,omitempty" validate:"gte=0"`
+	CostBasisPercentage float32 `json:"costBasisPercentage,omitempty" validate:"gte=0,lte=100"`
+	Price               float32 `json:"price,omitempty" validate:"gte=0"`
+	Currency            string  `json:"currency,omitempty" validate:"omitempty,eq=$|eq=€|eq=£"`
+	Date                Date    `json:"date" validate:"required"`
+}
+
+func (ca CorporateAction) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ca)
+}
+
+func (ca *CorporateAction) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&ca)
+}
+
+func (ca CorporateAction) Validate() error {
+	validate = validator.New()
+	if err := validate.Struct(ca); err != nil {
+		return err
+	}
+
+	switch {
+	case (ca.Type == Split || ca.Type == Merger || ca.Type == SpinOff) && ca.Ratio <= 0:
+		return fmt.Errorf("a %s requires a ratio greater than 0", ca.Type)
+	case (ca.Type == SymbolChange || ca.Type == Merger || ca.Type == SpinOff) && ca.NewTicker == "":
+		return fmt.Errorf("a %s requires the new ticker", ca.Type)
+	case ca.Type == CashAcquisition && (ca.Price <= 0 || ca.Currency == ""):
+		return fmt.Errorf("a %s requires the price per unit and its currency", ca.Type)
+	case ca.Type == SpinOff && ca.CostBasisPercentage <= 0:
+		return fmt.Errorf("a %s requires the percentage of cost basis moved to the new ticker", ca.Type)
+	}
+	return nil
+}
+
+// Renames returns true when the lots of the ticker move to a different one
+func (ca CorporateAction) Renames() bool {
+	return ca.Type == SymbolChange || ca.Type == Merger
+}
+
+type CorporateActionWithId struct {
+	Id string `json:"id"`
+	CorporateAction
+}
+
+type CorporateActions []CorporateActionWithId
+
+func (ca CorporateActionWithId) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ca)
+}
+
+func (cas CorporateActions) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(cas)
+}
+
+// SourceTickers returns the ticker together with all the tickers whose lots end up in it
+// through symbol changes, mergers or spin-offs
+func (cas CorporateActions) SourceTickers(ticker string) []string {
+	return cas.walkTickers(ticker, func(ca CorporateActionWithId) (string, string) {
+		return ca.NewTicker, ca.Ticker
+	})
+}
+
+// DerivedTickers returns the ticker together with all the tickers its lots end up in
+// through symbol changes, mergers or spin-offs
+func (cas CorporateActions) DerivedTickers(ticker string) []string {
+	return cas.walkTickers(ticker, func(ca CorporateActionWithId) (string, string) {
+		return ca.Ticker, ca.NewTicker
+	})
+}
+
+func (cas CorporateActions) walkTickers(ticker string, edge func(CorporateActionWithId) (string, string)) []string {
+	tickers := []string{ticker}
+	visited := map[string]bool{ticker: true}
+	for i := 0; i < len(tickers); i++ {
+		for _, ca := range cas {
+			if ca.NewTicker == "" {
+				continue
+			}
+			from, to := edge(ca)
+			if from == tickers[i] && !visited[to] {
+				visited[to] = true
+				tickers = append(tickers, to)
+			}
+		}
+	}
+	return tickers
+}
+
+// CashTransaction is a movement of a cash account other than the ones caused by buys,
+// sells and dividends. An FX conversion takes Amount out of the Currency account and
+// puts TargetAmount into the TargetCurrency one.
+type CashTransaction struct {
+	Type           string  `json:"type" validate:"required,oneof=deposit withdrawal interest fee fx_conversion"`
+	Amount         float32 `json:"amount" validate:"gt=0"`
+	Currency       string  `json:"currency" validate:"required,eq=$|eq=€|eq=£"`
+	TargetAmount   float32 `json:"targetAmount,omitempty" validate:"gte=0"`
+	TargetCurrency string  `json:"targetCurrency,omitempty" validate:"omitempty,eq=$|eq=€|eq=£,nefield=Currency"`
+	Description    string  `json:"description"`
+	Date           Date    `json:"date" validate:"required"`
+	// PortfolioId defaults to the default portfolio of the user when empty
+	PortfolioId string `json:"portfolioId,omitempty"`
+}
+
+func (ct CashTransaction) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ct)
+}
+
+func (ct *CashTransaction) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&ct)
+}
+
+func (ct CashTransaction) Validate() error {
+	validate = validator.New()
+	if err := validate.Struct(ct); err != nil {
+		return err
+	}
+
+	if ct.Type == FXConversion && (ct.TargetAmount <= 0 || ct.TargetCurrency == "") {
+		return fmt.Errorf("a %s requires the target amount and currency", ct.Type)
+	}
+	return nil
+}
+
+type CashTransactionWithId struct {
+	Id string `json:"id"`
+	CashTransaction
+}
+
+type CashTransactions []CashTransactionWithId
+
+func (ct CashTransactionWithId) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ct)
+}
+
+func (cts CashTransactions) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(cts)
+}
+
+type CashBalance struct {
+	Currency      string  `json:"currency"`
+	Balance       float32 `json:"balance"`
+	Contributions float32 `json:"contributions"`
+}
+
+type CashBalances []CashBalance
+
+func (cbs CashBalances) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(cbs)
+}
+
+// AssetsWithCash are the securities of the user together with its uninvested cash
+type AssetsWithCash struct {
+	Assets Assets       `json:"assets"`
+	Cash   CashBalances `json:"cash"`
+}
+
+func (awc AssetsWithCash) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(awc)
+}
+
+// ErrDividendNotPending is returned when confirming a dividend that is already confirmed
+var ErrDividendNotPending = errors.New("dividend is not pending")
+
+// ErrPortfolioNotFound is returned when a movement references a portfolio the user does not own
+var ErrPortfolioNotFound = errors.New("portfolio not found")
+
+// ErrPortfolioInUse is returned when deleting the default portfolio or one that still has movements
+var ErrPortfolioInUse = errors.New("portfolio is the default one or still has movements")
+
+// Portfolio groups the movements of a broker account. Every user has a default portfolio
+// which receives the movements that do not reference any.
+type Portfolio struct {
+	Name     string `json:"name" validate:"required"`
+	Broker   string `json:"broker"`
+	Currency string `json:"currency" validate:"required,eq=$|eq=€|eq=£"`
+}
+
+func (p Portfolio) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(p)
+}
+
+func (p *Portfolio) FromJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&p)
+}
+
+func (p Portfolio) Validate() error {
+	validate = validator.New()
+	return validate.Struct(p)
+}
+
+type PortfolioWithId struct {
+	Id        string `json:"id"`
+	IsDefault bool   `json:"isDefault"`
+	Portfolio
+}
+
+type Portfolios []PortfolioWithId
+
+func (p PortfolioWithId) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(p)
+}
+
+func (ps Portfolios) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(ps)
+}
+
+// ErrBackupForeignRows is returned when restoring a backup whose rows reuse the IDs of rows
+// owned by another user
+var ErrBackupForeignRows = errors.New("the backup contains rows owned by another user")
+
+// ErrUnsupportedBackupVersion is returned when reading a backup of a version that can not
+// be restored
+var ErrUnsupportedBackupVersion = errors.New("unsupported backup version")
+
+// AccountBackupVersion is the version of the archive produced by the account backup.
+// Bump it whenever the archive layout changes. Version 2 adds the treaty rates, the
+// allocation targets and the manual tickers.
+const AccountBackupVersion = 2
+
+// MinAccountBackupVersion is the oldest archive version that can still be restored, the
+// sections added later are left empty
+const MinAccountBackupVersion = 1
+
+type BackupHistoricEntry struct {
+	Id                   string    `json:"id"`
+	Value                float32   `json:"value"`
+	BuyValue             float32   `json:"buyValue"`
+	ValueWithoutReinvest float32   `json:"valueWithoutReinvest"`
+	Currency             string    `json:"currency"`
+	PortfolioId          string    `json:"portfolioId,omitempty"`
+	Backfilled           bool      `json:"backfilled,omitempty"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+type BackupTicker struct {
+	DateKey time.Time `json:"dateKey"`
+	Ticker
+}
+
+type BackupAllocationTarget struct {
+	Dimension string `json:"dimension"`
+	AllocationTarget
+}
+
+// AccountBackup is the whole state of a user, including the latest snapshot of
+// every ticker referenced by its movements
+type AccountBackup struct {
+	Version   int                   `json:"version"`
+	CreatedAt time.Time             `json:"createdAt"`
+	User      UserWithId            `json:"user"`
+	Buys      Buys                  `json:"buys"`
+	Sells     Sells                 `json:"sells"`
+	Dividends Dividends             `json:"dividends"`
+	Historic  []BackupHistoricEntry `json:"historic"`
+	Tickers   []BackupTicker        `json:"tickers"`
+
+	CorporateActions  CorporateActions         `json:"corporateActions"`
+	CashTransactions  CashTransactions         `json:"cashTransactions"`
+	Portfolios        Portfolios               `json:"portfolios"`
+	TreatyRates       TreatyRates              `json:"treatyRates"`
+	AllocationTargets []BackupAllocationTarget `json:"allocationTargets"`
+	ManualTickers     ManualTickers            `json:"manualTickers"`
+}
+
+func (b AccountBackup) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(b)
+}
+
+// FromJSON checks the version before decoding the archive, so archives of other versions
+// fail with ErrUnsupportedBackupVersion instead of on the fields they do not share
+func (b *AccountBackup) FromJSON(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Version < MinAccountBackupVersion || header.Version > AccountBackupVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedBackupVersion, header.Version)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&b)
+}
@@ -1,19 +1,27 @@
 package domain
 
+import "time"
+
 type BuysRepository interface {
 	Create(buy Buy, userEmail string) (*BuyWithId, error)
-	FindAll(userEmail string) (Buys, error)
-	FindByTicker(ticker string, userEmail string) (Buys, error)
-	FindByTickerAndCurrency(ticker string, currency string, userEmail string) (Buys, error)
+	FindAll(userEmail string, portfolioId *string) (Buys, error)
+	FindByTicker(ticker string, userEmail string, portfolioId *string) (Buys, error)
+	FindByTickerAndCurrency(ticker string, currency string, userEmail string, portfolioId *string) (Buys, error)
 	FindAllTickers() ([]string, error)
+	FindFirstBuyDates() (map[string]Date, error)
+	Update(id string, buy Buy, userEmail string) (*BuyWithId, error)
 	Delete(id string, userEmail string) error
 }
 
 type DividendsRepository interface {
 	Create(dividend Dividend, userEmail string) (*DividendWithId, error)
-	FindAll(userEmail string) (Dividends, error)
-	FindAllPreferredCurrency(userEmail string) (Dividends, error)
-	UpdateDividends(userEmail string, ids []string, reinvested bool) error
+	FindAll(userEmail string, portfolioId *string) (Dividends, error)
+	FindAllPreferredCurrency(userEmail string, portfolioId *string) (Dividends, error)
+	Update(id string, dividend Dividend, userEmail string) (*DividendWithId, error)
+	UpdateDividends(userEmail string, reinvestments DividendReinvestments) error
+	FindOrphanedReinvestments(userEmail string) (*OrphanedReinvestments, error)
+	FindPayments(userEmail string, portfolioId *string) (PaidDividends, error)
+	Confirm(id string, confirmation DividendConfirmation, userEmail string) (*DividendWithId, error)
 	Delete(id string, userEmail string) error
 }
 
@@ -21,13 +29,28 @@ type UserRepository interface {
 	Create(user User) (*UserWithId, error)
 	FindByEmail(email string) (*UserWithId, error)
 	FindByID(id string) (*UserWithId, error)
-	UpdatePreferences(id string, preferedCurrency string) error
+	UpdatePreferences(id string, preferedCurrency string, costBasisMethod string, benchmarks []string) error
+	FindAllBenchmarks() ([]string, error)
 }
 
 type AssetsRepository interface {
-	FindAll(userEmail string) (Assets, error)
-	FindEvents(userEmail string) (EventCalendar, error)
-	FindHistoric(userEmail string, startDate, endDate Date) (PortfolioHistoric, error)
+	FindAll(userEmail string, portfolioId *string) (Assets, error)
+	FindEvents(userEmail string, portfolioId *string) (EventCalendar, error)
+	FindHistoric(userEmail string, startDate, endDate Date, portfolioId *string) (PortfolioHistoric, error)
+	FindPerformanceInputs(userEmail string, portfolioId *string) (*PerformanceInputs, error)
+	FindBenchmarkPrices(userEmail string, benchmark string, currency string) (*BenchmarkPrices, error)
+	FindAllocation(userEmail string, by string, portfolioId *string) (*Allocation, error)
+	FindAllocationTargets(userEmail string, by string) (AllocationTargets, error)
+	UpdateAllocationTargets(targets AllocationTargets, by string, userEmail string) (AllocationTargets, error)
+}
+
+type HistoricRepository interface {
+	FindTradedTickers(userEmail string) (map[string]Date, error)
+	Backfill(userEmail string, prices map[string]Ticker, until Date) (int, error)
+}
+
+type PriceHistoryRepository interface {
+	FindPriceHistory(ticker string, start Date) (Ticker, error)
 }
 
 type CurrencyRepository interface {
@@ -35,19 +58,88 @@ type CurrencyRepository interface {
 	FindAllExchangeRates() (map[string]map[string]float32, error)
 }
 
+// HistoricalCurrencyRepository knows the exchange rates of past days
+type HistoricalCurrencyRepository interface {
+	FindExchangeRatesAt(date time.Time) (DatedExchangeRates, error)
+}
+
 type TickersRepository interface {
 	FindByTicker(ticker string, currency *string) (Ticker, error)
 	FindMultipleTickers(tickers []string, currency *string) (map[string]Ticker, error)
 }
 
+// MarketDataProvider is a source of ticker data, Source names it in the snapshots it serves
+type MarketDataProvider interface {
+	TickersRepository
+	PriceHistoryRepository
+	Source() string
+}
+
+type TickerSearchRepository interface {
+	Search(query string, limit int) (TickerSearchResults, error)
+}
+
+type ManualTickersRepository interface {
+	FindAll() (ManualTickers, error)
+	Save(ticker ManualTicker) (*ManualTicker, error)
+	Delete(ticker string) error
+}
+
+type TickerPricesRepository interface {
+	FindLastPriceDate(ticker string) (*Date, error)
+	SavePrices(ticker string, currency string, prices []HistoricalEntry) error
+	FindPrices(ticker string, from Date, to Date, interval string, currency *string) (PriceHistory, error)
+}
+
 type WritableTickersRepository interface {
-	Exists(ticker string) (bool, error)
+	FindLastUpdate(ticker string) (*time.Time, error)
 	Create(ticker Ticker) error
 }
 
 type SellsRepository interface {
 	Create(sell Sell, userEmail string) (*SellWithId, error)
-	FindAll(userEmail string) (Sells, error)
-	FindByTicker(ticker string, userEmail string) (Sells, error)
+	FindAll(userEmail string, portfolioId *string) (Sells, error)
+	FindByTicker(ticker string, userEmail string, portfolioId *string) (Sells, error)
+	Update(id string, sell Sell, userEmail string) (*SellWithId, error)
 	Delete(id string, userEmail string) error
 }
+
+type ImportsRepository interface {
+	Import(buys []Buy, sells []Sell, dividends []Dividend, userEmail string, dryRun bool) error
+}
+
+type AccountRepository interface {
+	Backup(userEmail string) (*AccountBackup, error)
+	Restore(backup AccountBackup, userEmail string) error
+}
+
+type CorporateActionsRepository interface {
+	Create(action CorporateAction, userEmail string) (*CorporateActionWithId, error)
+	FindAll(userEmail string) (CorporateActions, error)
+	FindByTicker(ticker string, userEmail string) (CorporateActions, error)
+	Update(id string, action CorporateAction, userEmail string) (*CorporateActionWithId, error)
+	Delete(id string, userEmail string) error
+}
+
+type CashRepository interface {
+	Create(transaction CashTransaction, userEmail string) (*CashTransactionWithId, error)
+	FindAll(userEmail string, portfolioId *string) (CashTransactions, error)
+	FindBalances(userEmail string, portfolioId *string) (CashBalances, error)
+	Update(id string, transaction CashTransaction, userEmail string) (*CashTransactionWithId, error)
+	Delete(id string, userEmail string) error
+}
+
+type PortfoliosRepository interface {
+	Create(portfolio Portfolio, userEmail string) (*PortfolioWithId, error)
+	FindAll(userEmail string) (Portfolios, error)
+	FindByID(id string, userEmail string) (*PortfolioWithId, error)
+	Update(id string, portfolio Portfolio, userEmail string) (*PortfolioWithId, error)
+	Delete(id string, userEmail string) error
+}
+
+type ReportsRepository interface {
+	FindRealizedGains(userEmail string, year int, portfolioId *string) (RealizedGains, error)
+	FindDividendTaxes(userEmail string, year int, portfolioId *string) (DividendTaxes, error)
+	FindTreatyRates(userEmail string) (TreatyRates, error)
+	UpdateTreatyRates(rates TreatyRates, userEmail string) (TreatyRates, error)
+}
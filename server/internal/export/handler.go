@@ -0,0 +1,129 @@
+package export
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+)
+
+type Handler struct {
+	br domain.BuysRepository
+	sr domain.SellsRepository
+	dr domain.DividendsRepository
+	ur domain.UserRepository
+	cr domain.HistoricalCurrencyRepository
+	l  *slog.Logger
+}
+
+func New(br domain.BuysRepository, sr domain.SellsRepository, dr domain.DividendsRepository, ur domain.UserRepository, cr domain.HistoricalCurrencyRepository, logger *slog.Logger) *Handler {
+	return &Handler{
+		br: br,
+		sr: sr,
+		dr: dr,
+		ur: ur,
+		cr: cr,
+		l:  logger,
+	}
+}
+
+// ExportLedgerHandler downloads all the buys, sells and dividends of the user as a single ledger
+func (h *Handler) ExportLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+
+	// Parse query parameters
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = CSV
+	}
+	if format != CSV && format != JSON && format != XLSX {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Format must be one of csv, json or xlsx")
+		return
+	}
+
+	from := query.Get("from")
+	if from == "" {
+		from = "1970-01-01"
+	}
+
+	to := query.Get("to")
+	if to == "" {
+		to = "2999-01-01"
+	}
+
+	parsedFrom, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		h.l.Error("Failed to parse from date", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse from date")
+		return
+	}
+
+	parsedTo, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		h.l.Error("Failed to parse to date", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse to date")
+		return
+	}
+
+	user, err := h.ur.FindByID(claims.User.Id)
+	if err != nil || user == nil {
+		h.l.Error("Failed to find user", "error", err)
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find user")
+		return
+	}
+
+	portfolioId := utils.PortfolioQuery(r)
+	buys, err := h.br.FindAll(user.Email, portfolioId)
+	if err != nil {
+		h.l.Error("Failed to retrieve buys", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve buys")
+		return
+	}
+
+	sells, err := h.sr.FindAll(user.Email, portfolioId)
+	if err != nil {
+		h.l.Error("Failed to retrieve sells", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve sells")
+		return
+	}
+
+	dividends, err := h.dr.FindAll(user.Email, portfolioId)
+	if err != nil {
+		h.l.Error("Failed to retrieve dividends", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve dividends")
+		return
+	}
+
+	// Movements of the same day share the rates
+	ratesByDate := map[domain.Date]domain.DatedExchangeRates{}
+	ratesAt := func(date domain.Date) (domain.DatedExchangeRates, error) {
+		rates, present := ratesByDate[date]
+		if present {
+			return rates, nil
+		}
+		rates, err := h.cr.FindExchangeRatesAt(time.Time(date))
+		if err != nil {
+			return domain.DatedExchangeRates{}, err
+		}
+		ratesByDate[date] = rates
+		return rates, nil
+	}
+
+	ledger, err := BuildLedger(buys, sells, dividends, ratesAt, *user.PreferredCurrency, parsedFrom, parsedTo)
+	if err != nil {
+		h.l.Error("Failed to retrieve exchange rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve exchange rates")
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ledger.%s"`, format))
+	if err := WriteLedger(w, ledger, format); err != nil {
+		h.l.Error("Failed to write ledger", "error", err.Error())
+	}
+}
@@ -0,0 +1,224 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/xuri/excelize/v2"
+)
+
+// Supported export formats
+const (
+	CSV  string = "csv"
+	JSON string = "json"
+	XLSX string = "xlsx"
+)
+
+var ledgerHeader = []string{
+	"Type", "Ticker", "Units", "Amount", "Fees", "Taxes", "Currency", "Date", "Reinvestment", "Preferred Currency Amount", "Preferred Currency",
+	"Approximate Rate",
+}
+
+// BuildLedger merges buys, sells and dividends dated between from and to (both included)
+// into a single ledger sorted by date. Amounts are also converted to the preferred currency
+// at the exchange rates of the date of each movement, flagging the ones converted at the
+// current rate because the rate history starts later.
+func BuildLedger(buys domain.Buys, sells domain.Sells, dividends domain.Dividends, ratesAt func(date domain.Date) (domain.DatedExchangeRates, error), preferredCurrency string, from, to time.Time) (domain.Ledger, error) {
+	ledger := domain.Ledger{}
+	var ratesErr error
+	convert := func(amount float32, currency string, date domain.Date) (float32, bool) {
+		if currency == preferredCurrency {
+			return amount, false
+		}
+		rates, err := ratesAt(date)
+		if err != nil {
+			ratesErr = err
+			return 0, false
+		}
+		rate, approximate := rates.Rate(currency, preferredCurrency)
+		return amount * rate, approximate
+	}
+	inRange := func(d domain.Date) bool {
+		t := time.Time(d)
+		return !t.Before(from) && !t.After(to)
+	}
+
+	for _, b := range buys {
+		if !inRange(b.Date) {
+			continue
+		}
+		amount, approximate := convert(b.Amount, b.Currency, b.Date)
+		ledger = append(ledger, domain.LedgerEntry{
+			Type:                    domain.BuyTransaction,
+			Ticker:                  b.Ticker,
+			Units:                   b.Units,
+			Amount:                  b.Amount,
+			Fees:                    b.Fee,
+			Taxes:                   b.Taxes,
+			Currency:                b.Currency,
+			Date:                    b.Date,
+			IsReinvestment:          b.IsReinvestment,
+			PreferredCurrencyAmount: amount,
+			PreferredCurrency:       preferredCurrency,
+			ApproximateRate:         approximate,
+		})
+	}
+
+	for _, s := range sells {
+		if !inRange(s.Date) {
+			continue
+		}
+		amount, approximate := convert(s.Amount, s.Currency, s.Date)
+		ledger = append(ledger, domain.LedgerEntry{
+			Type:                    domain.SellTransaction,
+			Ticker:                  s.Ticker,
+			Units:                   s.Units,
+			Amount:                  s.Amount,
+			Fees:                    s.Fees,
+			Currency:                s.Currency,
+			Date:                    s.Date,
+			PreferredCurrencyAmount: amount,
+			PreferredCurrency:       preferredCurrency,
+			ApproximateRate:         approximate,
+		})
+	}
+
+	for _, d := range dividends {
+		// Pending dividends are estimates, they are not booked until confirmed
+		if !inRange(d.Date) || d.Status == domain.DividendPending {
+			continue
+		}
+		// Dividend taxes are stored as the percentages withheld at origin and destination
+		net := d.Amount * (1 - d.DoubleTaxationOrigin/100) * (1 - d.DoubleTaxationDestination/100)
+		amount, approximate := convert(d.Amount, d.Currency, d.Date)
+		ledger = append(ledger, domain.LedgerEntry{
+			Type:                    domain.DividendTransaction,
+			Ticker:                  d.Company,
+			Amount:                  d.Amount,
+			Taxes:                   d.Amount - net,
+			Currency:                d.Currency,
+			Date:                    d.Date,
+			IsReinvestment:          d.IsReinvested,
+			PreferredCurrencyAmount: amount,
+			PreferredCurrency:       preferredCurrency,
+			ApproximateRate:         approximate,
+		})
+	}
+
+	if ratesErr != nil {
+		return nil, ratesErr
+	}
+
+	sort.SliceStable(ledger, func(i, j int) bool {
+		return time.Time(ledger[i].Date).Before(time.Time(ledger[j].Date))
+	})
+	return ledger, nil
+}
+
+// WriteLedger writes the ledger in the given format
+func WriteLedger(w io.Writer, ledger domain.Ledger, format string) error {
+	switch format {
+	case CSV:
+		return writeCSV(w, ledger)
+	case JSON:
+		return ledger.ToJSON(w)
+	case XLSX:
+		return writeXLSX(w, ledger)
+	default:
+		return fmt.Errorf("format %q not supported", format)
+	}
+}
+
+// ContentType returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/json"
+	}
+}
+
+func ledgerRecord(e domain.LedgerEntry) []string {
+	formatFloat := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return []string{
+		e.Type,
+		e.Ticker,
+		formatFloat(e.Units),
+		formatFloat(e.Amount),
+		formatFloat(e.Fees),
+		formatFloat(e.Taxes),
+		e.Currency,
+		e.Date.String(),
+		strconv.FormatBool(e.IsReinvestment),
+		formatFloat(e.PreferredCurrencyAmount),
+		e.PreferredCurrency,
+		strconv.FormatBool(e.ApproximateRate),
+	}
+}
+
+func writeCSV(w io.Writer, ledger domain.Ledger) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(ledgerHeader); err != nil {
+		return err
+	}
+	for _, e := range ledger {
+		if err := cw.Write(ledgerRecord(e)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeXLSX(w io.Writer, ledger domain.Ledger) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := "Ledger"
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(ledgerHeader))
+	for i, h := range ledgerHeader {
+		header[i] = h
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	for i, e := range ledger {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		row := []interface{}{
+			e.Type, e.Ticker, e.Units, e.Amount, e.Fees, e.Taxes, e.Currency,
+			e.Date.String(), e.IsReinvestment, e.PreferredCurrencyAmount, e.PreferredCurrency,
+			e.ApproximateRate,
+		}
+		if err := sw.SetRow(cell, row); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(w)
+}
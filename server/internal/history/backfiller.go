@@ -0,0 +1,49 @@
+package history
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+type Backfiller struct {
+	repo   domain.HistoricRepository
+	prices domain.PriceHistoryRepository
+	l      *slog.Logger
+}
+
+func NewBackfiller(repo domain.HistoricRepository, prices domain.PriceHistoryRepository, logger *slog.Logger) *Backfiller {
+	return &Backfiller{
+		repo:   repo,
+		prices: prices,
+		l:      logger,
+	}
+}
+
+// Backfill rebuilds the daily snapshots of the user since its first buy, from the daily
+// prices stored of every ticker it has traded. Tickers without stored prices are left out of
+// the snapshots. Returns the number of snapshots written.
+func (b *Backfiller) Backfill(userEmail string) (int, error) {
+	tickers, err := b.repo.FindTradedTickers(userEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	prices := map[string]domain.Ticker{}
+	for ticker, since := range tickers {
+		info, err := b.prices.FindPriceHistory(ticker, since)
+		if err != nil {
+			b.l.Warn("Failed to find price history", "ticker", ticker, "error", err.Error())
+			continue
+		}
+
+		sort.SliceStable(info.HistoricalData, func(i, j int) bool {
+			return time.Time(info.HistoricalData[i].Date).Before(time.Time(info.HistoricalData[j].Date))
+		})
+		prices[ticker] = info
+	}
+
+	return b.repo.Backfill(userEmail, prices, domain.Date(time.Now()))
+}
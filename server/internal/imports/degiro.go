@@ -0,0 +1,99 @@
+package imports
+
+import (
+	"io"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// DegiroParser reads the "Transactions" export of Degiro (English layout).
+// Degiro does not export ticker symbols, so rows only carry the ISIN and have
+// to be resolved with the symbols mapping of the import.
+//
+// Date,Time,Product,ISIN,Reference exchange,Venue,Quantity,Price,,Local value,,Value,,Exchange rate,Transaction and/or third party fees,,Total,,Order ID
+type DegiroParser struct{}
+
+const degiroFeesColumn = "Transaction and/or third party fees"
+
+var degiroNumberColumns = []string{"Quantity", "Value", degiroFeesColumn}
+
+func (p DegiroParser) Parse(r io.Reader) ([]Row, error) {
+	reader := newCSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	index := headerIndex(header)
+
+	records, lines := [][]string{}, []int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	// The numbers follow the language of the account, e.g. 1.234,56 in the Spanish one
+	formats := map[string]numberFormat{}
+	for _, column := range degiroNumberColumns {
+		values := make([]string, len(records))
+		for i, record := range records {
+			values[i] = field(record, index, column)
+		}
+		formats[column] = detectNumberFormat(values)
+	}
+
+	rows := []Row{}
+	for i, record := range records {
+		row := Row{Line: lines[i], ISIN: field(record, index, "ISIN")}
+		date, err := time.Parse("02-01-2006", field(record, index, "Date"))
+		if err != nil {
+			row.Errors = append(row.Errors, "invalid date "+field(record, index, "Date"))
+		}
+
+		quantity, errQuantity := parseNumber(field(record, index, "Quantity"), formats["Quantity"])
+		value, errValue := parseNumber(field(record, index, "Value"), formats["Value"])
+		fees, errFees := parseNumber(field(record, index, degiroFeesColumn), formats[degiroFeesColumn])
+		for _, err := range []error{errQuantity, errValue, errFees} {
+			if err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+
+		// The currency of each amount is in the unnamed column next to it
+		currency := ""
+		if i, present := index["Value"]; present && i+1 < len(record) {
+			currency = currencySymbol(record[i+1])
+		}
+
+		if quantity >= 0 {
+			row.Type = domain.BuyTransaction
+			row.Buy = &domain.Buy{
+				Units:    quantity,
+				Amount:   abs(value),
+				Fee:      abs(fees),
+				Currency: currency,
+				Date:     domain.Date(date),
+			}
+		} else {
+			row.Type = domain.SellTransaction
+			row.Sell = &domain.Sell{
+				Units:    abs(quantity),
+				Amount:   abs(value),
+				Fees:     abs(fees),
+				Currency: currency,
+				Date:     domain.Date(date),
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,83 @@
+package imports
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+)
+
+const maxStatementSize = 10 << 20
+
+type Handler struct {
+	importer *Importer
+	l        *slog.Logger
+}
+
+func New(importer *Importer, logger *slog.Logger) *Handler {
+	return &Handler{
+		importer: importer,
+		l:        logger,
+	}
+}
+
+// ImportHandler receives a broker statement as the "file" field of a multipart form.
+// By default it only returns the preview, the movements are stored when the commit
+// query parameter is true. Movements are stored in the portfolio query parameter,
+// or in the default portfolio when missing.
+func (h *Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	query := r.URL.Query()
+	broker := query.Get("broker")
+	if _, err := ParserFor(broker); err != nil {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	commit := query.Get("commit") == "true"
+
+	if err := r.ParseMultipartForm(maxStatementSize); err != nil {
+		h.l.Error("Failed to parse multipart form", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing file field")
+		return
+	}
+	defer file.Close()
+
+	// Optional JSON object mapping ISINs or broker symbols to tickers
+	symbols := map[string]string{}
+	if s := r.FormValue("symbols"); s != "" {
+		if err := json.Unmarshal([]byte(s), &symbols); err != nil {
+			utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid symbols field")
+			return
+		}
+	}
+
+	preview, err := h.importer.Import(broker, file, symbols, user.Email, query.Get("portfolio"), commit)
+	if err != nil {
+		h.l.Error("Failed to import statement", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	statusCode := http.StatusOK
+	if commit && preview.Committed {
+		statusCode = http.StatusCreated
+	} else if commit {
+		statusCode = http.StatusUnprocessableEntity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := preview.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize import preview", "error", err.Error())
+	}
+}
@@ -0,0 +1,172 @@
+package imports
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// IBKRParser reads the Activity Statement CSV of Interactive Brokers. The statement is
+// made of sections where the first column is the section name and the second one tells
+// whether the line is a header or data. Stock trades, dividends and withholding taxes
+// are used, the latter to compute the tax rate applied at source. Numbers always use dot
+// as decimal separator and comma for thousands.
+type IBKRParser struct{}
+
+type ibkrDividendKey struct {
+	symbol string
+	date   string
+}
+
+func (p IBKRParser) Parse(r io.Reader) ([]Row, error) {
+	reader := newCSVReader(r)
+
+	rows := []Row{}
+	dividendRows := map[ibkrDividendKey]int{}
+	withholdings := map[ibkrDividendKey]float32{}
+	headers := map[string]map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		section := strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff"))
+		if record[1] == "Header" {
+			headers[section] = headerIndex(record)
+			continue
+		}
+		if record[1] != "Data" {
+			continue
+		}
+		index := headers[section]
+
+		switch section {
+		case "Trades":
+			if field(record, index, "DataDiscriminator") != "Order" || field(record, index, "Asset Category") != "Stocks" {
+				continue
+			}
+			rows = append(rows, ibkrTradeRow(record, index, line))
+		case "Dividends":
+			// Skip the totals per currency
+			if strings.HasPrefix(field(record, index, "Currency"), "Total") {
+				continue
+			}
+			row := ibkrDividendRow(record, index, line)
+			dividendRows[ibkrDividendKey{row.Dividend.Company, field(record, index, "Date")}] = len(rows)
+			rows = append(rows, row)
+		case "Withholding Tax":
+			if strings.HasPrefix(field(record, index, "Currency"), "Total") {
+				continue
+			}
+			symbol, _ := ibkrSymbolAndISIN(field(record, index, "Description"))
+			amount, err := parseNumber(field(record, index, "Amount"), dotDecimal)
+			if err != nil {
+				continue
+			}
+			withholdings[ibkrDividendKey{symbol, field(record, index, "Date")}] += amount
+		}
+	}
+
+	for key, withholding := range withholdings {
+		i, present := dividendRows[key]
+		if !present || rows[i].Dividend.Amount <= 0 {
+			continue
+		}
+		rows[i].Dividend.DoubleTaxationOrigin = abs(withholding) / rows[i].Dividend.Amount * 100
+	}
+
+	return rows, nil
+}
+
+func ibkrTradeRow(record []string, index map[string]int, line int) Row {
+	row := Row{Line: line}
+	ticker := field(record, index, "Symbol")
+	currency := currencySymbol(field(record, index, "Currency"))
+
+	// Date/Time looks like "2023-01-05, 10:30:00"
+	dateTime := field(record, index, "Date/Time")
+	date, err := time.Parse(time.DateOnly, strings.SplitN(dateTime, ",", 2)[0])
+	if err != nil {
+		row.Errors = append(row.Errors, "invalid date "+dateTime)
+	}
+
+	quantity, errQuantity := parseNumber(field(record, index, "Quantity"), dotDecimal)
+	proceeds, errProceeds := parseNumber(field(record, index, "Proceeds"), dotDecimal)
+	fees, errFees := parseNumber(field(record, index, "Comm/Fee"), dotDecimal)
+	for _, err := range []error{errQuantity, errProceeds, errFees} {
+		if err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+	}
+
+	if quantity >= 0 {
+		row.Type = domain.BuyTransaction
+		row.Buy = &domain.Buy{
+			Units:    quantity,
+			Ticker:   ticker,
+			Amount:   abs(proceeds),
+			Fee:      abs(fees),
+			Currency: currency,
+			Date:     domain.Date(date),
+		}
+	} else {
+		row.Type = domain.SellTransaction
+		row.Sell = &domain.Sell{
+			Units:    abs(quantity),
+			Ticker:   ticker,
+			Amount:   abs(proceeds),
+			Fees:     abs(fees),
+			Currency: currency,
+			Date:     domain.Date(date),
+		}
+	}
+	return row
+}
+
+func ibkrDividendRow(record []string, index map[string]int, line int) Row {
+	symbol, isin := ibkrSymbolAndISIN(field(record, index, "Description"))
+	row := Row{Line: line, ISIN: isin, Type: domain.DividendTransaction}
+
+	date, err := time.Parse(time.DateOnly, field(record, index, "Date"))
+	if err != nil {
+		row.Errors = append(row.Errors, "invalid date "+field(record, index, "Date"))
+	}
+
+	amount, err := parseNumber(field(record, index, "Amount"), dotDecimal)
+	if err != nil {
+		row.Errors = append(row.Errors, err.Error())
+	}
+
+	row.Dividend = &domain.Dividend{
+		Company:  symbol,
+		Amount:   amount,
+		Currency: currencySymbol(field(record, index, "Currency")),
+		Date:     domain.Date(date),
+	}
+	return row
+}
+
+// ibkrSymbolAndISIN extracts the symbol and ISIN from descriptions such as
+// "AAPL(US0378331005) Cash Dividend USD 0.23 per Share (Ordinary Dividend)"
+func ibkrSymbolAndISIN(description string) (string, string) {
+	open := strings.Index(description, "(")
+	if open < 0 {
+		return strings.TrimSpace(description), ""
+	}
+	symbol := strings.TrimSpace(description[:open])
+	end := strings.Index(description[open:], ")")
+	if end < 0 {
+		return symbol, ""
+	}
+	return symbol, description[open+1 : open+end]
+}
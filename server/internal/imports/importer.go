@@ -0,0 +1,314 @@
+package imports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/history"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/tickers"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/go-playground/validator"
+	"github.com/judedaryl/go-arrayutils"
+)
+
+// Preview is the result of processing a broker statement. Rows marked as duplicates
+// are already stored and are skipped when committing.
+type Preview struct {
+	Broker    string   `json:"broker"`
+	Committed bool     `json:"committed"`
+	Rows      []Row    `json:"rows"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+func (p Preview) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(p)
+}
+
+// HasErrors tells whether the import can not be committed
+func (p Preview) HasErrors() bool {
+	if len(p.Errors) > 0 {
+		return true
+	}
+	return arrayutils.Some(p.Rows, func(r Row) bool {
+		return len(r.Errors) > 0
+	})
+}
+
+type Importer struct {
+	br           domain.BuysRepository
+	sr           domain.SellsRepository
+	dr           domain.DividendsRepository
+	tr           domain.TickersRepository
+	ir           domain.ImportsRepository
+	tickersCache *tickers.CacheManager
+	backfiller   *history.Backfiller
+	l            *slog.Logger
+}
+
+// NewImporter creates the importer. The backfiller may be nil when the caller rebuilds the
+// history itself.
+func NewImporter(br domain.BuysRepository, sr domain.SellsRepository, dr domain.DividendsRepository, tr domain.TickersRepository, ir domain.ImportsRepository, tickersCache *tickers.CacheManager, backfiller *history.Backfiller, logger *slog.Logger) *Importer {
+	return &Importer{
+		br:           br,
+		sr:           sr,
+		dr:           dr,
+		tr:           tr,
+		ir:           ir,
+		tickersCache: tickersCache,
+		backfiller:   backfiller,
+		l:            logger,
+	}
+}
+
+// Import parses the broker statement and validates every row. Symbols maps ISINs or broker
+// symbols to the provider tickers. When commit is true and there are no errors, all the new
+// movements are stored in a single transaction, otherwise the transaction is only simulated.
+// Movements go to the given portfolio, or to the default one when portfolioId is empty.
+// Once committed, the daily history of the user is rebuilt with the new movements.
+func (i *Importer) Import(broker string, r io.Reader, symbols map[string]string, userEmail, portfolioId string, commit bool) (*Preview, error) {
+	parser, err := ParserFor(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := parser.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s statement: %w", broker, err)
+	}
+
+	for j := range rows {
+		rows[j].SetPortfolio(portfolioId)
+	}
+
+	preview := &Preview{Broker: broker, Rows: rows}
+	i.resolveTickers(preview.Rows, symbols)
+	i.validate(preview.Rows)
+	if err := i.markDuplicates(preview.Rows, userEmail, portfolioId); err != nil {
+		return nil, err
+	}
+
+	if preview.HasErrors() {
+		return preview, nil
+	}
+
+	newBuys, newSells, newDividends := newMovements(preview.Rows)
+	err = i.ir.Import(newBuys, newSells, newDividends, userEmail, !commit)
+	if errors.Is(err, sells.ErrNotEnoughUnits) || errors.Is(err, domain.ErrPortfolioNotFound) {
+		preview.Errors = append(preview.Errors, err.Error())
+		return preview, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	preview.Committed = commit
+	if commit && i.backfiller != nil {
+		i.backfill(userEmail)
+	}
+	return preview, nil
+}
+
+// backfill rebuilds the daily history of the user in the background, or before answering
+// on Lambda, where nothing runs once the response is sent. The movements are already
+// stored, a failed backfill is retried by the daily backfill task.
+func (i *Importer) backfill(userEmail string) {
+	run := func() {
+		if _, err := i.backfiller.Backfill(userEmail); err != nil {
+			i.l.Error("Failed to backfill history", "user", userEmail, "error", err.Error())
+		}
+	}
+
+	if utils.IsRunningInLambdaEnv() {
+		run()
+		return
+	}
+	go run()
+}
+
+// resolveTickers applies the symbols mapping, searches the ticker of the rows that only
+// carry an ISIN and warms the tickers cache so every movement points to a known ticker
+func (i *Importer) resolveTickers(rows []Row, symbols map[string]string) {
+	for j := range rows {
+		if s, present := symbols[rows[j].ISIN]; present && rows[j].ISIN != "" {
+			rows[j].SetTicker(s)
+		} else if s, present := symbols[rows[j].Ticker()]; present {
+			rows[j].SetTicker(s)
+		}
+	}
+
+	isinTickers := map[string]string{}
+	for j := range rows {
+		if rows[j].Ticker() != "" || rows[j].ISIN == "" {
+			continue
+		}
+		ticker, present := isinTickers[rows[j].ISIN]
+		if !present {
+			ticker = i.findTickerByISIN(rows[j].ISIN)
+			isinTickers[rows[j].ISIN] = ticker
+		}
+		if ticker != "" {
+			rows[j].SetTicker(ticker)
+		}
+	}
+
+	tickersInfo := map[string]*domain.Ticker{}
+	uts := utils.ArrayUnique(arrayutils.Map(rows, func(r Row) string {
+		return r.Ticker()
+	}))
+	for _, t := range uts {
+		if t == "" {
+			continue
+		}
+		if err := i.tickersCache.WriteToCache(t); err != nil {
+			i.l.Warn("Failed to write ticker to cache", "ticker", t, "error", err.Error())
+			continue
+		}
+		ticker, err := i.tr.FindByTicker(t, nil)
+		if err != nil {
+			i.l.Warn("Failed to find ticker", "ticker", t, "error", err.Error())
+			continue
+		}
+		tickersInfo[t] = &ticker
+	}
+
+	for j := range rows {
+		ticker := rows[j].Ticker()
+		if ticker == "" {
+			rows[j].Errors = append(rows[j].Errors, fmt.Sprintf("missing ticker for ISIN %s, add it to the symbols mapping", rows[j].ISIN))
+			continue
+		}
+
+		info, present := tickersInfo[ticker]
+		if !present {
+			rows[j].Errors = append(rows[j].Errors, fmt.Sprintf("could not find ticker %s", ticker))
+			continue
+		}
+
+		if rows[j].Dividend != nil && rows[j].Dividend.Country == "" {
+			rows[j].Dividend.Country = info.Country
+		}
+	}
+}
+
+// findTickerByISIN returns the ticker found for the ISIN, empty when the search fails or
+// none of the results has that ISIN
+func (i *Importer) findTickerByISIN(isin string) string {
+	results, err := i.tickersCache.Search(isin, 5)
+	if err != nil {
+		i.l.Warn("Failed to search ticker by ISIN", "isin", isin, "error", err.Error())
+		return ""
+	}
+
+	for _, result := range results {
+		if strings.EqualFold(result.Isin, isin) {
+			return result.Ticker
+		}
+	}
+	return ""
+}
+
+func (i *Importer) validate(rows []Row) {
+	validate := validator.New()
+	for j := range rows {
+		var err error
+		switch {
+		case rows[j].Buy != nil:
+			err = rows[j].Buy.Validate()
+		case rows[j].Sell != nil:
+			// The acquisition value is computed with the cost basis method when storing the sell
+			err = validate.StructExcept(rows[j].Sell, "AcquisitionValue")
+		case rows[j].Dividend != nil:
+			err = rows[j].Dividend.Validate()
+		}
+		if err != nil {
+			rows[j].Errors = append(rows[j].Errors, err.Error())
+		}
+	}
+}
+
+// markDuplicates flags the rows already stored in the portfolio, or in any portfolio when
+// none is given, and the ones repeated within the statement
+func (i *Importer) markDuplicates(rows []Row, userEmail, portfolioId string) error {
+	var portfolioFilter *string
+	if portfolioId != "" {
+		portfolioFilter = &portfolioId
+	}
+
+	buys, err := i.br.FindAll(userEmail, portfolioFilter)
+	if err != nil {
+		return err
+	}
+	existingSells, err := i.sr.FindAll(userEmail, portfolioFilter)
+	if err != nil {
+		return err
+	}
+	dividends, err := i.dr.FindAll(userEmail, portfolioFilter)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, b := range buys {
+		seen[buyKey(b.Buy)] = true
+	}
+	for _, s := range existingSells {
+		seen[sellKey(s.Sell)] = true
+	}
+	for _, d := range dividends {
+		seen[dividendKey(d.Dividend)] = true
+	}
+
+	for j := range rows {
+		var key string
+		switch {
+		case rows[j].Buy != nil:
+			key = buyKey(*rows[j].Buy)
+		case rows[j].Sell != nil:
+			key = sellKey(*rows[j].Sell)
+		case rows[j].Dividend != nil:
+			key = dividendKey(*rows[j].Dividend)
+		}
+		rows[j].Duplicate = seen[key]
+		seen[key] = true
+	}
+	return nil
+}
+
+func newMovements(rows []Row) ([]domain.Buy, []domain.Sell, []domain.Dividend) {
+	buys := []domain.Buy{}
+	sells := []domain.Sell{}
+	dividends := []domain.Dividend{}
+	for _, r := range rows {
+		if r.Duplicate {
+			continue
+		}
+		switch {
+		case r.Buy != nil:
+			buys = append(buys, *r.Buy)
+		case r.Sell != nil:
+			sells = append(sells, *r.Sell)
+		case r.Dividend != nil:
+			dividends = append(dividends, *r.Dividend)
+		}
+	}
+	return buys, sells, dividends
+}
+
+func buyKey(b domain.Buy) string {
+	return fmt.Sprintf("%s|%s|%s|%.4f|%.2f", domain.BuyTransaction, b.Ticker, b.Date.String(), b.Units, b.Amount)
+}
+
+func sellKey(s domain.Sell) string {
+	return fmt.Sprintf("%s|%s|%s|%.4f|%.2f", domain.SellTransaction, s.Ticker, s.Date.String(), s.Units, s.Amount)
+}
+
+func dividendKey(d domain.Dividend) string {
+	return fmt.Sprintf("%s|%s|%s|%.2f", domain.DividendTransaction, d.Company, d.Date.String(), d.Amount)
+}
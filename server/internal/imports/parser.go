@@ -0,0 +1,199 @@
+package imports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// Row is a single movement found in a broker statement
+type Row struct {
+	Line      int              `json:"line"`
+	Type      string           `json:"type"`
+	ISIN      string           `json:"isin,omitempty"`
+	Buy       *domain.Buy      `json:"buy,omitempty"`
+	Sell      *domain.Sell     `json:"sell,omitempty"`
+	Dividend  *domain.Dividend `json:"dividend,omitempty"`
+	Duplicate bool             `json:"duplicate"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// Ticker returns the ticker the movement refers to
+func (r Row) Ticker() string {
+	switch {
+	case r.Buy != nil:
+		return r.Buy.Ticker
+	case r.Sell != nil:
+		return r.Sell.Ticker
+	case r.Dividend != nil:
+		return r.Dividend.Company
+	}
+	return ""
+}
+
+// SetTicker changes the ticker the movement refers to
+func (r *Row) SetTicker(ticker string) {
+	switch {
+	case r.Buy != nil:
+		r.Buy.Ticker = ticker
+	case r.Sell != nil:
+		r.Sell.Ticker = ticker
+	case r.Dividend != nil:
+		r.Dividend.Company = ticker
+	}
+}
+
+// SetPortfolio changes the portfolio the movement is stored in
+func (r *Row) SetPortfolio(portfolioId string) {
+	switch {
+	case r.Buy != nil:
+		r.Buy.PortfolioId = portfolioId
+	case r.Sell != nil:
+		r.Sell.PortfolioId = portfolioId
+	case r.Dividend != nil:
+		r.Dividend.PortfolioId = portfolioId
+	}
+}
+
+// Parser reads the CSV layout of a broker export. Rows that can not be mapped
+// to a movement are returned with errors instead of failing the whole file.
+type Parser interface {
+	Parse(r io.Reader) ([]Row, error)
+}
+
+var parsers = map[string]Parser{
+	"degiro":     DegiroParser{},
+	"ibkr":       IBKRParser{},
+	"trading212": Trading212Parser{},
+}
+
+// ParserFor returns the parser registered for the broker
+func ParserFor(broker string) (Parser, error) {
+	p, present := parsers[strings.ToLower(broker)]
+	if !present {
+		return nil, fmt.Errorf("broker %q not supported, use one of %s", broker, strings.Join(SupportedBrokers(), ", "))
+	}
+	return p, nil
+}
+
+// SupportedBrokers lists the brokers with a registered parser
+func SupportedBrokers() []string {
+	brokers := make([]string, 0, len(parsers))
+	for b := range parsers {
+		brokers = append(brokers, b)
+	}
+	sort.Strings(brokers)
+	return brokers
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// headerIndex maps every column name to its position. Repeated names keep the first one.
+func headerIndex(header []string) map[string]int {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if _, present := index[h]; !present && h != "" {
+			index[h] = i
+		}
+	}
+	return index
+}
+
+func field(record []string, index map[string]int, name string) string {
+	i, present := index[name]
+	if !present || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// numberFormat is the decimal and thousands separators a broker writes its numbers with
+type numberFormat struct {
+	decimal   string
+	thousands string
+}
+
+var (
+	dotDecimal   = numberFormat{decimal: ".", thousands: ","}
+	commaDecimal = numberFormat{decimal: ",", thousands: "."}
+)
+
+// detectNumberFormat guesses the separators of a column from its values. The last of both
+// separators, a separator repeated or one not followed by three digits tell the format
+// apart, columns where every value is ambiguous (e.g. 1,000) default to dot decimals.
+func detectNumberFormat(values []string) numberFormat {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		lastDot, lastComma := strings.LastIndex(v, "."), strings.LastIndex(v, ",")
+		switch {
+		case lastDot >= 0 && lastComma >= 0:
+			if lastComma > lastDot {
+				return commaDecimal
+			}
+			return dotDecimal
+		case lastComma >= 0:
+			if strings.Count(v, ",") > 1 {
+				return dotDecimal
+			}
+			if len(v)-lastComma-1 != 3 {
+				return commaDecimal
+			}
+		case lastDot >= 0:
+			if strings.Count(v, ".") > 1 {
+				return commaDecimal
+			}
+			if len(v)-lastDot-1 != 3 {
+				return dotDecimal
+			}
+		}
+	}
+	return dotDecimal
+}
+
+// parseNumber parses a decimal number written in the format. Empty values are zero.
+func parseNumber(s string, format numberFormat) (float32, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	normalized := strings.ReplaceAll(s, format.thousands, "")
+	normalized = strings.Replace(normalized, format.decimal, ".", 1)
+	v, err := strconv.ParseFloat(normalized, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return float32(v), nil
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// currencySymbol maps ISO currency codes to the symbols used in the domain
+func currencySymbol(code string) string {
+	switch strings.ToUpper(strings.TrimSpace(code)) {
+	case "EUR":
+		return domain.EUR
+	case "USD":
+		return domain.USD
+	case "GBP":
+		return domain.GBP
+	default:
+		return code
+	}
+}
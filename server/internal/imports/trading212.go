@@ -0,0 +1,148 @@
+package imports
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// Trading212Parser reads the history export of Trading 212. Deposits, withdrawals
+// and interest rows are ignored. Numbers always use dot as decimal separator.
+//
+// Action,Time,ISIN,Ticker,Name,No. of shares,Price / share,Currency (Price / share),Exchange rate,Result,Currency (Result),Total,Currency (Total),Withholding tax,Currency (Withholding tax),...
+type Trading212Parser struct{}
+
+var trading212FeeColumns = []string{"Currency conversion fee", "Transaction fee", "Finra fee"}
+var trading212TaxColumns = []string{"Stamp duty reserve tax", "French transaction tax", "Stamp duty"}
+
+func (p Trading212Parser) Parse(r io.Reader) ([]Row, error) {
+	reader := newCSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	index := headerIndex(header)
+
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		action := strings.ToLower(field(record, index, "Action"))
+		isBuy := strings.HasSuffix(action, "buy")
+		isSell := strings.HasSuffix(action, "sell")
+		isDividend := strings.HasPrefix(action, "dividend")
+		if !isBuy && !isSell && !isDividend {
+			continue
+		}
+
+		row := Row{
+			Line: line,
+			ISIN: field(record, index, "ISIN"),
+		}
+		ticker := field(record, index, "Ticker")
+
+		// Only the date part of "2023-01-05 14:30:12" is kept
+		date, err := time.Parse(time.DateOnly, strings.SplitN(field(record, index, "Time"), " ", 2)[0])
+		if err != nil {
+			row.Errors = append(row.Errors, "invalid date "+field(record, index, "Time"))
+		}
+
+		shares, errShares := parseNumber(field(record, index, "No. of shares"), dotDecimal)
+		pricePerShare, errPrice := parseNumber(field(record, index, "Price / share"), dotDecimal)
+		withholding, errWithholding := parseNumber(field(record, index, "Withholding tax"), dotDecimal)
+		total, currency, errTotal := trading212Total(record, index)
+		fees, errFees := sumColumns(record, index, trading212FeeColumns)
+		taxes, errTaxes := sumColumns(record, index, trading212TaxColumns)
+		for _, err := range []error{errShares, errPrice, errWithholding, errTotal, errFees, errTaxes} {
+			if err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+
+		switch {
+		case isBuy:
+			// The total of a buy includes fees and taxes
+			row.Type = domain.BuyTransaction
+			row.Buy = &domain.Buy{
+				Units:    shares,
+				Ticker:   ticker,
+				Amount:   total - fees - taxes,
+				Fee:      fees,
+				Taxes:    taxes,
+				Currency: currency,
+				Date:     domain.Date(date),
+			}
+		case isSell:
+			// The total of a sell is already net of fees
+			row.Type = domain.SellTransaction
+			row.Sell = &domain.Sell{
+				Units:    shares,
+				Ticker:   ticker,
+				Amount:   total + fees + taxes,
+				Fees:     fees + taxes,
+				Currency: currency,
+				Date:     domain.Date(date),
+			}
+		case isDividend:
+			// The total is the net amount in the account currency while the withholding tax
+			// is in the instrument currency, so the tax is turned into a rate first
+			var origin float32
+			if gross := shares * pricePerShare; gross > 0 {
+				origin = abs(withholding) / gross * 100
+			}
+			amount := total
+			if origin < 100 {
+				amount = total / (1 - origin/100)
+			}
+			row.Type = domain.DividendTransaction
+			row.Dividend = &domain.Dividend{
+				Company:              ticker,
+				Amount:               amount,
+				Currency:             currency,
+				DoubleTaxationOrigin: origin,
+				Date:                 domain.Date(date),
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// trading212Total supports both the "Total" + "Currency (Total)" layout and the
+// older "Total (EUR)" one
+func trading212Total(record []string, index map[string]int) (float32, string, error) {
+	if _, present := index["Total"]; present {
+		total, err := parseNumber(field(record, index, "Total"), dotDecimal)
+		return total, currencySymbol(field(record, index, "Currency (Total)")), err
+	}
+
+	for name := range index {
+		if strings.HasPrefix(name, "Total (") && strings.HasSuffix(name, ")") {
+			total, err := parseNumber(field(record, index, name), dotDecimal)
+			return total, currencySymbol(name[len("Total (") : len(name)-1]), err
+		}
+	}
+	return 0, "", nil
+}
+
+func sumColumns(record []string, index map[string]int, columns []string) (float32, error) {
+	var sum float32
+	for _, c := range columns {
+		v, err := parseNumber(field(record, index, c), dotDecimal)
+		if err != nil {
+			return 0, err
+		}
+		sum += abs(v)
+	}
+	return sum, nil
+}
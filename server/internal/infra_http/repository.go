@@ -23,6 +23,10 @@ func NewTickerRepository(baseUrl string, currencyRepository domain.CurrencyRepos
 	return &TickerRepository{baseUrl: baseUrl, cr: currencyRepository, l: logger}
 }
 
+func (r *TickerRepository) Source() string {
+	return domain.TickerInfoApiProvider
+}
+
 func (r *TickerRepository) FindByTicker(ticker string, currency *string) (domain.Ticker, error) {
 	lastYear := time.Now().AddDate(-1, 0, 0)
 	firstDayOfLastYearMonth := time.Date(lastYear.Year(), lastYear.Month(), 1, 0, 0, 0, 0, lastYear.Location())
@@ -86,7 +90,40 @@ func (r *TickerRepository) FindMultipleTickers(tickers []string, currency *strin
 	return tickersMap, nil
 }
 
+// FindPriceHistory returns the ticker with its daily prices since start, in the currency
+// of the ticker
+func (r *TickerRepository) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	url := fmt.Sprintf("%s/%s?history_resample=day&history_start=%s", r.baseUrl, ticker, start.String())
+	r.l.Debug("Fetching price history", "url", url)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		r.l.Error("Failed to fetch price history", "error", err.Error())
+		return domain.Ticker{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return domain.Ticker{}, errors.New("could not find ticker")
+	}
+
+	t := &domain.Ticker{}
+	if err := t.FromJSON(resp.Body); err != nil {
+		r.l.Error("Failed to parse price history", "error", err.Error())
+		return domain.Ticker{}, err
+	}
+	r.l.Debug("Fetched price history", "ticker", t.Ticker, "prices", len(t.HistoricalData))
+
+	return r.mapper(*t, nil)
+}
+
 func (r *TickerRepository) mapper(ticker domain.Ticker, currency *string) (domain.Ticker, error) {
+	return MapTicker(ticker, currency, r.cr)
+}
+
+// MapTicker turns a ticker in the format of the ticker info API, with its currency as an
+// ISO code and the british ones in pence, to the domain one converted to the currency
+func MapTicker(ticker domain.Ticker, currency *string, cr domain.CurrencyRepository) (domain.Ticker, error) {
 	if ticker.Country == "United States" {
 		ticker.Country = "US"
 	}
@@ -102,14 +139,19 @@ func (r *TickerRepository) mapper(ticker domain.Ticker, currency *string) (domai
 		ticker.YearlyPriceRange.Max = ticker.YearlyPriceRange.Max / 100
 		ticker.HistoricalData = arrayutils.Map(ticker.HistoricalData, func(value domain.HistoricalEntry) domain.HistoricalEntry {
 			return domain.HistoricalEntry{
-				Date:  value.Date,
-				Price: value.Price / 100,
+				Date:     value.Date,
+				Price:    value.Price / 100,
+				Open:     value.Open / 100,
+				High:     value.High / 100,
+				Low:      value.Low / 100,
+				AdjClose: value.AdjClose / 100,
+				Volume:   value.Volume,
 			}
 		})
 	}
 
 	// Convert the price to the preferred currency
-	exchangeRates, err := r.cr.FindAllExchangeRates()
+	exchangeRates, err := cr.FindAllExchangeRates()
 	if err != nil {
 		return domain.Ticker{}, err
 	}
@@ -0,0 +1,331 @@
+package infra_http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// YahooRepository fetches the tickers from a Yahoo Finance style chart API, which has no
+// fundamentals, so the sector, country and industry of its tickers are left empty
+type YahooRepository struct {
+	baseUrl string
+	cr      domain.CurrencyRepository
+	l       *slog.Logger
+}
+
+func NewYahooRepository(baseUrl string, currencyRepository domain.CurrencyRepository, logger *slog.Logger) *YahooRepository {
+	return &YahooRepository{baseUrl: baseUrl, cr: currencyRepository, l: logger}
+}
+
+type yahooChart struct {
+	Chart struct {
+		Result []struct {
+			Meta struct {
+				Currency           string  `json:"currency"`
+				Symbol             string  `json:"symbol"`
+				InstrumentType     string  `json:"instrumentType"`
+				LongName           string  `json:"longName"`
+				ShortName          string  `json:"shortName"`
+				ExchangeName       string  `json:"exchangeName"`
+				FullExchangeName   string  `json:"fullExchangeName"`
+				RegularMarketPrice float32 `json:"regularMarketPrice"`
+				PreviousClose      float32 `json:"previousClose"`
+			} `json:"meta"`
+			Timestamp []int64 `json:"timestamp"`
+			Events    struct {
+				Dividends map[string]struct {
+					Amount float32 `json:"amount"`
+					Date   int64   `json:"date"`
+				} `json:"dividends"`
+			} `json:"events"`
+			Indicators struct {
+				Quote []struct {
+					Open   []*float32 `json:"open"`
+					High   []*float32 `json:"high"`
+					Low    []*float32 `json:"low"`
+					Close  []*float32 `json:"close"`
+					Volume []*int64   `json:"volume"`
+				} `json:"quote"`
+				AdjClose []struct {
+					AdjClose []*float32 `json:"adjclose"`
+				} `json:"adjclose"`
+			} `json:"indicators"`
+		} `json:"result"`
+		Error *struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	} `json:"chart"`
+}
+
+type yahooSearch struct {
+	Quotes []struct {
+		Symbol    string `json:"symbol"`
+		ShortName string `json:"shortname"`
+		LongName  string `json:"longname"`
+		Exchange  string `json:"exchDisp"`
+		QuoteType string `json:"quoteType"`
+	} `json:"quotes"`
+}
+
+func (r *YahooRepository) Source() string {
+	return domain.YahooProvider
+}
+
+func (r *YahooRepository) FindByTicker(ticker string, currency *string) (domain.Ticker, error) {
+	lastYear := time.Now().AddDate(-1, 0, 0)
+	firstDayOfLastYearMonth := time.Date(lastYear.Year(), lastYear.Month(), 1, 0, 0, 0, 0, lastYear.Location())
+
+	t, err := r.fetchChart(ticker, firstDayOfLastYearMonth)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+
+	// Keep the first close of every month, as the monthly history of the ticker info API
+	monthly := []domain.HistoricalEntry{}
+	for _, entry := range t.HistoricalData {
+		date := time.Time(entry.Date)
+		if len(monthly) == 0 || time.Time(monthly[len(monthly)-1].Date).Month() != date.Month() {
+			monthly = append(monthly, domain.HistoricalEntry{Date: entry.Date, Price: entry.Price})
+		}
+	}
+	t.HistoricalData = monthly
+
+	return MapTicker(t, currency, r.cr)
+}
+
+func (r *YahooRepository) FindMultipleTickers(tickers []string, currency *string) (map[string]domain.Ticker, error) {
+	tickersMap := map[string]domain.Ticker{}
+	var errs []error
+	for _, ticker := range tickers {
+		t, err := r.FindByTicker(ticker, currency)
+		if err != nil {
+			r.l.Warn("Failed to fetch ticker", "ticker", ticker, "error", err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		tickersMap[ticker] = t
+	}
+
+	if len(tickersMap) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return tickersMap, nil
+}
+
+// FindPriceHistory returns the ticker with its daily prices since start, in the currency
+// of the ticker
+func (r *YahooRepository) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	t, err := r.fetchChart(ticker, time.Time(start))
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+	return MapTicker(t, nil, r.cr)
+}
+
+// Search looks the query up in the symbol lookup of the API, which matches symbols, names
+// and ISINs but does not return the currency of the tickers
+func (r *YahooRepository) Search(q string, limit int) (domain.TickerSearchResults, error) {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("quotesCount", fmt.Sprint(limit))
+	query.Set("newsCount", "0")
+	searchUrl := fmt.Sprintf("%s/v1/finance/search?%s", r.baseUrl, query.Encode())
+	r.l.Debug("Searching tickers", "url", searchUrl)
+
+	req, err := http.NewRequest(http.MethodGet, searchUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.l.Error("Failed to search tickers", "error", err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ticker search failed with status %d", resp.StatusCode)
+	}
+
+	search := yahooSearch{}
+	if err := json.NewDecoder(resp.Body).Decode(&search); err != nil {
+		r.l.Error("Failed to parse ticker search", "error", err.Error())
+		return nil, err
+	}
+
+	// The quotes matched by ISIN do not carry it, but the query is the ISIN of all of them
+	var isin string
+	if domain.IsISIN(q) {
+		isin = strings.ToUpper(q)
+	}
+
+	results := domain.TickerSearchResults{}
+	for _, quote := range search.Quotes {
+		if quote.QuoteType != "EQUITY" && quote.QuoteType != "ETF" {
+			continue
+		}
+
+		result := domain.TickerSearchResult{
+			Ticker:   quote.Symbol,
+			Name:     quote.LongName,
+			Isin:     isin,
+			Exchange: quote.Exchange,
+			Type:     domain.StockAsset,
+		}
+		if result.Name == "" {
+			result.Name = quote.ShortName
+		}
+		if quote.QuoteType == "ETF" {
+			result.Type = domain.EtfAsset
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// fetchChart returns the ticker with its daily bars since start, in the format of the
+// ticker info API
+func (r *YahooRepository) fetchChart(ticker string, start time.Time) (domain.Ticker, error) {
+	query := url.Values{}
+	query.Set("period1", fmt.Sprint(start.Unix()))
+	query.Set("period2", fmt.Sprint(time.Now().Unix()))
+	query.Set("interval", "1d")
+	query.Set("events", "div")
+	chartUrl := fmt.Sprintf("%s/v8/finance/chart/%s?%s", r.baseUrl, url.PathEscape(ticker), query.Encode())
+	r.l.Debug("Fetching chart", "url", chartUrl)
+
+	req, err := http.NewRequest(http.MethodGet, chartUrl, nil)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+	// The API rejects the requests without a browser user agent
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.l.Error("Failed to fetch chart", "error", err.Error())
+		return domain.Ticker{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return domain.Ticker{}, errors.New("could not find ticker")
+	}
+
+	chart := yahooChart{}
+	if err := json.NewDecoder(resp.Body).Decode(&chart); err != nil {
+		r.l.Error("Failed to parse chart", "error", err.Error())
+		return domain.Ticker{}, err
+	}
+	if chart.Chart.Error != nil {
+		return domain.Ticker{}, errors.New(chart.Chart.Error.Description)
+	}
+	if len(chart.Chart.Result) == 0 {
+		return domain.Ticker{}, errors.New("could not find ticker")
+	}
+	result := chart.Chart.Result[0]
+	meta := result.Meta
+
+	t := domain.Ticker{
+		Ticker:         ticker,
+		Name:           meta.LongName,
+		Price:          meta.RegularMarketPrice,
+		Currency:       meta.Currency,
+		Exchange:       meta.FullExchangeName,
+		IsEtf:          meta.InstrumentType == "ETF",
+		EarningDates:   []domain.DateWithTime{},
+		HistoricalData: []domain.HistoricalEntry{},
+	}
+	if t.Name == "" {
+		t.Name = meta.ShortName
+	}
+	if t.Exchange == "" {
+		t.Exchange = meta.ExchangeName
+	}
+
+	if len(result.Indicators.Quote) > 0 {
+		quote := result.Indicators.Quote[0]
+		var adjCloses []*float32
+		if len(result.Indicators.AdjClose) > 0 {
+			adjCloses = result.Indicators.AdjClose[0].AdjClose
+		}
+
+		for i, ts := range result.Timestamp {
+			if i >= len(quote.Close) || quote.Close[i] == nil {
+				continue
+			}
+			date := time.Unix(ts, 0).UTC()
+			t.HistoricalData = append(t.HistoricalData, domain.HistoricalEntry{
+				Date:     domain.Date(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)),
+				Price:    *quote.Close[i],
+				Open:     valueAt(quote.Open, i),
+				High:     valueAt(quote.High, i),
+				Low:      valueAt(quote.Low, i),
+				AdjClose: valueAt(adjCloses, i),
+				Volume:   valueAt(quote.Volume, i),
+			})
+		}
+	}
+
+	previousClose := meta.PreviousClose
+	if previousClose == 0 && len(t.HistoricalData) > 1 {
+		previousClose = t.HistoricalData[len(t.HistoricalData)-2].Price
+	}
+	if previousClose > 0 {
+		t.ChangeRate = (t.Price/previousClose - 1) * 100
+	}
+
+	monthAgo, yearAgo := time.Now().AddDate(0, -1, 0), time.Now().AddDate(-1, 0, 0)
+	t.MonthlyPriceRange = domain.PriceRange{Min: t.Price, Max: t.Price}
+	t.YearlyPriceRange = domain.PriceRange{Min: t.Price, Max: t.Price}
+	for _, entry := range t.HistoricalData {
+		date := time.Time(entry.Date)
+		if date.After(yearAgo) {
+			t.YearlyPriceRange.Min = min(t.YearlyPriceRange.Min, entry.Price)
+			t.YearlyPriceRange.Max = max(t.YearlyPriceRange.Max, entry.Price)
+		}
+		if date.After(monthAgo) {
+			t.MonthlyPriceRange.Min = min(t.MonthlyPriceRange.Min, entry.Price)
+			t.MonthlyPriceRange.Max = max(t.MonthlyPriceRange.Max, entry.Price)
+		}
+	}
+
+	for _, dividend := range result.Events.Dividends {
+		if time.Unix(dividend.Date, 0).After(yearAgo) {
+			t.YearlyDividendValue += dividend.Amount
+		}
+	}
+	// The dividends are paid in pence, as the prices, and MapTicker only converts the prices
+	if t.Currency == "GBp" {
+		t.YearlyDividendValue = t.YearlyDividendValue / 100
+	}
+	if t.Price > 0 {
+		yearlyPrice := t.Price
+		if t.Currency == "GBp" {
+			yearlyPrice = t.Price / 100
+		}
+		t.YearlyDividendYield = t.YearlyDividendValue / yearlyPrice
+	}
+
+	r.l.Debug("Fetched chart", "ticker", ticker, "prices", len(t.HistoricalData))
+	return t, nil
+}
+
+// valueAt returns the value of the series at i, zero when it is missing
+func valueAt[T float32 | int64](values []*T, i int) T {
+	if i >= len(values) || values[i] == nil {
+		return 0
+	}
+	return *values[i]
+}
@@ -0,0 +1,130 @@
+package marketdata
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// Chain asks its providers in order, falling through to the next one when a provider fails
+// or does not know a ticker. The tickers it returns carry the source of the provider that
+// served them.
+type Chain struct {
+	providers []domain.MarketDataProvider
+	l         *slog.Logger
+}
+
+func NewChain(providers []domain.MarketDataProvider, logger *slog.Logger) *Chain {
+	return &Chain{providers: providers, l: logger}
+}
+
+func (c *Chain) Source() string {
+	if len(c.providers) == 1 {
+		return c.providers[0].Source()
+	}
+	return "chain"
+}
+
+func (c *Chain) FindByTicker(ticker string, currency *string) (domain.Ticker, error) {
+	errs := []error{}
+	for _, p := range c.providers {
+		t, err := p.FindByTicker(ticker, currency)
+		if err != nil {
+			c.l.Warn("Market data provider failed, trying the next one", "source", p.Source(), "ticker", ticker, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", p.Source(), err))
+			continue
+		}
+		t.Source = p.Source()
+		return t, nil
+	}
+	return domain.Ticker{}, providersError(ticker, errs)
+}
+
+func (c *Chain) FindMultipleTickers(tickers []string, currency *string) (map[string]domain.Ticker, error) {
+	tickersMap := map[string]domain.Ticker{}
+	missing := tickers
+	errs := []error{}
+	for _, p := range c.providers {
+		if len(missing) == 0 {
+			break
+		}
+
+		found, err := p.FindMultipleTickers(missing, currency)
+		if err != nil {
+			c.l.Warn("Market data provider failed, trying the next one", "source", p.Source(), "tickers", missing, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", p.Source(), err))
+			continue
+		}
+
+		stillMissing := []string{}
+		for _, ticker := range missing {
+			t, ok := found[ticker]
+			if !ok {
+				stillMissing = append(stillMissing, ticker)
+				continue
+			}
+			t.Source = p.Source()
+			tickersMap[ticker] = t
+		}
+		missing = stillMissing
+	}
+
+	if len(tickersMap) == 0 && len(tickers) > 0 {
+		return nil, providersError(fmt.Sprint(tickers), errs)
+	}
+	if len(missing) > 0 {
+		c.l.Warn("No market data provider found the tickers", "tickers", missing)
+	}
+	return tickersMap, nil
+}
+
+func (c *Chain) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	errs := []error{}
+	for _, p := range c.providers {
+		t, err := p.FindPriceHistory(ticker, start)
+		if err != nil {
+			c.l.Warn("Market data provider failed, trying the next one", "source", p.Source(), "ticker", ticker, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", p.Source(), err))
+			continue
+		}
+		t.Source = p.Source()
+		return t, nil
+	}
+	return domain.Ticker{}, providersError(ticker, errs)
+}
+
+// Search asks the providers that can search in order, returning the results of the first
+// one that finds any
+func (c *Chain) Search(query string, limit int) (domain.TickerSearchResults, error) {
+	errs := []error{}
+	for _, p := range c.providers {
+		searcher, ok := p.(domain.TickerSearchRepository)
+		if !ok {
+			continue
+		}
+
+		results, err := searcher.Search(query, limit)
+		if err != nil {
+			c.l.Warn("Market data provider failed to search, trying the next one", "source", p.Source(), "query", query, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", p.Source(), err))
+			continue
+		}
+		if len(results) > 0 {
+			return results, nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no market data provider could search %s: %w", query, errors.Join(errs...))
+	}
+	return domain.TickerSearchResults{}, nil
+}
+
+func providersError(ticker string, errs []error) error {
+	if len(errs) == 0 {
+		return fmt.Errorf("no market data provider found %s", ticker)
+	}
+	return fmt.Errorf("no market data provider found %s: %w", ticker, errors.Join(errs...))
+}
@@ -0,0 +1,59 @@
+package marketdata
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/infra_http"
+)
+
+const defaultYahooFinanceApi = "https://query1.finance.yahoo.com"
+
+// NewChainFromEnv builds the chain of the providers listed in MARKET_DATA_PROVIDERS, in
+// priority order, defaulting to the ticker info API alone. Each provider reads its own
+// settings: TICKER_INFO_API, YAHOO_FINANCE_API and MARKET_DATA_DIR.
+func NewChainFromEnv(cr domain.CurrencyRepository, manual domain.MarketDataProvider, logger *slog.Logger) (*Chain, error) {
+	names := []string{domain.TickerInfoApiProvider}
+	if value, present := os.LookupEnv("MARKET_DATA_PROVIDERS"); present && strings.TrimSpace(value) != "" {
+		names = strings.Split(value, ",")
+	}
+
+	providers := []domain.MarketDataProvider{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(domain.MarketDataProviders, name) {
+			return nil, fmt.Errorf("unknown market data provider %s", name)
+		}
+
+		switch name {
+		case domain.TickerInfoApiProvider:
+			tickerInfoUrl, present := os.LookupEnv("TICKER_INFO_API")
+			if !present {
+				return nil, errors.New("TICKER_INFO_API not found")
+			}
+			providers = append(providers, infra_http.NewTickerRepository(tickerInfoUrl, cr, logger))
+		case domain.YahooProvider:
+			yahooUrl, present := os.LookupEnv("YAHOO_FINANCE_API")
+			if !present {
+				yahooUrl = defaultYahooFinanceApi
+			}
+			providers = append(providers, infra_http.NewYahooRepository(yahooUrl, cr, logger))
+		case domain.FileProvider:
+			dir, present := os.LookupEnv("MARKET_DATA_DIR")
+			if !present {
+				return nil, errors.New("MARKET_DATA_DIR not found")
+			}
+			providers = append(providers, NewFileProvider(dir, cr, logger))
+		case domain.ManualProvider:
+			providers = append(providers, manual)
+		}
+	}
+
+	logger.Debug("Market data providers", "providers", names)
+	return NewChain(providers, logger), nil
+}
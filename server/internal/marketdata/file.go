@@ -0,0 +1,143 @@
+package marketdata
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/infra_http"
+)
+
+// FileProvider serves the tickers from a directory, for offline development and tests.
+// Each ticker is a <ticker>.json file in the format of the ticker info API, and its daily
+// prices may be given in a <ticker>.csv file with a date,price row per day.
+type FileProvider struct {
+	dir string
+	cr  domain.CurrencyRepository
+	l   *slog.Logger
+}
+
+func NewFileProvider(dir string, currencyRepository domain.CurrencyRepository, logger *slog.Logger) *FileProvider {
+	return &FileProvider{dir: dir, cr: currencyRepository, l: logger}
+}
+
+func (p *FileProvider) Source() string {
+	return domain.FileProvider
+}
+
+func (p *FileProvider) FindByTicker(ticker string, currency *string) (domain.Ticker, error) {
+	t, err := p.readTicker(ticker)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+	return infra_http.MapTicker(t, currency, p.cr)
+}
+
+func (p *FileProvider) FindMultipleTickers(tickers []string, currency *string) (map[string]domain.Ticker, error) {
+	tickersMap := map[string]domain.Ticker{}
+	for _, ticker := range tickers {
+		t, err := p.FindByTicker(ticker, currency)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tickersMap[ticker] = t
+	}
+	return tickersMap, nil
+}
+
+// FindPriceHistory returns the ticker with its prices since start, from its csv file when
+// there is one, in the currency of the ticker
+func (p *FileProvider) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	t, err := p.readTicker(ticker)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+
+	prices, err := p.readPrices(ticker)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return domain.Ticker{}, err
+	}
+	if err == nil {
+		t.HistoricalData = prices
+	}
+
+	history := []domain.HistoricalEntry{}
+	for _, entry := range t.HistoricalData {
+		if !time.Time(entry.Date).Before(time.Time(start)) {
+			history = append(history, entry)
+		}
+	}
+	t.HistoricalData = history
+	return infra_http.MapTicker(t, nil, p.cr)
+}
+
+func (p *FileProvider) path(ticker string, extension string) string {
+	return filepath.Join(p.dir, filepath.Base(ticker)+extension)
+}
+
+func (p *FileProvider) readTicker(ticker string) (domain.Ticker, error) {
+	f, err := os.Open(p.path(ticker, ".json"))
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+	defer f.Close()
+
+	t := domain.Ticker{}
+	if err := t.FromJSON(f); err != nil {
+		p.l.Error("Failed to parse ticker file", "ticker", ticker, "error", err.Error())
+		return domain.Ticker{}, err
+	}
+	t.Ticker = ticker
+	return t, nil
+}
+
+func (p *FileProvider) readPrices(ticker string) ([]domain.HistoricalEntry, error) {
+	f, err := os.Open(p.path(ticker, ".csv"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	prices := []domain.HistoricalEntry{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		date, err := time.Parse(time.DateOnly, record[0])
+		if err != nil {
+			// The header has no date
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		price, err := strconv.ParseFloat(record[1], 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prices = append(prices, domain.HistoricalEntry{Date: domain.Date(date), Price: float32(price)})
+	}
+
+	sort.SliceStable(prices, func(i, j int) bool {
+		return time.Time(prices[i].Date).Before(time.Time(prices[j].Date))
+	})
+	return prices, nil
+}
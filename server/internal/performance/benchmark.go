@@ -0,0 +1,52 @@
+package performance
+
+import (
+	"sort"
+	"time"
+)
+
+// BenchmarkValues returns what the cash flows would be worth at each date had they gone
+// into the benchmark instead. Money put in buys units at the price of its date and money
+// taken out sells them, never below zero. A date before the first price uses the first one.
+// Returns nil when the benchmark has no prices.
+func BenchmarkValues(flows []CashFlow, prices []Valuation, dates []time.Time) []float64 {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	sortedPrices := append([]Valuation{}, prices...)
+	sort.SliceStable(sortedPrices, func(i, j int) bool {
+		return sortedPrices[i].Date.Before(sortedPrices[j].Date)
+	})
+	priceAt := func(date time.Time) float64 {
+		i := sort.Search(len(sortedPrices), func(i int) bool {
+			return sortedPrices[i].Date.After(date)
+		})
+		if i == 0 {
+			return sortedPrices[0].Value
+		}
+		return sortedPrices[i-1].Value
+	}
+
+	sortedFlows := append([]CashFlow{}, flows...)
+	sort.SliceStable(sortedFlows, func(i, j int) bool {
+		return sortedFlows[i].Date.Before(sortedFlows[j].Date)
+	})
+
+	values := make([]float64, len(dates))
+	for i, date := range dates {
+		var units float64
+		for _, f := range sortedFlows {
+			if f.Date.After(date) {
+				break
+			}
+			price := priceAt(f.Date)
+			if price <= 0 {
+				continue
+			}
+			units = max(units-f.Amount/price, 0)
+		}
+		values[i] = units * priceAt(date)
+	}
+	return values
+}
@@ -0,0 +1,140 @@
+package performance
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// Windows the returns are computed over
+const (
+	YTD        string = "ytd"
+	OneYear    string = "1y"
+	ThreeYears string = "3y"
+	Inception  string = "inception"
+)
+
+// Compute returns the time-weighted return and the XIRR of the whole holdings and of each
+// ticker over every window. A window without a valuation at its start, or without flows
+// to compute a rate from, has no returns.
+func Compute(inputs domain.PerformanceInputs, now time.Time) domain.Performance {
+	flows := map[string][]CashFlow{}
+	all := []CashFlow{}
+	for _, f := range inputs.Flows {
+		flow := CashFlow{Date: time.Time(f.Date), Amount: float64(f.Amount)}
+		flows[f.Ticker] = append(flows[f.Ticker], flow)
+		all = append(all, flow)
+	}
+
+	performance := domain.Performance{
+		Currency: inputs.Currency,
+		Returns:  windowReturns(valuations(inputs.Valuations), all, now),
+		Tickers:  []domain.TickerPerformance{},
+	}
+
+	tickers := make([]string, 0, len(flows))
+	for ticker := range flows {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	for _, ticker := range tickers {
+		performance.Tickers = append(performance.Tickers, domain.TickerPerformance{
+			Ticker:  ticker,
+			Returns: windowReturns(valuations(inputs.TickerValuations[ticker]), flows[ticker], now),
+		})
+	}
+	return performance
+}
+
+func valuations(pvs []domain.PerformanceValuation) []Valuation {
+	vs := make([]Valuation, len(pvs))
+	for i, pv := range pvs {
+		vs[i] = Valuation{Date: time.Time(pv.Date), Value: float64(pv.Value)}
+	}
+	sort.SliceStable(vs, func(i, j int) bool {
+		return vs[i].Date.Before(vs[j].Date)
+	})
+	return vs
+}
+
+func windowReturns(vs []Valuation, flows []CashFlow, now time.Time) []domain.WindowReturn {
+	inception := now
+	for _, f := range flows {
+		if f.Date.Before(inception) {
+			inception = f.Date
+		}
+	}
+
+	starts := []struct {
+		window string
+		start  time.Time
+	}{
+		{YTD, time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{OneYear, now.AddDate(-1, 0, 0)},
+		{ThreeYears, now.AddDate(-3, 0, 0)},
+		{Inception, inception},
+	}
+
+	returns := make([]domain.WindowReturn, len(starts))
+	for i, s := range starts {
+		returns[i] = domain.WindowReturn{Window: s.window, Start: domain.Date(s.start)}
+
+		// Windows starting before the first flow are computed since inception, with
+		// nothing invested at their start
+		start, startValue := s.start, 0.0
+		if !start.After(inception) {
+			start = inception.AddDate(0, 0, -1)
+		} else {
+			v, ok := valueAt(vs, start)
+			if !ok {
+				continue
+			}
+			startValue = v
+		}
+
+		end, ok := valueAt(vs, now)
+		if !ok {
+			continue
+		}
+
+		windowValuations := []Valuation{{Date: start, Value: startValue}}
+		windowFlows := []CashFlow{{Date: start, Amount: -startValue}}
+		for _, v := range vs {
+			if v.Date.After(start) && !v.Date.After(now) {
+				windowValuations = append(windowValuations, v)
+			}
+		}
+		for _, f := range flows {
+			if f.Date.After(start) && !f.Date.After(now) {
+				windowFlows = append(windowFlows, f)
+			}
+		}
+		windowFlows = append(windowFlows, CashFlow{Date: now, Amount: end})
+
+		if twr, err := TimeWeightedReturn(windowValuations, windowFlows[1:len(windowFlows)-1]); err == nil {
+			returns[i].TWR = rate(twr)
+		}
+		if xirr, err := XIRR(windowFlows); err == nil {
+			returns[i].XIRR = rate(xirr)
+		}
+	}
+	return returns
+}
+
+// valueAt returns the last valuation on or before the date
+func valueAt(vs []Valuation, date time.Time) (float64, bool) {
+	value, found := 0.0, false
+	for _, v := range vs {
+		if v.Date.After(date) {
+			break
+		}
+		value, found = v.Value, true
+	}
+	return value, found
+}
+
+func rate(r float64) *float32 {
+	r32 := float32(r)
+	return &r32
+}
@@ -0,0 +1,185 @@
+package performance
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// ComputeRisk returns the risk metrics of the whole holdings and of each ticker still held,
+// from the returns between their valuations. Metrics are annualized with the average
+// length of the periods and riskFreeRate is an annual rate. Beta is computed against the
+// benchmark, when given.
+func ComputeRisk(inputs domain.PerformanceInputs, benchmark *domain.BenchmarkPrices, riskFreeRate float64) domain.Risk {
+	flows := map[string][]CashFlow{}
+	all := []CashFlow{}
+	for _, f := range inputs.Flows {
+		flow := CashFlow{Date: time.Time(f.Date), Amount: float64(f.Amount)}
+		flows[f.Ticker] = append(flows[f.Ticker], flow)
+		all = append(all, flow)
+	}
+
+	risk := domain.Risk{
+		Currency:     inputs.Currency,
+		RiskFreeRate: float32(riskFreeRate),
+		Tickers:      []domain.TickerRisk{},
+	}
+
+	prices := []Valuation{}
+	if benchmark != nil {
+		risk.Benchmark = benchmark.Ticker
+		prices = valuations(benchmark.Prices)
+	}
+	risk.Portfolio = riskMetrics(PeriodReturns(valuations(inputs.Valuations), all), prices, riskFreeRate)
+
+	tickers := make([]string, 0, len(inputs.TickerValuations))
+	for ticker, vs := range inputs.TickerValuations {
+		if len(vs) > 0 && vs[len(vs)-1].Value > 0 {
+			tickers = append(tickers, ticker)
+		}
+	}
+	sort.Strings(tickers)
+	for _, ticker := range tickers {
+		risk.Tickers = append(risk.Tickers, domain.TickerRisk{
+			Ticker:      ticker,
+			RiskMetrics: riskMetrics(PeriodReturns(valuations(inputs.TickerValuations[ticker]), flows[ticker]), prices, riskFreeRate),
+		})
+	}
+	return risk
+}
+
+func riskMetrics(returns []PeriodReturn, benchmark []Valuation, riskFreeRate float64) domain.RiskMetrics {
+	metrics := domain.RiskMetrics{}
+	if len(returns) == 0 {
+		return metrics
+	}
+
+	drawdown, peak, trough := MaxDrawdown(returns)
+	metrics.MaxDrawdown = rate(drawdown)
+	if drawdown < 0 {
+		peakDate, troughDate := domain.Date(peak), domain.Date(trough)
+		metrics.DrawdownPeak, metrics.DrawdownTrough = &peakDate, &troughDate
+	}
+
+	if len(returns) < 2 {
+		return metrics
+	}
+
+	days := returns[len(returns)-1].End.Sub(returns[0].Start).Hours() / 24
+	if days <= 0 {
+		return metrics
+	}
+	periodsPerYear := daysPerYear * float64(len(returns)) / days
+	riskFree := riskFreeRate / periodsPerYear
+
+	values := make([]float64, len(returns))
+	for i, r := range returns {
+		values[i] = r.Return
+	}
+	average := mean(values)
+	deviation := stdDev(values)
+	metrics.Volatility = rate(deviation * math.Sqrt(periodsPerYear))
+	if deviation > 0 {
+		metrics.Sharpe = rate((average - riskFree) / deviation * math.Sqrt(periodsPerYear))
+	}
+
+	var downside float64
+	for _, v := range values {
+		downside += math.Pow(min(v-riskFree, 0), 2)
+	}
+	if downside > 0 {
+		metrics.Sortino = rate((average - riskFree) / math.Sqrt(downside/float64(len(values))) * math.Sqrt(periodsPerYear))
+	}
+
+	if beta, ok := Beta(returns, benchmark); ok {
+		metrics.Beta = rate(beta)
+	}
+	return metrics
+}
+
+// MaxDrawdown returns the largest fall, as a negative rate, of the growth of the returns
+// from a peak, together with the dates of the peak and of the trough
+func MaxDrawdown(returns []PeriodReturn) (float64, time.Time, time.Time) {
+	if len(returns) == 0 {
+		return 0, time.Time{}, time.Time{}
+	}
+
+	growth, peakGrowth := 1.0, 1.0
+	peakDate := returns[0].Start
+	drawdown, drawdownPeak, drawdownTrough := 0.0, time.Time{}, time.Time{}
+	for _, r := range returns {
+		growth *= 1 + r.Return
+		if growth > peakGrowth {
+			peakGrowth, peakDate = growth, r.End
+			continue
+		}
+		if dd := growth/peakGrowth - 1; dd < drawdown {
+			drawdown, drawdownPeak, drawdownTrough = dd, peakDate, r.End
+		}
+	}
+	return drawdown, drawdownPeak, drawdownTrough
+}
+
+// Beta returns the beta of the returns against the benchmark prices. The returns are
+// chained between consecutive benchmark prices, so series of different frequencies can
+// be compared. Returns false when there are not two such periods to compare.
+func Beta(returns []PeriodReturn, benchmark []Valuation) (float64, bool) {
+	var own, market []float64
+	for i := 1; i < len(benchmark); i++ {
+		start, end := benchmark[i-1], benchmark[i]
+		if start.Value <= 0 {
+			continue
+		}
+
+		growth, periods := 1.0, 0
+		for _, r := range returns {
+			if r.Start.Before(start.Date) || r.End.After(end.Date) {
+				continue
+			}
+			growth *= 1 + r.Return
+			periods++
+		}
+		if periods == 0 {
+			continue
+		}
+		own = append(own, growth-1)
+		market = append(market, end.Value/start.Value-1)
+	}
+
+	if len(market) < 2 {
+		return 0, false
+	}
+	variance := math.Pow(stdDev(market), 2)
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance(own, market) / variance, true
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// stdDev returns the sample standard deviation
+func stdDev(values []float64) float64 {
+	return math.Sqrt(covariance(values, values))
+}
+
+// covariance returns the sample covariance of two series of the same length
+func covariance(a, b []float64) float64 {
+	if len(a) < 2 {
+		return 0
+	}
+	meanA, meanB := mean(a), mean(b)
+	var sum float64
+	for i := range a {
+		sum += (a[i] - meanA) * (b[i] - meanB)
+	}
+	return sum / float64(len(a)-1)
+}
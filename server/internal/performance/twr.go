@@ -0,0 +1,75 @@
+package performance
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNotEnoughValuations is returned when there is no period to chain the returns of
+var ErrNotEnoughValuations = errors.New("at least two valuations are needed")
+
+// Valuation is the value of the holdings at a date
+type Valuation struct {
+	Date  time.Time
+	Value float64
+}
+
+// PeriodReturn is the return between two consecutive valuations
+type PeriodReturn struct {
+	Start  time.Time
+	End    time.Time
+	Return float64
+}
+
+// TimeWeightedReturn chains the returns of the periods between consecutive valuations,
+// so the money put in or taken out does not distort them
+func TimeWeightedReturn(valuations []Valuation, flows []CashFlow) (float64, error) {
+	if len(valuations) < 2 {
+		return 0, ErrNotEnoughValuations
+	}
+
+	growth := 1.0
+	for _, p := range PeriodReturns(valuations, flows) {
+		growth *= 1 + p.Return
+	}
+	return growth - 1, nil
+}
+
+// PeriodReturns returns the return of each period between consecutive valuations. The
+// money put in during a period is taken as invested at its start and the money taken out
+// as withdrawn at its end. Periods with nothing invested are skipped.
+func PeriodReturns(valuations []Valuation, flows []CashFlow) []PeriodReturn {
+	sorted := append([]Valuation{}, valuations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	returns := []PeriodReturn{}
+	for i := 1; i < len(sorted); i++ {
+		start, end := sorted[i-1], sorted[i]
+
+		var in, out float64
+		for _, f := range flows {
+			if !f.Date.After(start.Date) || f.Date.After(end.Date) {
+				continue
+			}
+			if f.Amount < 0 {
+				in -= f.Amount
+			} else {
+				out += f.Amount
+			}
+		}
+
+		invested := start.Value + in
+		if invested <= 0 {
+			continue
+		}
+		returns = append(returns, PeriodReturn{
+			Start:  start.Date,
+			End:    end.Date,
+			Return: (end.Value+out)/invested - 1,
+		})
+	}
+	return returns
+}
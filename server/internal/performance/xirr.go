@@ -0,0 +1,143 @@
+package performance
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Solver settings. The rate is searched above -100%, where the present values are defined.
+const (
+	xirrTolerance     = 1e-9
+	xirrMaxIterations = 100
+	bisectIterations  = 200
+	minRate           = -0.999999
+	maxRate           = 1e6
+	daysPerYear       = 365
+)
+
+// ErrInvalidCashFlows is returned when the flows cannot have a rate of return: there are
+// no money in and money out, or all of them happen on the same day
+var ErrInvalidCashFlows = errors.New("cash flows need money in and out on different dates")
+
+// ErrNoConvergence is returned when no rate makes the present value of the flows zero
+var ErrNoConvergence = errors.New("rate of return did not converge")
+
+// CashFlow is money put in (negative) or taken out (positive) by the investor
+type CashFlow struct {
+	Date   time.Time
+	Amount float64
+}
+
+// XIRR returns the annual rate that makes the net present value of the dated cash flows
+// zero. Newton's method is tried first and bisection is the fallback when it diverges.
+func XIRR(flows []CashFlow) (float64, error) {
+	flows = nonZero(flows)
+	if err := validate(flows); err != nil {
+		return 0, err
+	}
+
+	sorted := append([]CashFlow{}, flows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	years := make([]float64, len(sorted))
+	for i, f := range sorted {
+		years[i] = f.Date.Sub(sorted[0].Date).Hours() / 24 / daysPerYear
+	}
+
+	if rate, ok := newton(sorted, years); ok {
+		return rate, nil
+	}
+	return bisect(sorted, years)
+}
+
+func nonZero(flows []CashFlow) []CashFlow {
+	kept := make([]CashFlow, 0, len(flows))
+	for _, f := range flows {
+		if f.Amount != 0 && !math.IsNaN(f.Amount) && !math.IsInf(f.Amount, 0) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func validate(flows []CashFlow) error {
+	var in, out bool
+	var first, last time.Time
+	for i, f := range flows {
+		in = in || f.Amount < 0
+		out = out || f.Amount > 0
+		if i == 0 || f.Date.Before(first) {
+			first = f.Date
+		}
+		if i == 0 || f.Date.After(last) {
+			last = f.Date
+		}
+	}
+	if !in || !out || !last.After(first) {
+		return ErrInvalidCashFlows
+	}
+	return nil
+}
+
+// npv returns the net present value of the flows at the rate and its derivative
+func npv(flows []CashFlow, years []float64, rate float64) (float64, float64) {
+	var value, derivative float64
+	for i, f := range flows {
+		discount := math.Pow(1+rate, years[i])
+		value += f.Amount / discount
+		derivative -= years[i] * f.Amount / (discount * (1 + rate))
+	}
+	return value, derivative
+}
+
+func newton(flows []CashFlow, years []float64) (float64, bool) {
+	rate := 0.1
+	for i := 0; i < xirrMaxIterations; i++ {
+		value, derivative := npv(flows, years, rate)
+		if derivative == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, false
+		}
+
+		next := rate - value/derivative
+		if next <= minRate || next > maxRate || math.IsNaN(next) {
+			return 0, false
+		}
+		if math.Abs(next-rate) < xirrTolerance {
+			return next, true
+		}
+		rate = next
+	}
+	return 0, false
+}
+
+// bisect looks for a change of sign of the present value, widening the upper bound of the
+// rate until there is one
+func bisect(flows []CashFlow, years []float64) (float64, error) {
+	low, high := minRate, 1.0
+	lowValue, _ := npv(flows, years, low)
+	highValue, _ := npv(flows, years, high)
+	for lowValue*highValue > 0 {
+		if high >= maxRate {
+			return 0, ErrNoConvergence
+		}
+		high *= 2
+		highValue, _ = npv(flows, years, high)
+	}
+
+	for i := 0; i < bisectIterations; i++ {
+		mid := (low + high) / 2
+		midValue, _ := npv(flows, years, mid)
+		if math.Abs(high-low) < xirrTolerance || midValue == 0 {
+			return mid, nil
+		}
+		if lowValue*midValue < 0 {
+			high = mid
+		} else {
+			low, lowValue = mid, midValue
+		}
+	}
+	return (low + high) / 2, nil
+}
@@ -0,0 +1,206 @@
+package performance
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// twoFlowsRate is the closed form of the rate of a single investment and withdrawal
+func twoFlowsRate(in, out CashFlow) float64 {
+	years := out.Date.Sub(in.Date).Hours() / 24 / daysPerYear
+	return math.Pow(out.Amount/-in.Amount, 1/years) - 1
+}
+
+func TestXIRR(t *testing.T) {
+	tests := []struct {
+		name      string
+		flows     []CashFlow
+		want      float64
+		tolerance float64
+	}{
+		{
+			// Example of the XIRR documentation of Excel
+			name: "excel example",
+			flows: []CashFlow{
+				{date(2008, time.January, 1), -10000},
+				{date(2008, time.March, 1), 2750},
+				{date(2008, time.October, 30), 4250},
+				{date(2009, time.February, 15), 3250},
+				{date(2009, time.April, 1), 2750},
+			},
+			want:      0.373362535,
+			tolerance: 1e-6,
+		},
+		{
+			name: "excel example unsorted",
+			flows: []CashFlow{
+				{date(2009, time.April, 1), 2750},
+				{date(2008, time.October, 30), 4250},
+				{date(2008, time.January, 1), -10000},
+				{date(2009, time.February, 15), 3250},
+				{date(2008, time.March, 1), 2750},
+			},
+			want:      0.373362535,
+			tolerance: 1e-6,
+		},
+		{
+			// Same as =XIRR({-1000,1100},{"2019-01-01","2020-01-01"}) in Excel
+			name: "one year",
+			flows: []CashFlow{
+				{date(2019, time.January, 1), -1000},
+				{date(2020, time.January, 1), 1100},
+			},
+			want:      0.1,
+			tolerance: 1e-9,
+		},
+		{
+			name: "loss",
+			flows: []CashFlow{
+				{date(2019, time.January, 1), -1000},
+				{date(2019, time.July, 1), 500},
+				{date(2020, time.January, 1), 300},
+			},
+			want:      -0.274755,
+			tolerance: 1e-6,
+		},
+		{
+			name: "zero flows are ignored",
+			flows: []CashFlow{
+				{date(2019, time.January, 1), -1000},
+				{date(2019, time.June, 1), 0},
+				{date(2020, time.January, 1), 1100},
+			},
+			want:      0.1,
+			tolerance: 1e-9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := XIRR(tt.flows)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-tt.want) > tt.tolerance {
+				t.Errorf("XIRR is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXIRRInvalidCashFlows(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows []CashFlow
+	}{
+		{name: "no flows"},
+		{
+			name: "single day",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), -1000},
+				{date(2020, time.January, 1), 1100},
+			},
+		},
+		{
+			name: "all negative",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), -1000},
+				{date(2020, time.June, 1), -500},
+			},
+		},
+		{
+			name: "all positive",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), 1000},
+				{date(2020, time.June, 1), 500},
+			},
+		},
+		{
+			name: "only zero and invalid amounts",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), 0},
+				{date(2020, time.June, 1), math.NaN()},
+				{date(2020, time.July, 1), math.Inf(1)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := XIRR(tt.flows); !errors.Is(err, ErrInvalidCashFlows) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidCashFlows)
+			}
+		})
+	}
+}
+
+func TestXIRRExtremeRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows []CashFlow
+		// Whether Newton's method is expected to diverge, leaving the rate to bisection
+		bisected bool
+	}{
+		{
+			name: "very large rate",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), -100},
+				{date(2020, time.January, 31), 200},
+			},
+		},
+		{
+			name: "almost everything lost",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), -1000},
+				{date(2021, time.January, 1), 1},
+			},
+			bisected: true,
+		},
+		{
+			name: "rate near -100%",
+			flows: []CashFlow{
+				{date(2020, time.January, 1), -1000},
+				{date(2021, time.January, 1), 0.001},
+			},
+			bisected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := twoFlowsRate(tt.flows[0], tt.flows[1])
+			years := []float64{0, tt.flows[1].Date.Sub(tt.flows[0].Date).Hours() / 24 / daysPerYear}
+			if _, converged := newton(tt.flows, years); converged == tt.bisected {
+				t.Fatalf("newton converged is %v, want %v", converged, !tt.bisected)
+			}
+
+			got, err := XIRR(tt.flows)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-want) > 1e-6*math.Max(1, math.Abs(want)) {
+				t.Errorf("XIRR is %v, want %v", got, want)
+			}
+			if got <= -1 {
+				t.Errorf("XIRR is %v, it must stay above -100%%", got)
+			}
+		})
+	}
+}
+
+func TestXIRRNoConvergence(t *testing.T) {
+	// Tripling the money in ten days is a rate far above the upper bound of the search
+	flows := []CashFlow{
+		{date(2020, time.January, 1), -100},
+		{date(2020, time.January, 11), 300},
+	}
+	if _, err := XIRR(flows); !errors.Is(err, ErrNoConvergence) {
+		t.Errorf("got error %v, want %v", err, ErrNoConvergence)
+	}
+}
@@ -0,0 +1,151 @@
+package portfolios
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	repository domain.PortfoliosRepository
+	l          *slog.Logger
+}
+
+func New(repository domain.PortfoliosRepository, logger *slog.Logger) *Handler {
+	return &Handler{
+		repository: repository,
+		l:          logger,
+	}
+}
+
+// CreatePortfolioHandler creates a new portfolio
+func (h *Handler) CreatePortfolioHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	portfolio := &domain.Portfolio{}
+	if err := portfolio.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := portfolio.Validate(); err != nil {
+		h.l.Error("Invalid portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	newPortfolio, err := h.repository.Create(*portfolio, user.Email)
+	if err != nil {
+		h.l.Error("Failed to create portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create portfolio")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := newPortfolio.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize portfolio", "error", err.Error())
+	}
+}
+
+// ListPortfoliosHandler returns all the portfolios of the user, the default one first
+func (h *Handler) ListPortfoliosHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	portfolios, err := h.repository.FindAll(user.Email)
+	if err != nil {
+		h.l.Error("Failed to get portfolios", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get portfolios")
+		return
+	}
+
+	if err := portfolios.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize portfolios", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize portfolios")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdatePortfolioHandler replaces the name, broker and base currency of a portfolio
+func (h *Handler) UpdatePortfolioHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	portfolio := &domain.Portfolio{}
+	if err := portfolio.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := portfolio.Validate(); err != nil {
+		h.l.Error("Invalid portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updatedPortfolio, err := h.repository.Update(id, *portfolio, user.Email)
+	if err != nil {
+		h.l.Error("Failed to update portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update portfolio")
+		return
+	}
+
+	if updatedPortfolio == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Portfolio not found")
+		return
+	}
+
+	if err := updatedPortfolio.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize portfolio")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// DeletePortfolioHandler deletes an empty portfolio other than the default one
+func (h *Handler) DeletePortfolioHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
+
+	err := h.repository.Delete(id, user.Email)
+	if errors.Is(err, domain.ErrPortfolioInUse) {
+		utils.SendHTTPMessage(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to delete portfolio", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete portfolio")
+		return
+	}
+
+	utils.SendHTTPMessage(w, http.StatusOK, "Portfolio deleted successfully")
+}
@@ -0,0 +1,133 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+var dividendTaxesHeader = []string{
+	"Country", "Treaty Rate", "Dividends", "Gross", "Source Tax", "Destination Tax", "Net", "Recoverable Deduction", "Currency",
+	"Approximate Rates",
+}
+
+// BuildDividendTaxesReport groups the dividend taxes by country. The deduction of each
+// dividend is the tax withheld at source capped by the treaty rate of the country.
+func BuildDividendTaxesReport(year int, preferredCurrency string, taxes domain.DividendTaxes, rates domain.TreatyRates) domain.DividendTaxesReport {
+	report := domain.DividendTaxesReport{
+		Year:      year,
+		Currency:  preferredCurrency,
+		Countries: []domain.CountryDividendTaxes{},
+	}
+
+	byCountry := map[string]int{}
+	for _, t := range taxes {
+		i, present := byCountry[t.Country]
+		if !present {
+			i = len(report.Countries)
+			byCountry[t.Country] = i
+			report.Countries = append(report.Countries, domain.CountryDividendTaxes{
+				Country:    t.Country,
+				TreatyRate: rates.Rate(t.Country),
+			})
+		}
+
+		country := &report.Countries[i]
+		deduction := min(t.SourceTax, t.Gross*country.TreatyRate/100)
+		country.Dividends++
+		addDividendTax(&country.DividendTaxesTotals, t, deduction)
+		addDividendTax(&report.Totals, t, deduction)
+	}
+
+	sort.SliceStable(report.Countries, func(i, j int) bool {
+		return report.Countries[i].Country < report.Countries[j].Country
+	})
+	return report
+}
+
+func addDividendTax(totals *domain.DividendTaxesTotals, t domain.DividendTax, deduction float32) {
+	totals.Gross += t.Gross
+	totals.SourceTax += t.SourceTax
+	totals.DestinationTax += t.DestinationTax
+	totals.Net += t.Net
+	totals.RecoverableDeduction += deduction
+	if t.ApproximateRate {
+		totals.ApproximateRates++
+	}
+}
+
+// WriteDividendTaxes writes the report in the given format
+func WriteDividendTaxes(w io.Writer, report domain.DividendTaxesReport, format string) error {
+	switch format {
+	case CSV:
+		return writeDividendTaxesCSV(w, report)
+	case JSON:
+		return report.ToJSON(w)
+	case PDF:
+		return writeDividendTaxesPDF(w, report)
+	default:
+		return fmt.Errorf("format %q not supported", format)
+	}
+}
+
+func dividendTaxesRecord(country string, treatyRate string, dividends string, totals domain.DividendTaxesTotals, currency string) []string {
+	return []string{
+		country,
+		treatyRate,
+		dividends,
+		formatFloat(totals.Gross),
+		formatFloat(totals.SourceTax),
+		formatFloat(totals.DestinationTax),
+		formatFloat(totals.Net),
+		formatFloat(totals.RecoverableDeduction),
+		currency,
+		fmt.Sprint(totals.ApproximateRates),
+	}
+}
+
+func writeDividendTaxesCSV(w io.Writer, report domain.DividendTaxesReport) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(dividendTaxesHeader); err != nil {
+		return err
+	}
+	for _, c := range report.Countries {
+		record := dividendTaxesRecord(c.Country, formatFloat(c.TreatyRate), fmt.Sprint(c.Dividends), c.DividendTaxesTotals, report.Currency)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	record := dividendTaxesRecord(fmt.Sprintf("Total %d", report.Year), "", "", report.Totals, report.Currency)
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDividendTaxesPDF(w io.Writer, report domain.DividendTaxesReport) error {
+	doc := newPDFDocument()
+	doc.Title(fmt.Sprintf("Dividend withholding taxes %d (%s)", report.Year, report.Currency))
+
+	row := "%-20s %8s %9s %12s %12s %15s %12s %12s"
+	doc.Line(fmt.Sprintf(row, "Country", "Treaty", "Dividends", "Gross", "Source tax", "Destination tax", "Net", "Deduction"))
+	for _, c := range report.Countries {
+		doc.Line(fmt.Sprintf(row, c.Country, formatFloat(c.TreatyRate)+"%", fmt.Sprint(c.Dividends),
+			formatFloat(c.Gross), formatFloat(c.SourceTax), formatFloat(c.DestinationTax),
+			formatFloat(c.Net), formatFloat(c.RecoverableDeduction),
+		))
+	}
+	doc.Line(fmt.Sprintf(row, "Total", "", "",
+		formatFloat(report.Totals.Gross), formatFloat(report.Totals.SourceTax), formatFloat(report.Totals.DestinationTax),
+		formatFloat(report.Totals.Net), formatFloat(report.Totals.RecoverableDeduction),
+	))
+	if report.Totals.ApproximateRates > 0 {
+		doc.Line("")
+		doc.Line(fmt.Sprintf("%d dividends were converted at the current exchange rate, the rate history starts later", report.Totals.ApproximateRates))
+	}
+
+	return doc.Write(w)
+}
@@ -0,0 +1,220 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+)
+
+// Supported report formats
+const (
+	CSV  string = "csv"
+	JSON string = "json"
+	PDF  string = "pdf"
+)
+
+type Handler struct {
+	rr domain.ReportsRepository
+	ur domain.UserRepository
+	l  *slog.Logger
+}
+
+func New(rr domain.ReportsRepository, ur domain.UserRepository, logger *slog.Logger) *Handler {
+	return &Handler{
+		rr: rr,
+		ur: ur,
+		l:  logger,
+	}
+}
+
+// RealizedGainsHandler returns the gains and losses of the sells of a tax year, the current
+// one by default. CSV and PDF formats are downloaded as a file.
+func (h *Handler) RealizedGainsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = JSON
+	}
+	if format != CSV && format != JSON && format != PDF {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Format must be one of csv, json or pdf")
+		return
+	}
+
+	year, err := parseYear(query.Get("year"))
+	if err != nil {
+		h.l.Error("Failed to parse year", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse year")
+		return
+	}
+
+	user, err := h.ur.FindByID(claims.User.Id)
+	if err != nil || user == nil {
+		h.l.Error("Failed to find user", "error", err)
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find user")
+		return
+	}
+
+	gains, err := h.rr.FindRealizedGains(user.Email, year, utils.PortfolioQuery(r))
+	if err != nil {
+		h.l.Error("Failed to compute realized gains", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to compute realized gains")
+		return
+	}
+
+	report := BuildRealizedGainsReport(year, *user.PreferredCurrency, gains)
+
+	w.Header().Set("Content-Type", ContentType(format))
+	if format != JSON {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="realized-gains-%d.%s"`, year, format))
+	}
+	if err := WriteRealizedGains(w, report, format); err != nil {
+		h.l.Error("Failed to write realized gains", "error", err.Error())
+	}
+}
+
+// DividendTaxesHandler returns the taxes withheld from the dividends of a tax year grouped
+// by country, with the deduction for double taxation capped by the treaty rates
+func (h *Handler) DividendTaxesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = JSON
+	}
+	if format != CSV && format != JSON && format != PDF {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Format must be one of csv, json or pdf")
+		return
+	}
+
+	year, err := parseYear(query.Get("year"))
+	if err != nil {
+		h.l.Error("Failed to parse year", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse year")
+		return
+	}
+
+	user, err := h.ur.FindByID(claims.User.Id)
+	if err != nil || user == nil {
+		h.l.Error("Failed to find user", "error", err)
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find user")
+		return
+	}
+
+	taxes, err := h.rr.FindDividendTaxes(user.Email, year, utils.PortfolioQuery(r))
+	if err != nil {
+		h.l.Error("Failed to compute dividend taxes", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to compute dividend taxes")
+		return
+	}
+
+	rates, err := h.rr.FindTreatyRates(user.Email)
+	if err != nil {
+		h.l.Error("Failed to find treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find treaty rates")
+		return
+	}
+
+	report := BuildDividendTaxesReport(year, *user.PreferredCurrency, taxes, rates)
+
+	w.Header().Set("Content-Type", ContentType(format))
+	if format != JSON {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dividend-taxes-%d.%s"`, year, format))
+	}
+	if err := WriteDividendTaxes(w, report, format); err != nil {
+		h.l.Error("Failed to write dividend taxes", "error", err.Error())
+	}
+}
+
+// ListTreatyRatesHandler returns the treaty rates used to cap the dividend deductions
+func (h *Handler) ListTreatyRatesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	rates, err := h.rr.FindTreatyRates(user.Email)
+	if err != nil {
+		h.l.Error("Failed to find treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find treaty rates")
+		return
+	}
+
+	if err := rates.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize treaty rates")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// UpdateTreatyRatesHandler replaces the treaty rates of the user
+func (h *Handler) UpdateTreatyRatesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	rates := domain.TreatyRates{}
+	if err := rates.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := rates.Validate(); err != nil {
+		h.l.Error("Invalid treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updatedRates, err := h.rr.UpdateTreatyRates(rates, user.Email)
+	if err != nil {
+		h.l.Error("Failed to update treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update treaty rates")
+		return
+	}
+
+	if err := updatedRates.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize treaty rates", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize treaty rates")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// ContentType returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case PDF:
+		return "application/pdf"
+	default:
+		return "application/json"
+	}
+}
+
+// parseYear parses the tax year of a report, an empty one is the current year
+func parseYear(year string) (int, error) {
+	if year == "" {
+		return time.Now().Year(), nil
+	}
+
+	parsed, err := strconv.Atoi(year)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 1900 || parsed > 9999 {
+		return 0, errors.New("year out of range")
+	}
+	return parsed, nil
+}
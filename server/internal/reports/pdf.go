@@ -0,0 +1,125 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Layout of the pages, an A4 sheet in landscape with a monospaced font so the columns of
+// the reports can be aligned with spaces
+const (
+	pdfPageWidth  = 842
+	pdfPageHeight = 595
+	pdfMargin     = 36
+	pdfFontSize   = 8
+	pdfTitleSize  = 14
+	pdfLeading    = 11
+)
+
+// pdfDocument is a minimal PDF writer for text reports, one line after the other
+type pdfDocument struct {
+	title string
+	lines []string
+}
+
+func newPDFDocument() *pdfDocument {
+	return &pdfDocument{}
+}
+
+// Title sets the heading shown on top of the first page
+func (d *pdfDocument) Title(title string) {
+	d.title = title
+}
+
+// Line appends a line of text, a new page starts when the current one is full
+func (d *pdfDocument) Line(line string) {
+	d.lines = append(d.lines, line)
+}
+
+// pages splits the lines in the content streams of each page
+func (d *pdfDocument) pages() []string {
+	pages := []string{}
+	var content strings.Builder
+	y := pdfPageHeight - pdfMargin
+	if d.title != "" {
+		fmt.Fprintf(&content, "BT /F2 %d Tf %d %d Td (%s) Tj ET\n", pdfTitleSize, pdfMargin, y-pdfTitleSize, pdfEscape(d.title))
+		y -= pdfTitleSize + 2*pdfLeading
+	}
+
+	for _, line := range d.lines {
+		if y-pdfLeading < pdfMargin {
+			pages = append(pages, content.String())
+			content.Reset()
+			y = pdfPageHeight - pdfMargin
+		}
+		y -= pdfLeading
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", pdfFontSize, pdfMargin, y, pdfEscape(line))
+	}
+	return append(pages, content.String())
+}
+
+// Write serializes the document, keeping the offset of every object for the xref table
+func (d *pdfDocument) Write(w io.Writer) error {
+	pages := d.pages()
+
+	// 1: catalog, 2: pages, 3 and 4: fonts, then a page and its content per page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for _, content := range pages {
+		pageId := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageId))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, pageId+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// pdfEscape escapes the text of a string literal and encodes the currency symbols in
+// WinAnsiEncoding, other characters outside ASCII are replaced
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString("\\200")
+		case r == '£':
+			b.WriteString("\\243")
+		case r < 128:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
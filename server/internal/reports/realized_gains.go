@@ -0,0 +1,178 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+var realizedGainsHeader = []string{
+	"Ticker", "Term", "Units", "Acquisition Date", "Sell Date", "Currency", "Proceeds", "Cost Basis", "Fees", "Gain",
+	"Preferred Currency", "Exchange Rate", "Preferred Proceeds", "Preferred Cost Basis", "Preferred Fees", "Preferred Gain",
+	"Approximate Rate",
+}
+
+// BuildRealizedGainsReport sorts the gains by date and adds them up per ticker and for the
+// whole year in the preferred currency
+func BuildRealizedGainsReport(year int, preferredCurrency string, gains domain.RealizedGains) domain.RealizedGainsReport {
+	sort.SliceStable(gains, func(i, j int) bool {
+		return time.Time(gains[i].Date).Before(time.Time(gains[j].Date))
+	})
+
+	report := domain.RealizedGainsReport{
+		Year:     year,
+		Currency: preferredCurrency,
+		Gains:    gains,
+		Tickers:  []domain.TickerRealizedGains{},
+	}
+
+	byTicker := map[string]int{}
+	for _, g := range gains {
+		i, present := byTicker[g.Ticker]
+		if !present {
+			i = len(report.Tickers)
+			byTicker[g.Ticker] = i
+			report.Tickers = append(report.Tickers, domain.TickerRealizedGains{Ticker: g.Ticker})
+		}
+		addRealizedGain(&report.Tickers[i].RealizedGainsTotals, g)
+		addRealizedGain(&report.Totals, g)
+	}
+
+	sort.SliceStable(report.Tickers, func(i, j int) bool {
+		return report.Tickers[i].Ticker < report.Tickers[j].Ticker
+	})
+	return report
+}
+
+func addRealizedGain(totals *domain.RealizedGainsTotals, g domain.RealizedGain) {
+	totals.Proceeds += g.PreferredProceeds
+	totals.CostBasis += g.PreferredCostBasis
+	totals.Fees += g.PreferredFees
+	totals.Gain += g.PreferredGain
+	if g.Term == domain.LongTerm {
+		totals.LongTermGain += g.PreferredGain
+	} else {
+		totals.ShortTermGain += g.PreferredGain
+	}
+}
+
+// WriteRealizedGains writes the report in the given format
+func WriteRealizedGains(w io.Writer, report domain.RealizedGainsReport, format string) error {
+	switch format {
+	case CSV:
+		return writeRealizedGainsCSV(w, report)
+	case JSON:
+		return report.ToJSON(w)
+	case PDF:
+		return writeRealizedGainsPDF(w, report)
+	default:
+		return fmt.Errorf("format %q not supported", format)
+	}
+}
+
+func formatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', 2, 32)
+}
+
+func realizedGainRecord(g domain.RealizedGain) []string {
+	return []string{
+		g.Ticker,
+		g.Term,
+		strconv.FormatFloat(float64(g.Units), 'f', -1, 32),
+		g.AcquisitionDate.String(),
+		g.Date.String(),
+		g.Currency,
+		formatFloat(g.Proceeds),
+		formatFloat(g.CostBasis),
+		formatFloat(g.Fees),
+		formatFloat(g.Gain),
+		g.PreferredCurrency,
+		strconv.FormatFloat(float64(g.ExchangeRate), 'f', -1, 32),
+		formatFloat(g.PreferredProceeds),
+		formatFloat(g.PreferredCostBasis),
+		formatFloat(g.PreferredFees),
+		formatFloat(g.PreferredGain),
+		strconv.FormatBool(g.ApproximateRate),
+	}
+}
+
+// totalsRecord places the totals below the preferred currency columns of the gains
+func totalsRecord(label string, totals domain.RealizedGainsTotals, currency string) []string {
+	record := make([]string, len(realizedGainsHeader))
+	record[0] = label
+	record[10] = currency
+	record[12] = formatFloat(totals.Proceeds)
+	record[13] = formatFloat(totals.CostBasis)
+	record[14] = formatFloat(totals.Fees)
+	record[15] = formatFloat(totals.Gain)
+	return record
+}
+
+func writeRealizedGainsCSV(w io.Writer, report domain.RealizedGainsReport) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(realizedGainsHeader); err != nil {
+		return err
+	}
+	for _, g := range report.Gains {
+		if err := cw.Write(realizedGainRecord(g)); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range report.Tickers {
+		if err := cw.Write(totalsRecord("Total "+t.Ticker, t.RealizedGainsTotals, report.Currency)); err != nil {
+			return err
+		}
+	}
+	if err := cw.Write(totalsRecord(fmt.Sprintf("Total %d", report.Year), report.Totals, report.Currency)); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeRealizedGainsPDF(w io.Writer, report domain.RealizedGainsReport) error {
+	doc := newPDFDocument()
+	doc.Title(fmt.Sprintf("Realized gains %d (%s)", report.Year, report.Currency))
+
+	row := "%-10s %-10s %10s %-10s %-10s %12s %12s %10s %12s%s"
+	doc.Line(fmt.Sprintf(row, "Ticker", "Term", "Units", "Acquired", "Sold", "Proceeds", "Cost basis", "Fees", "Gain", ""))
+	approximate := false
+	for _, g := range report.Gains {
+		mark := ""
+		if g.ApproximateRate {
+			mark = "*"
+			approximate = true
+		}
+		doc.Line(fmt.Sprintf(row,
+			g.Ticker, g.Term, strconv.FormatFloat(float64(g.Units), 'f', -1, 32),
+			g.AcquisitionDate.String(), g.Date.String(),
+			formatFloat(g.PreferredProceeds), formatFloat(g.PreferredCostBasis),
+			formatFloat(g.PreferredFees), formatFloat(g.PreferredGain), mark,
+		))
+	}
+	if approximate {
+		doc.Line("* Converted at the current exchange rate, the rate history starts later")
+	}
+
+	doc.Line("")
+	totals := "%-10s %12s %12s %10s %12s %12s %12s"
+	doc.Line(fmt.Sprintf(totals, "Ticker", "Proceeds", "Cost basis", "Fees", "Gain", "Short term", "Long term"))
+	for _, t := range report.Tickers {
+		doc.Line(fmt.Sprintf(totals, t.Ticker,
+			formatFloat(t.Proceeds), formatFloat(t.CostBasis), formatFloat(t.Fees),
+			formatFloat(t.Gain), formatFloat(t.ShortTermGain), formatFloat(t.LongTermGain),
+		))
+	}
+	doc.Line(fmt.Sprintf(totals, "Total",
+		formatFloat(report.Totals.Proceeds), formatFloat(report.Totals.CostBasis), formatFloat(report.Totals.Fees),
+		formatFloat(report.Totals.Gain), formatFloat(report.Totals.ShortTermGain), formatFloat(report.Totals.LongTermGain),
+	))
+
+	return doc.Write(w)
+}
@@ -0,0 +1,303 @@
+package sells
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// unitsTolerance absorbs the rounding errors of the units stored as float32
+const unitsTolerance = 1e-3
+
+// BedAndBreakfastWindow is the period after a sell in which the buys of the same ticker
+// are matched with it under the UK Section 104 rules
+const BedAndBreakfastWindow = 30 * 24 * time.Hour
+
+// ErrInvalidLotSelection is returned when the lots of a sell are not owned when selling
+var ErrInvalidLotSelection = errors.New("the selected lots are not owned at the sell date")
+
+// Lot is a buy together with the units of it that are still owned
+type Lot struct {
+	Buy   domain.BuyWithId
+	Units float32
+}
+
+// CostBasisMethod decides which lots the units of a sell come from
+type CostBasisMethod interface {
+	// Match returns the units taken from each lot when selling. Lots are sorted by date
+	// and include the buys after the sell, which most methods must skip.
+	Match(lots []Lot, sell domain.Sell) ([]float32, error)
+}
+
+type SellCostOutput struct {
+	MeanAcquisitionValue float32
+	AccumulatedFees      float32
+	// Matches are the lots the units come from, with the units taken from each of them
+	Matches []Lot
+}
+
+// NewCostBasisMethod returns the implementation of the method, FIFO when it is empty
+func NewCostBasisMethod(method string) (CostBasisMethod, error) {
+	switch method {
+	case "", domain.FIFO:
+		return fifo{}, nil
+	case domain.LIFO:
+		return lifo{}, nil
+	case domain.WeightedAverage:
+		return weightedAverage{}, nil
+	case domain.SpecificLot:
+		return specificLot{}, nil
+	case domain.Section104:
+		return section104{}, nil
+	}
+	return nil, fmt.Errorf("unsupported cost basis method %s", method)
+}
+
+// ComputeSellCost computes the acquisition value per unit and the fees of the buys matched
+// with the sell, once the previous sells have consumed their lots. Buys after the sell
+// date can be given, the method decides whether they are matched.
+func ComputeSellCost(method CostBasisMethod, buys domain.Buys, previousSells domain.Sells, sell domain.Sell) (SellCostOutput, error) {
+	lots, err := consumeLots(method, buys, previousSells)
+	if err != nil {
+		return SellCostOutput{}, err
+	}
+
+	matched, err := match(method, lots, sell)
+	if err != nil {
+		return SellCostOutput{}, err
+	}
+
+	var cost, fees float64
+	matches := []Lot{}
+	for i, units := range matched {
+		if units <= 0 {
+			continue
+		}
+		b := lots[i].Buy.Buy
+		cost += float64(units) * float64(b.Amount) / float64(b.Units)
+		fees += float64(units) * float64(b.Fee+b.Taxes) / float64(b.Units)
+		matches = append(matches, Lot{Buy: lots[i].Buy, Units: units})
+	}
+
+	return SellCostOutput{
+		MeanAcquisitionValue: float32(cost / float64(sell.Units)),
+		AccumulatedFees:      float32(fees),
+		Matches:              matches,
+	}, nil
+}
+
+// ComputeAvgPurchasePrice computes the average price, fees and taxes included, of the units
+// still owned once the sells have consumed their lots. Reinvested buys only account for
+// their fees and taxes when reinvestmentsAsFree is set.
+func ComputeAvgPurchasePrice(method CostBasisMethod, buys domain.Buys, sells domain.Sells, reinvestmentsAsFree bool) (float32, error) {
+	lots, err := consumeLots(method, buys, sells)
+	if err != nil {
+		return 0, err
+	}
+
+	var cost, units float64
+	for _, l := range lots {
+		b := l.Buy.Buy
+		total := b.Amount + b.Fee + b.Taxes
+		if reinvestmentsAsFree && b.IsReinvestment {
+			total -= b.Amount
+		}
+		cost += float64(l.Units) * float64(total) / float64(b.Units)
+		units += float64(l.Units)
+	}
+
+	if units < unitsTolerance {
+		return 0, nil
+	}
+	return float32(cost / units), nil
+}
+
+// consumeLots replays the sells in chronological order and returns the lots with the
+// units still owned
+func consumeLots(method CostBasisMethod, buys domain.Buys, sells domain.Sells) ([]Lot, error) {
+	lots := make([]Lot, len(buys))
+	for i, b := range buys {
+		lots[i] = Lot{Buy: b, Units: b.Buy.Units}
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		return time.Time(lots[i].Buy.Buy.Date).Before(time.Time(lots[j].Buy.Buy.Date))
+	})
+
+	sortedSells := make(domain.Sells, len(sells))
+	copy(sortedSells, sells)
+	sort.SliceStable(sortedSells, func(i, j int) bool {
+		return time.Time(sortedSells[i].Sell.Date).Before(time.Time(sortedSells[j].Sell.Date))
+	})
+
+	for _, s := range sortedSells {
+		matched, err := match(method, lots, s.Sell)
+		if err != nil {
+			return nil, err
+		}
+		for i, units := range matched {
+			lots[i].Units -= units
+		}
+	}
+	return lots, nil
+}
+
+// match checks the method took exactly the sold units from the lots
+func match(method CostBasisMethod, lots []Lot, sell domain.Sell) ([]float32, error) {
+	matched, err := method.Match(lots, sell)
+	if err != nil {
+		return nil, err
+	}
+
+	var total float32
+	for i, units := range matched {
+		if units > lots[i].Units+unitsTolerance {
+			return nil, ErrNotEnoughUnits
+		}
+		total += units
+	}
+	if total < sell.Units-unitsTolerance {
+		return nil, ErrNotEnoughUnits
+	}
+	return matched, nil
+}
+
+// ownedAt tells whether the lot was bought on or before the date
+func ownedAt(l Lot, date domain.Date) bool {
+	return !time.Time(l.Buy.Buy.Date).After(time.Time(date))
+}
+
+// takeInOrder takes the units from the lots following the order of the indexes
+func takeInOrder(lots []Lot, indexes []int, units float32, matched []float32) float32 {
+	for _, i := range indexes {
+		if units < unitsTolerance {
+			break
+		}
+		available := lots[i].Units - matched[i]
+		if available <= 0 {
+			continue
+		}
+		taken := min(available, units)
+		matched[i] += taken
+		units -= taken
+	}
+	return units
+}
+
+// takeProRata takes the units from the lots in proportion to the units left in each of them
+func takeProRata(lots []Lot, indexes []int, units float32, matched []float32) float32 {
+	var available float32
+	for _, i := range indexes {
+		available += lots[i].Units - matched[i]
+	}
+	if available <= 0 {
+		return units
+	}
+
+	taken := min(available, units)
+	for _, i := range indexes {
+		matched[i] += taken * (lots[i].Units - matched[i]) / available
+	}
+	return units - taken
+}
+
+// ownedIndexes returns the indexes of the lots owned at the date, oldest first
+func ownedIndexes(lots []Lot, date domain.Date) []int {
+	indexes := []int{}
+	for i, l := range lots {
+		if ownedAt(l, date) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// fifo takes the oldest lots first
+type fifo struct{}
+
+func (fifo) Match(lots []Lot, sell domain.Sell) ([]float32, error) {
+	matched := make([]float32, len(lots))
+	takeInOrder(lots, ownedIndexes(lots, sell.Date), sell.Units, matched)
+	return matched, nil
+}
+
+// lifo takes the newest lots first
+type lifo struct{}
+
+func (lifo) Match(lots []Lot, sell domain.Sell) ([]float32, error) {
+	indexes := ownedIndexes(lots, sell.Date)
+	for i, j := 0, len(indexes)-1; i < j; i, j = i+1, j-1 {
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+	}
+
+	matched := make([]float32, len(lots))
+	takeInOrder(lots, indexes, sell.Units, matched)
+	return matched, nil
+}
+
+// weightedAverage takes the units from all the lots owned, so they keep the average cost
+type weightedAverage struct{}
+
+func (weightedAverage) Match(lots []Lot, sell domain.Sell) ([]float32, error) {
+	matched := make([]float32, len(lots))
+	takeProRata(lots, ownedIndexes(lots, sell.Date), sell.Units, matched)
+	return matched, nil
+}
+
+// specificLot takes the lots chosen in the sell and the rest of the units following FIFO
+type specificLot struct{}
+
+func (specificLot) Match(lots []Lot, sell domain.Sell) ([]float32, error) {
+	matched := make([]float32, len(lots))
+	units := sell.Units
+	for _, sl := range sell.Lots {
+		found := false
+		for i, l := range lots {
+			if l.Buy.Id != sl.BuyId || !ownedAt(l, sell.Date) {
+				continue
+			}
+			if l.Units-matched[i] < sl.Units-unitsTolerance {
+				return nil, ErrInvalidLotSelection
+			}
+			matched[i] += sl.Units
+			units -= sl.Units
+			found = true
+			break
+		}
+		if !found {
+			return nil, ErrInvalidLotSelection
+		}
+	}
+
+	takeInOrder(lots, ownedIndexes(lots, sell.Date), units, matched)
+	return matched, nil
+}
+
+// section104 follows the UK rules: the buys of the same day are matched first, then the
+// ones of the next 30 days (bed and breakfast) and finally the pool of previous buys at
+// its average cost
+type section104 struct{}
+
+func (section104) Match(lots []Lot, sell domain.Sell) ([]float32, error) {
+	sellDate := time.Time(sell.Date)
+	sameDay, following, pool := []int{}, []int{}, []int{}
+	for i, l := range lots {
+		buyDate := time.Time(l.Buy.Buy.Date)
+		switch {
+		case buyDate.Equal(sellDate):
+			sameDay = append(sameDay, i)
+		case buyDate.After(sellDate) && !buyDate.After(sellDate.Add(BedAndBreakfastWindow)):
+			following = append(following, i)
+		case buyDate.Before(sellDate):
+			pool = append(pool, i)
+		}
+	}
+
+	matched := make([]float32, len(lots))
+	units := takeInOrder(lots, sameDay, sell.Units, matched)
+	units = takeInOrder(lots, following, units, matched)
+	takeProRata(lots, pool, units, matched)
+	return matched, nil
+}
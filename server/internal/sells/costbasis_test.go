@@ -0,0 +1,272 @@
+package sells
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+func day(n int) domain.Date {
+	return domain.Date(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n))
+}
+
+func buy(id string, d int, units, amount, fee float32) domain.BuyWithId {
+	return domain.BuyWithId{Id: id, Buy: domain.Buy{Ticker: "T", Units: units, Amount: amount, Fee: fee, Date: day(d)}}
+}
+
+func sell(d int, units float32, lots ...domain.SellLot) domain.SellWithId {
+	return domain.SellWithId{Sell: domain.Sell{Ticker: "T", Units: units, Date: day(d), Lots: lots}}
+}
+
+// Lots of 10 units bought at 10, 20 and 30 per unit
+var testBuys = domain.Buys{
+	buy("b1", 1, 10, 100, 1),
+	buy("b2", 5, 10, 200, 2),
+	buy("b3", 10, 10, 300, 0),
+}
+
+func TestComputeSellCost(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		buys          domain.Buys
+		previousSells domain.Sells
+		sell          domain.SellWithId
+		// Expected acquisition value per unit and accumulated fees
+		acquisitionValue float32
+		fees             float32
+		err              error
+	}{
+		{
+			name:             "fifo takes the oldest lots",
+			method:           domain.FIFO,
+			buys:             testBuys,
+			sell:             sell(10, 15),
+			acquisitionValue: (10*10 + 5*20) / 15.,
+			fees:             1 + 1,
+		},
+		{
+			name:             "fifo continues a partially sold lot",
+			method:           domain.FIFO,
+			buys:             testBuys,
+			previousSells:    domain.Sells{sell(6, 5)},
+			sell:             sell(10, 10),
+			acquisitionValue: (5*10 + 5*20) / 10.,
+			fees:             0.5 + 1,
+		},
+		{
+			name:             "fifo skips the buys after the sell",
+			method:           domain.FIFO,
+			buys:             testBuys,
+			previousSells:    domain.Sells{sell(2, 10)},
+			sell:             sell(6, 10),
+			acquisitionValue: 20,
+			fees:             2,
+		},
+		{
+			name:   "fifo fails without enough units",
+			method: domain.FIFO,
+			buys:   testBuys,
+			sell:   sell(6, 25),
+			err:    ErrNotEnoughUnits,
+		},
+		{
+			name:             "lifo takes the newest lots",
+			method:           domain.LIFO,
+			buys:             testBuys,
+			sell:             sell(10, 15),
+			acquisitionValue: (10*30 + 5*20) / 15.,
+			fees:             0 + 1,
+		},
+		{
+			name:             "lifo takes the buy of the same day first",
+			method:           domain.LIFO,
+			buys:             testBuys,
+			sell:             sell(5, 12),
+			acquisitionValue: (10*20 + 2*10) / 12.,
+			fees:             2 + 0.2,
+		},
+		{
+			name:             "lifo continues a partially sold lot",
+			method:           domain.LIFO,
+			buys:             testBuys,
+			previousSells:    domain.Sells{sell(10, 4)},
+			sell:             sell(12, 8),
+			acquisitionValue: (6*30 + 2*20) / 8.,
+			fees:             0 + 0.4,
+		},
+		{
+			name:             "weighted average takes from every lot owned",
+			method:           domain.WeightedAverage,
+			buys:             testBuys,
+			sell:             sell(6, 10),
+			acquisitionValue: (5*10 + 5*20) / 10.,
+			fees:             0.5 + 1,
+		},
+		{
+			name:             "weighted average keeps the proportions of partial lots",
+			method:           domain.WeightedAverage,
+			buys:             testBuys,
+			previousSells:    domain.Sells{sell(6, 10)},
+			sell:             sell(10, 10),
+			acquisitionValue: (2.5*10 + 2.5*20 + 5*30) / 10.,
+			fees:             0.25 + 0.5,
+		},
+		{
+			name:             "specific lot takes the selected lots and the rest by fifo",
+			method:           domain.SpecificLot,
+			buys:             testBuys,
+			sell:             sell(10, 10, domain.SellLot{BuyId: "b2", Units: 6}),
+			acquisitionValue: (6*20 + 4*10) / 10.,
+			fees:             1.2 + 0.4,
+		},
+		{
+			name:             "specific lot takes a lot bought the same day",
+			method:           domain.SpecificLot,
+			buys:             testBuys,
+			previousSells:    domain.Sells{sell(6, 5)},
+			sell:             sell(10, 10, domain.SellLot{BuyId: "b3", Units: 4}, domain.SellLot{BuyId: "b1", Units: 5}),
+			acquisitionValue: (4*30 + 5*10 + 1*20) / 10.,
+			fees:             0.5 + 0.2,
+		},
+		{
+			name:   "specific lot rejects a lot bought after the sell",
+			method: domain.SpecificLot,
+			buys:   testBuys,
+			sell:   sell(6, 5, domain.SellLot{BuyId: "b3", Units: 5}),
+			err:    ErrInvalidLotSelection,
+		},
+		{
+			name:   "specific lot rejects more units than left in the lot",
+			method: domain.SpecificLot,
+			buys:   testBuys,
+			// The previous sell follows fifo, leaving 2 units in b1
+			previousSells: domain.Sells{sell(6, 8)},
+			sell:          sell(10, 5, domain.SellLot{BuyId: "b1", Units: 5}),
+			err:           ErrInvalidLotSelection,
+		},
+		{
+			name:             "section 104 matches the buy of the same day first",
+			method:           domain.Section104,
+			buys:             testBuys,
+			sell:             sell(10, 12),
+			acquisitionValue: (10*30 + 1*10 + 1*20) / 12.,
+			fees:             0.1 + 0.2,
+		},
+		{
+			name:             "section 104 matches the buys of the next 30 days",
+			method:           domain.Section104,
+			buys:             testBuys,
+			sell:             sell(6, 5),
+			acquisitionValue: 30,
+			fees:             0,
+		},
+		{
+			name:             "section 104 leaves the buys after 30 days to the pool",
+			method:           domain.Section104,
+			buys:             append(domain.Buys{buy("b0", 0, 10, 100, 0)}, buy("b4", 60, 10, 500, 0)),
+			sell:             sell(20, 5),
+			acquisitionValue: 10,
+			fees:             0,
+		},
+		{
+			name:             "section 104 takes the rest from the pool at its average cost",
+			method:           domain.Section104,
+			buys:             testBuys,
+			sell:             sell(60, 15),
+			acquisitionValue: (5*10 + 5*20 + 5*30) / 15.,
+			fees:             0.5 + 1,
+		},
+		{
+			name:             "section 104 pool after a partial sell",
+			method:           domain.Section104,
+			buys:             testBuys[:2],
+			previousSells:    domain.Sells{sell(6, 10)},
+			sell:             sell(60, 4),
+			acquisitionValue: (2*10 + 2*20) / 4.,
+			fees:             0.2 + 0.4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := NewCostBasisMethod(tt.method)
+			if err != nil {
+				t.Fatalf("NewCostBasisMethod(%q) failed: %v", tt.method, err)
+			}
+
+			output, err := ComputeSellCost(method, tt.buys, tt.previousSells, tt.sell.Sell)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !closeTo(output.MeanAcquisitionValue, tt.acquisitionValue) {
+				t.Errorf("acquisition value is %v, want %v", output.MeanAcquisitionValue, tt.acquisitionValue)
+			}
+			if !closeTo(output.AccumulatedFees, tt.fees) {
+				t.Errorf("fees are %v, want %v", output.AccumulatedFees, tt.fees)
+			}
+
+			var matched float32
+			for _, m := range output.Matches {
+				matched += m.Units
+			}
+			if !closeTo(matched, tt.sell.Units) {
+				t.Errorf("matched %v units, want %v", matched, tt.sell.Units)
+			}
+		})
+	}
+}
+
+func TestComputeAvgPurchasePrice(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		sells  domain.Sells
+		want   float32
+	}{
+		{name: "no sells", method: domain.FIFO, want: (101 + 202 + 300) / 30.},
+		{name: "fifo partial lot", method: domain.FIFO, sells: domain.Sells{sell(6, 15)}, want: (101 + 300) / 15.},
+		{name: "lifo partial lot", method: domain.LIFO, sells: domain.Sells{sell(6, 15)}, want: (50.5 + 300) / 15.},
+		{name: "weighted average", method: domain.WeightedAverage, sells: domain.Sells{sell(6, 10)}, want: (50.5 + 101 + 300) / 20.},
+		{name: "sold on the day of the last buy", method: domain.Section104, sells: domain.Sells{sell(10, 10)}, want: (101 + 202) / 20.},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := NewCostBasisMethod(tt.method)
+			if err != nil {
+				t.Fatalf("NewCostBasisMethod(%q) failed: %v", tt.method, err)
+			}
+
+			got, err := ComputeAvgPurchasePrice(method, testBuys, tt.sells, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !closeTo(got, tt.want) {
+				t.Errorf("average price is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCostBasisMethod(t *testing.T) {
+	if _, err := NewCostBasisMethod(""); err != nil {
+		t.Errorf("the empty method should default to fifo, got %v", err)
+	}
+	if _, err := NewCostBasisMethod("random"); err == nil {
+		t.Error("an unknown method should fail")
+	}
+}
+
+func closeTo(got, want float32) bool {
+	return math.Abs(float64(got-want)) < 1e-3
+}
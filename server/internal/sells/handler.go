@@ -2,9 +2,10 @@ package sells
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
@@ -15,13 +16,15 @@ import (
 )
 
 type Handler struct {
-	sr domain.SellsRepository
-	br domain.BuysRepository
-	l  *slog.Logger
+	sr  domain.SellsRepository
+	br  domain.BuysRepository
+	car domain.CorporateActionsRepository
+	ur  domain.UserRepository
+	l   *slog.Logger
 }
 
-func New(sr domain.SellsRepository, br domain.BuysRepository, l *slog.Logger) *Handler {
-	return &Handler{sr: sr, br: br, l: l}
+func New(sr domain.SellsRepository, br domain.BuysRepository, car domain.CorporateActionsRepository, ur domain.UserRepository, l *slog.Logger) *Handler {
+	return &Handler{sr: sr, br: br, car: car, ur: ur, l: l}
 }
 
 type CreateSellRequest struct {
@@ -31,12 +34,15 @@ type CreateSellRequest struct {
 	Amount   float32     `json:"amount" validate:"required,gt=0"`
 	Currency string      `json:"currency" validate:"required,eq=$|eq=€|eq=£"`
 	Date     domain.Date `json:"date" validate:"required"`
+	// UseCashAccount credits the proceeds to the cash account of the currency
+	UseCashAccount bool   `json:"useCashAccount"`
+	PortfolioId    string `json:"portfolioId"`
+	// Lots are the buys the units come from with the specific lot method
+	Lots []domain.SellLot `json:"lots" validate:"dive"`
 }
 
-type sellFIFORuleOutput struct {
-	meanAcquisitionValue float32
-	accumulatedFees      float32
-}
+// ErrNotEnoughUnits is returned when a sell exceeds the units still owned
+var ErrNotEnoughUnits = errors.New("not enough units to sell")
 
 func (h *Handler) CreateSellHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
@@ -55,42 +61,92 @@ func (h *Handler) CreateSellHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	buys, err := h.br.FindByTickerAndCurrency(csr.Ticker, csr.Currency, userEmail)
+	actions, err := h.car.FindAll(userEmail)
 	if err != nil {
-		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find buys")
+		h.l.Error("Failed to find corporate actions", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find corporate actions")
 		return
 	}
 
-	if len(buys) == 0 {
-		utils.SendHTTPMessage(w, http.StatusBadRequest, "No buys found for the given ticker and currency")
+	dbUser, err := h.ur.FindByEmail(userEmail)
+	if err != nil {
+		h.l.Error("Failed to find user", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find user")
+		return
+	}
+	if dbUser == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	alreadySold, err := h.sr.FindByTicker(csr.Ticker, userEmail)
+	method, err := NewCostBasisMethod(dbUser.CostBasisMethod)
 	if err != nil {
-		h.l.Error("Failed to find previous sells", "error", err.Error())
-		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find previous sells")
+		h.l.Error("Invalid cost basis method", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Invalid cost basis method")
 		return
 	}
 
-	fifo, err := computeFIFOSellRule(buys, alreadySold, csr.Units)
-	if err != nil {
-		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+	// Lots bought under a previous symbol or in the parent of a spin-off are sold as well.
+	// The cost basis method applies to all the lots of the user, no matter the portfolio.
+	buys := domain.Buys{}
+	alreadySold := domain.Sells{}
+	for _, ticker := range actions.SourceTickers(csr.Ticker) {
+		tickerBuys, err := h.br.FindByTickerAndCurrency(ticker, csr.Currency, userEmail, nil)
+		if err != nil {
+			utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find buys")
+			return
+		}
+		buys = append(buys, tickerBuys...)
+
+		tickerSells, err := h.sr.FindByTicker(ticker, userEmail, nil)
+		if err != nil {
+			h.l.Error("Failed to find previous sells", "error", err.Error())
+			utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find previous sells")
+			return
+		}
+		alreadySold = append(alreadySold, tickerSells...)
+	}
+	alreadySold = arrayutils.Filter(alreadySold, func(s domain.SellWithId) bool {
+		return !time.Time(s.Sell.Date).After(time.Time(csr.Date))
+	})
+
+	// Units are expressed as of the sell date, so corporate actions in between are taken into account
+	buys, alreadySold = ApplyCorporateActions(buys, alreadySold, actions, time.Time(csr.Date))
+	buys, alreadySold = FilterByTicker(buys, alreadySold, csr.Ticker)
+	if len(buys) == 0 {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "No buys found for the given ticker and currency")
 		return
 	}
 
 	sell := domain.Sell{
-		Units:            csr.Units,
-		Ticker:           csr.Ticker,
-		AcquisitionValue: fifo.meanAcquisitionValue,
-		AccumulatedFees:  fifo.accumulatedFees,
-		Amount:           csr.Amount,
-		Currency:         csr.Currency,
-		Date:             csr.Date,
-		Fees:             csr.Fees,
+		Units:          csr.Units,
+		Ticker:         csr.Ticker,
+		Amount:         csr.Amount,
+		Currency:       csr.Currency,
+		Date:           csr.Date,
+		Fees:           csr.Fees,
+		UseCashAccount: csr.UseCashAccount,
+		PortfolioId:    csr.PortfolioId,
+		Lots:           csr.Lots,
+	}
+
+	cost, err := ComputeSellCost(method, buys, alreadySold, sell)
+	if err != nil {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	sell.AcquisitionValue = cost.MeanAcquisitionValue
+	sell.AccumulatedFees = cost.AccumulatedFees
 
 	newSell, err := h.sr.Create(sell, userEmail)
+	if errors.Is(err, ErrNotEnoughUnits) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The units are required by later sells: "+err.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to create sell")
 		return
@@ -110,7 +166,7 @@ func (h *Handler) ListSellsHandler(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
 	user := claims.User
 
-	sells, err := h.sr.FindAll(user.Email)
+	sells, err := h.sr.FindAll(user.Email, utils.PortfolioQuery(r))
 	if err != nil {
 		h.l.Error("Failed to find sells", "error", err.Error())
 		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to find sells")
@@ -146,72 +202,65 @@ func (h *Handler) DeleteSellHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SendHTTPMessage(w, http.StatusOK, "Sell deleted successfully")
 }
 
-func computeFIFOSellRule(buys domain.Buys, sells domain.Sells, soldUnits float32) (sellFIFORuleOutput, error) {
-	boughtUnits := arrayutils.Reduce(buys, 0, func(agg float32, b domain.BuyWithId) float32 {
-		return agg + b.Buy.Units
-	})
+func (h *Handler) UpdateSellHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
 
-	alreadySoldUnits := arrayutils.Reduce(sells, 0, func(agg float32, s domain.SellWithId) float32 {
-		return agg + s.Sell.Units
-	})
+	vars := mux.Vars(r)
+	id, present := vars["id"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing id parameter")
+		return
+	}
 
-	remainingUnits := boughtUnits - alreadySoldUnits
-	if remainingUnits < soldUnits {
-		return sellFIFORuleOutput{}, fmt.Errorf("not enough units to sell")
+	var usr CreateSellRequest
+	if err := json.NewDecoder(r.Body).Decode(&usr); err != nil {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
 	}
+	defer r.Body.Close()
 
-	// Get all buy packets for example if in the past I did 4 purchases of 100 shares
-	// here I'll get [100, 100, 100, 100]
-	packetsRemaining := arrayutils.Map(buys, func(b domain.BuyWithId) float32 {
-		return b.Buy.Units
-	})
+	validate := validator.New()
+	if err := validate.Struct(usr); err != nil {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
 
-	// In this loop we clear the already sold packets. For example, if in the past I sold 150 units
-	// the state after this loop will be
-	// packetsRemaining := [0, 50, 100, 100]
-	i := 0
-	for alreadySoldUnits > 0 {
-		packet := packetsRemaining[i]
-		if packet <= alreadySoldUnits {
-			packetsRemaining[i] = 0
-			alreadySoldUnits -= packet
-			i++
-		} else {
-			packetsRemaining[i] -= alreadySoldUnits
-			alreadySoldUnits = 0
-			break
-		}
+	// Acquisition value and accumulated fees are recomputed by the repository
+	// following the cost basis method of the user
+	sell := domain.Sell{
+		Units:          usr.Units,
+		Ticker:         usr.Ticker,
+		Amount:         usr.Amount,
+		Currency:       usr.Currency,
+		Date:           usr.Date,
+		Fees:           usr.Fees,
+		UseCashAccount: usr.UseCashAccount,
+		PortfolioId:    usr.PortfolioId,
+		Lots:           usr.Lots,
 	}
 
-	// Here starting from the startingPackageIndex (i) we obtain the weighted cost of the purchases
-	weightedSum := 0.0
-	var accumulatedFees float32
-	j := i
-	soldUnitsIt := soldUnits
-	for soldUnitsIt > 0 {
-		if soldUnitsIt < 0.001 {
-			soldUnitsIt = 0
-			break
-		}
+	updatedSell, err := h.sr.Update(id, sell, user.Email)
+	if errors.Is(err, ErrNotEnoughUnits) || errors.Is(err, ErrInvalidLotSelection) || errors.Is(err, domain.ErrPortfolioNotFound) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to update sell", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to update sell")
+		return
+	}
 
-		currentBuy := buys[j]
-		currentBuyRemainingUnits := packetsRemaining[j]
-		packetUnitValue := float64(currentBuy.Buy.Amount) / float64(currentBuy.Buy.Units)
-		weightedSum += float64(currentBuyRemainingUnits) * packetUnitValue
-
-		if currentBuyRemainingUnits <= soldUnitsIt {
-			soldUnitsIt -= currentBuyRemainingUnits
-			accumulatedFees += currentBuy.Buy.Fee
-			j++
-		} else {
-			// Partially consume the packet and exit because there's no more sold units
-			soldUnitsIt = 0
-			break
-		}
+	if updatedSell == nil {
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Sell not found")
+		return
 	}
 
-	return sellFIFORuleOutput{
-		meanAcquisitionValue: float32(weightedSum) / float32(soldUnits),
-		accumulatedFees:      accumulatedFees,
-	}, nil
+	if err := updatedSell.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize sell", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to serialize sell")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 }
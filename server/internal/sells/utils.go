@@ -1,77 +1,115 @@
 package sells
 
 import (
+	"sort"
+	"time"
+
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
 	"github.com/judedaryl/go-arrayutils"
 )
 
-func ComputeFIFORuleAvgPurchasePrice(buys domain.Buys, sells domain.Sells, reinvestmentsAsFree bool) (float32, error) {
-	buys = arrayutils.Map(buys, func(b domain.BuyWithId) domain.BuyWithId {
-		if reinvestmentsAsFree && b.Buy.IsReinvestment {
-			return domain.BuyWithId{
-				Id: b.Id,
-				Buy: domain.Buy{
-					Units:          b.Buy.Units,
-					Amount:         0,
-					Fee:            b.Buy.Fee,
-					Taxes:          b.Buy.Taxes,
-					Ticker:         b.Buy.Ticker,
-					Currency:       b.Buy.Currency,
-					IsReinvestment: b.Buy.IsReinvestment,
-					Date:           b.Buy.Date,
-				},
-			}
-		}
-		return b
-	})
-	totalUnits := arrayutils.Reduce(buys, 0, func(agg float32, b domain.BuyWithId) float32 {
-		return agg + b.Buy.Units
-	})
-	soldUnits := arrayutils.Reduce(sells, 0, func(agg float32, s domain.SellWithId) float32 {
-		return agg + s.Sell.Units
-	})
-	buyValue := arrayutils.Reduce(buys, 0, func(agg float32, b domain.BuyWithId) float32 {
-		return agg + b.Buy.Amount + b.Buy.Fee + b.Buy.Taxes
+// ApplyCorporateActions expresses buys and sells as of asOf, replaying in date order every
+// corporate action effective on or before asOf over the lots dated before it. Amounts, fees
+// and taxes are totals, so a change in the units adjusts the per-unit cost by the inverse
+// of the ratio. Cash acquisitions are stored as sells, so they are not replayed here.
+//
+// Spin-offs copy the lots into the new ticker. The sells of the parent before the spin-off
+// are copied as well with no amount, so the new lots are consumed the same way.
+func ApplyCorporateActions(buys domain.Buys, sells domain.Sells, actions domain.CorporateActions, asOf time.Time) (domain.Buys, domain.Sells) {
+	adjustedBuys := append(domain.Buys{}, buys...)
+	adjustedSells := append(domain.Sells{}, sells...)
+
+	sortedActions := append(domain.CorporateActions{}, actions...)
+	sort.SliceStable(sortedActions, func(i, j int) bool {
+		return time.Time(sortedActions[i].Date).Before(time.Time(sortedActions[j].Date))
 	})
 
-	if soldUnits == 0 {
-		return buyValue / totalUnits, nil
-	}
+	for _, ca := range sortedActions {
+		date := time.Time(ca.Date)
+		if date.After(asOf) || ca.Type == domain.CashAcquisition {
+			continue
+		}
+		affected := func(ticker string, d domain.Date) bool {
+			return ticker == ca.Ticker && time.Time(d).Before(date)
+		}
 
-	// Get all buy packets for example if in the past I did 4 purchases of 100 shares
-	// here I'll get [100, 100, 100, 100]
-	packetsRemaining := arrayutils.Map(buys, func(b domain.BuyWithId) float32 {
-		return b.Buy.Units
-	})
+		spunOffBuys := domain.Buys{}
+		for i, b := range adjustedBuys {
+			if !affected(b.Buy.Ticker, b.Buy.Date) {
+				continue
+			}
+			switch ca.Type {
+			case domain.Split:
+				adjustedBuys[i].Buy.Units *= ca.Ratio
+			case domain.SymbolChange:
+				adjustedBuys[i].Buy.Ticker = ca.NewTicker
+			case domain.Merger:
+				adjustedBuys[i].Buy.Ticker = ca.NewTicker
+				adjustedBuys[i].Buy.Units *= ca.Ratio
+			case domain.SpinOff:
+				share := ca.CostBasisPercentage / 100
+				spunOff := b
+				spunOff.Buy.Ticker = ca.NewTicker
+				spunOff.Buy.Units *= ca.Ratio
+				spunOff.Buy.Amount *= share
+				spunOff.Buy.Fee *= share
+				spunOff.Buy.Taxes *= share
+				spunOffBuys = append(spunOffBuys, spunOff)
 
-	// In this loop we clear the already sold packets. For example, if in the past I sold 150 units
-	// the state after this loop will be
-	// packetsRemaining := [0, 50, 100, 100]
-	i := 0
-	for soldUnits > 0 {
-		packet := packetsRemaining[i]
-		if packet <= soldUnits {
-			packetsRemaining[i] = 0
-			soldUnits -= packet
-			i++
-		} else {
-			packetsRemaining[i] -= soldUnits
-			soldUnits = 0
-			break
+				adjustedBuys[i].Buy.Amount *= 1 - share
+				adjustedBuys[i].Buy.Fee *= 1 - share
+				adjustedBuys[i].Buy.Taxes *= 1 - share
+			}
+		}
+
+		spunOffSells := domain.Sells{}
+		for i, s := range adjustedSells {
+			if !affected(s.Sell.Ticker, s.Sell.Date) {
+				continue
+			}
+			switch ca.Type {
+			case domain.Split:
+				adjustedSells[i].Sell.Units *= ca.Ratio
+				adjustedSells[i].Sell.AcquisitionValue /= ca.Ratio
+			case domain.SymbolChange:
+				adjustedSells[i].Sell.Ticker = ca.NewTicker
+			case domain.Merger:
+				adjustedSells[i].Sell.Ticker = ca.NewTicker
+				adjustedSells[i].Sell.Units *= ca.Ratio
+				adjustedSells[i].Sell.AcquisitionValue /= ca.Ratio
+			case domain.SpinOff:
+				spunOff := s
+				spunOff.Sell.Ticker = ca.NewTicker
+				spunOff.Sell.Units *= ca.Ratio
+				spunOff.Sell.Amount = 0
+				spunOff.Sell.Fees = 0
+				spunOff.Sell.AccumulatedFees = 0
+				spunOff.Sell.AcquisitionValue = 0
+				spunOffSells = append(spunOffSells, spunOff)
+			}
 		}
-	}
-	totalRemainingUnits := arrayutils.Reduce(packetsRemaining[i:], 0, func(agg float32, p float32) float32 {
-		return agg + p
-	})
 
-	// Here starting from the startingPackageIndex (i) we obtain the weighted cost of the purchases
-	weightedSum := 0.0
-	for j := i; j < len(buys); j++ {
-		currentBuy := buys[j]
-		currentBuyRemainingUnits := packetsRemaining[j]
-		packetUnitValue := float64(currentBuy.Buy.Amount+currentBuy.Buy.Fee+currentBuy.Buy.Taxes) / float64(currentBuy.Buy.Units)
-		weightedSum += float64(currentBuyRemainingUnits) * packetUnitValue
+		adjustedBuys = append(adjustedBuys, spunOffBuys...)
+		adjustedSells = append(adjustedSells, spunOffSells...)
 	}
 
-	return float32(weightedSum) / float32(totalRemainingUnits), nil
+	// Keep the lots in date order, the cost basis methods rely on it
+	sort.SliceStable(adjustedBuys, func(i, j int) bool {
+		return time.Time(adjustedBuys[i].Buy.Date).Before(time.Time(adjustedBuys[j].Buy.Date))
+	})
+	sort.SliceStable(adjustedSells, func(i, j int) bool {
+		return time.Time(adjustedSells[i].Sell.Date).Before(time.Time(adjustedSells[j].Sell.Date))
+	})
+	return adjustedBuys, adjustedSells
+}
+
+// FilterByTicker keeps the buys and sells of the ticker
+func FilterByTicker(buys domain.Buys, sells domain.Sells, ticker string) (domain.Buys, domain.Sells) {
+	tickerBuys := arrayutils.Filter(buys, func(b domain.BuyWithId) bool {
+		return b.Buy.Ticker == ticker
+	})
+	tickerSells := arrayutils.Filter(sells, func(s domain.SellWithId) bool {
+		return s.Sell.Ticker == ticker
+	})
+	return tickerBuys, tickerSells
 }
@@ -4,11 +4,19 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Guillem96/portfolio-analyzer-server/internal/account"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/assets"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/buys"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/cash"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/corporateactions"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/dividends"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/export"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/imports"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/portfolios"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/reports"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/tickers"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 
 	"github.com/gorilla/handlers"
@@ -22,6 +30,14 @@ func SetupRouter(
 	dividendsHandler *dividends.Handler,
 	assetsHandler *assets.Handler,
 	sellsHandler *sells.Handler,
+	importsHandler *imports.Handler,
+	exportHandler *export.Handler,
+	accountHandler *account.Handler,
+	corporateActionsHandler *corporateactions.Handler,
+	cashHandler *cash.Handler,
+	portfoliosHandler *portfolios.Handler,
+	reportsHandler *reports.Handler,
+	tickersHandler *tickers.Handler,
 ) http.Handler {
 	router := mux.NewRouter()
 	router.StrictSlash(true)
@@ -37,20 +53,27 @@ func SetupRouter(
 	buysRouter.Use(auth.JwtMiddleware)
 	buysRouter.HandleFunc("/", buysHandler.ListBuysHandler).Methods("GET")
 	buysRouter.HandleFunc("/", buysHandler.CreateBuyHandler).Methods("POST")
+	buysRouter.HandleFunc("/{id}", buysHandler.UpdateBuyHandler).Methods("PUT")
 	buysRouter.HandleFunc("/{id}", buysHandler.DeleteBuyHandler).Methods("DELETE")
 
 	sellsRouter := router.PathPrefix("/sells").Subrouter()
 	sellsRouter.Use(auth.JwtMiddleware)
 	sellsRouter.HandleFunc("/", sellsHandler.ListSellsHandler).Methods("GET")
 	sellsRouter.HandleFunc("/", sellsHandler.CreateSellHandler).Methods("POST")
+	sellsRouter.HandleFunc("/{id}", sellsHandler.UpdateSellHandler).Methods("PUT")
 	sellsRouter.HandleFunc("/{id}", sellsHandler.DeleteSellHandler).Methods("DELETE")
 
 	dividendsRouter := router.PathPrefix("/dividends").Subrouter()
 	dividendsRouter.Use(auth.JwtMiddleware)
 	dividendsRouter.HandleFunc("/", dividendsHandler.ListDividendsHandler).Methods("GET")
 	dividendsRouter.HandleFunc("/preferred-currency", dividendsHandler.ListPreferredCurrencyDividendsHandler).Methods("GET")
+	dividendsRouter.HandleFunc("/forecast", dividendsHandler.ForecastDividendsHandler).Methods("GET")
+	dividendsRouter.HandleFunc("/growth", dividendsHandler.DividendGrowthHandler).Methods("GET")
+	dividendsRouter.HandleFunc("/reinvestments/orphans", dividendsHandler.ListOrphanedReinvestmentsHandler).Methods("GET")
 	dividendsRouter.HandleFunc("/", dividendsHandler.CreateDividendHandler).Methods("POST")
+	dividendsRouter.HandleFunc("/{id}", dividendsHandler.UpdateDividendHandler).Methods("PUT")
 	dividendsRouter.HandleFunc("/{id}", dividendsHandler.DeleteDividendHandler).Methods("DELETE")
+	dividendsRouter.HandleFunc("/{id}/confirm", dividendsHandler.ConfirmDividendHandler).Methods("POST")
 	dividendsRouter.HandleFunc("/", dividendsHandler.UpdateDividendsHandler).Methods("PATCH")
 
 	assetsRouter := router.PathPrefix("/assets").Subrouter()
@@ -58,6 +81,63 @@ func SetupRouter(
 	assetsRouter.HandleFunc("/", assetsHandler.ListAssetsHandler).Methods("GET")
 	assetsRouter.HandleFunc("/events", assetsHandler.ListEventsHandler).Methods("GET")
 	assetsRouter.HandleFunc("/historic", assetsHandler.RetrieveHistoricDataHandler).Methods("GET")
+	assetsRouter.HandleFunc("/performance", assetsHandler.PerformanceHandler).Methods("GET")
+	assetsRouter.HandleFunc("/risk", assetsHandler.RiskHandler).Methods("GET")
+	assetsRouter.HandleFunc("/allocation", assetsHandler.AllocationHandler).Methods("GET")
+	assetsRouter.HandleFunc("/allocation/targets", assetsHandler.ListAllocationTargetsHandler).Methods("GET")
+	assetsRouter.HandleFunc("/allocation/targets", assetsHandler.UpdateAllocationTargetsHandler).Methods("PUT")
+
+	importsRouter := router.PathPrefix("/imports").Subrouter()
+	importsRouter.Use(auth.JwtMiddleware)
+	importsRouter.HandleFunc("/", importsHandler.ImportHandler).Methods("POST")
+
+	exportRouter := router.PathPrefix("/export").Subrouter()
+	exportRouter.Use(auth.JwtMiddleware)
+	exportRouter.HandleFunc("/", exportHandler.ExportLedgerHandler).Methods("GET")
+
+	accountRouter := router.PathPrefix("/account").Subrouter()
+	accountRouter.Use(auth.JwtMiddleware)
+	accountRouter.HandleFunc("/backup", accountHandler.BackupHandler).Methods("GET")
+	accountRouter.HandleFunc("/restore", accountHandler.RestoreHandler).Methods("POST")
+
+	corporateActionsRouter := router.PathPrefix("/corporate-actions").Subrouter()
+	corporateActionsRouter.Use(auth.JwtMiddleware)
+	corporateActionsRouter.HandleFunc("/", corporateActionsHandler.ListCorporateActionsHandler).Methods("GET")
+	corporateActionsRouter.HandleFunc("/", corporateActionsHandler.CreateCorporateActionHandler).Methods("POST")
+	corporateActionsRouter.HandleFunc("/{id}", corporateActionsHandler.UpdateCorporateActionHandler).Methods("PUT")
+	corporateActionsRouter.HandleFunc("/{id}", corporateActionsHandler.DeleteCorporateActionHandler).Methods("DELETE")
+
+	cashRouter := router.PathPrefix("/cash").Subrouter()
+	cashRouter.Use(auth.JwtMiddleware)
+	cashRouter.HandleFunc("/", cashHandler.ListCashTransactionsHandler).Methods("GET")
+	cashRouter.HandleFunc("/balances", cashHandler.ListBalancesHandler).Methods("GET")
+	cashRouter.HandleFunc("/", cashHandler.CreateCashTransactionHandler).Methods("POST")
+	cashRouter.HandleFunc("/{id}", cashHandler.UpdateCashTransactionHandler).Methods("PUT")
+	cashRouter.HandleFunc("/{id}", cashHandler.DeleteCashTransactionHandler).Methods("DELETE")
+
+	portfoliosRouter := router.PathPrefix("/portfolios").Subrouter()
+	portfoliosRouter.Use(auth.JwtMiddleware)
+	portfoliosRouter.HandleFunc("/", portfoliosHandler.ListPortfoliosHandler).Methods("GET")
+	portfoliosRouter.HandleFunc("/", portfoliosHandler.CreatePortfolioHandler).Methods("POST")
+	portfoliosRouter.HandleFunc("/{id}", portfoliosHandler.UpdatePortfolioHandler).Methods("PUT")
+	portfoliosRouter.HandleFunc("/{id}", portfoliosHandler.DeletePortfolioHandler).Methods("DELETE")
+
+	reportsRouter := router.PathPrefix("/reports").Subrouter()
+	reportsRouter.Use(auth.JwtMiddleware)
+	reportsRouter.HandleFunc("/realized-gains", reportsHandler.RealizedGainsHandler).Methods("GET")
+	reportsRouter.HandleFunc("/dividend-taxes", reportsHandler.DividendTaxesHandler).Methods("GET")
+	reportsRouter.HandleFunc("/treaty-rates", reportsHandler.ListTreatyRatesHandler).Methods("GET")
+	reportsRouter.HandleFunc("/treaty-rates", reportsHandler.UpdateTreatyRatesHandler).Methods("PUT")
+
+	tickersRouter := router.PathPrefix("/tickers").Subrouter()
+	tickersRouter.Use(auth.JwtMiddleware)
+	tickersRouter.HandleFunc("/search", tickersHandler.SearchTickersHandler).Methods("GET")
+	tickersRouter.HandleFunc("/manual", tickersHandler.ListManualTickersHandler).Methods("GET")
+	tickersRouter.HandleFunc("/manual/{ticker}", tickersHandler.SaveManualTickerHandler).Methods("PUT")
+	tickersRouter.HandleFunc("/manual/{ticker}", tickersHandler.DeleteManualTickerHandler).Methods("DELETE")
+	tickersRouter.HandleFunc("/{ticker}", tickersHandler.RetrieveTickerHandler).Methods("GET")
+	tickersRouter.HandleFunc("/{ticker}/history", tickersHandler.PriceHistoryHandler).Methods("GET")
+	tickersRouter.HandleFunc("/{ticker}/refresh", tickersHandler.RefreshTickerHandler).Methods("POST")
 
 	// Serve static files
 	staticDir := "./static/dist"
@@ -69,7 +149,7 @@ func SetupRouter(
 		AllowedOrigins:   []string{"http://localhost:5173", "https://guillem96.github.io"},
 		AllowCredentials: true,
 		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowedMethods:   []string{"GET", "POST", "DELETE", "PATCH"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
 		Debug:            !utils.IsProdEnvironment(),
 	})
 	return c.Handler(handlers.LoggingHandler(os.Stdout, router))
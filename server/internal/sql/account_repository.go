@@ -0,0 +1,478 @@
+package sql
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/judedaryl/go-arrayutils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewAccountRepository(db *gorm.DB, logger *slog.Logger) *AccountRepository {
+	return &AccountRepository{db: db, l: logger}
+}
+
+const findLatestTickerSnapshotsQuery = `
+SELECT *
+FROM LATEST_TICKERS
+WHERE TICKER IN ?;
+`
+
+func (r *AccountRepository) Backup(userEmail string) (*domain.AccountBackup, error) {
+	dbUser := User{}
+	if err := r.db.Where("email = ?", userEmail).First(&dbUser).Error; err != nil {
+		return nil, err
+	}
+
+	dbBuys := []Buy{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbBuys).Error; err != nil {
+		return nil, err
+	}
+
+	dbSells := []Sell{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbSells).Error; err != nil {
+		return nil, err
+	}
+
+	dbDividends := []Dividend{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbDividends).Error; err != nil {
+		return nil, err
+	}
+
+	dbActions := []CorporateAction{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbActions).Error; err != nil {
+		return nil, err
+	}
+
+	dbCashTransactions := []CashTransaction{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbCashTransactions).Error; err != nil {
+		return nil, err
+	}
+
+	dbPortfolios := []Portfolio{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("created_at asc").Find(&dbPortfolios).Error; err != nil {
+		return nil, err
+	}
+
+	dbHistorics := []PortfolioHistoric{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("created_at asc").Find(&dbHistorics).Error; err != nil {
+		return nil, err
+	}
+
+	dbTreatyRates := []TreatyRate{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("country asc").Find(&dbTreatyRates).Error; err != nil {
+		return nil, err
+	}
+
+	dbTargets := []AllocationTarget{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("dimension asc, label asc").Find(&dbTargets).Error; err != nil {
+		return nil, err
+	}
+
+	backup := &domain.AccountBackup{
+		Version:   domain.AccountBackupVersion,
+		CreatedAt: time.Now(),
+		User: domain.UserWithId{
+			Id: dbUser.ID,
+			User: domain.User{
+				Email:             dbUser.Email,
+				Picture:           dbUser.Picture,
+				PreferredCurrency: &dbUser.PreferredCurrency,
+				CostBasisMethod:   dbUser.CostBasisMethod,
+				Benchmarks:        splitBenchmarks(dbUser.Benchmarks),
+			},
+		},
+		Buys:      make(domain.Buys, len(dbBuys)),
+		Sells:     make(domain.Sells, len(dbSells)),
+		Dividends: make(domain.Dividends, len(dbDividends)),
+		Historic:  make([]domain.BackupHistoricEntry, len(dbHistorics)),
+		Tickers:   []domain.BackupTicker{},
+
+		CorporateActions: dbCorporateActionsToDomain(dbActions),
+		CashTransactions: dbCashTransactionsToDomain(dbCashTransactions),
+		Portfolios:       make(domain.Portfolios, len(dbPortfolios)),
+
+		TreatyRates: arrayutils.Map(dbTreatyRates, func(t TreatyRate) domain.TreatyRate {
+			return domain.TreatyRate{Country: t.Country, Rate: t.Rate}
+		}),
+		AllocationTargets: arrayutils.Map(dbTargets, func(t AllocationTarget) domain.BackupAllocationTarget {
+			return domain.BackupAllocationTarget{
+				Dimension:        t.Dimension,
+				AllocationTarget: domain.AllocationTarget{Key: t.Label, Weight: t.Weight},
+			}
+		}),
+		ManualTickers: domain.ManualTickers{},
+	}
+
+	for i, dbPortfolio := range dbPortfolios {
+		backup.Portfolios[i] = dbPortfolioToDomain(dbPortfolio)
+	}
+
+	tickers := []string{}
+	for i, dbBuy := range dbBuys {
+		backup.Buys[i] = domain.BuyWithId{
+			Id: dbBuy.ID,
+			Buy: domain.Buy{
+				Units:          dbBuy.Units,
+				Ticker:         dbBuy.Ticker,
+				Taxes:          dbBuy.Taxes,
+				Fee:            dbBuy.Fee,
+				Amount:         dbBuy.Amount,
+				Currency:       dbBuy.Currency,
+				IsReinvestment: dbBuy.IsReinvestment,
+				UseCashAccount: dbBuy.UseCashAccount,
+				PortfolioId:    dbBuy.PortfolioID,
+				DividendId:     dbBuy.DividendID,
+				Date:           domain.Date(dbBuy.Date),
+			},
+		}
+		tickers = append(tickers, dbBuy.Ticker)
+	}
+
+	for i, dbSell := range dbSells {
+		backup.Sells[i] = domain.SellWithId{
+			Id: dbSell.ID,
+			Sell: domain.Sell{
+				Units:            dbSell.Units,
+				Ticker:           dbSell.Ticker,
+				AcquisitionValue: dbSell.AcquisitionValue,
+				Amount:           dbSell.Amount,
+				Fees:             dbSell.Fees,
+				AccumulatedFees:  dbSell.AccumulatedFees,
+				UseCashAccount:   dbSell.UseCashAccount,
+				Currency:         dbSell.Currency,
+				Date:             domain.Date(dbSell.Date),
+
+				CorporateActionId: dbSell.CorporateActionID,
+				PortfolioId:       dbSell.PortfolioID,
+				Lots:              dbSell.Lots,
+			},
+		}
+		tickers = append(tickers, dbSell.Ticker)
+	}
+
+	for i, dbDividend := range dbDividends {
+		backup.Dividends[i] = domain.DividendWithId{
+			Id: dbDividend.ID,
+			Dividend: domain.Dividend{
+				Company:                   dbDividend.Company,
+				Amount:                    dbDividend.Amount,
+				Country:                   dbDividend.Country,
+				Currency:                  dbDividend.Currency,
+				DoubleTaxationOrigin:      dbDividend.DoubleTaxationOrigin,
+				DoubleTaxationDestination: dbDividend.DoubleTaxationDestination,
+				IsReinvested:              dbDividend.IsReinvested,
+				UseCashAccount:            dbDividend.UseCashAccount,
+				PortfolioId:               dbDividend.PortfolioID,
+				Status:                    dbDividend.Status,
+				ExDividendDate:            optionalDate(dbDividend.ExDividendDate),
+				Date:                      domain.Date(dbDividend.Date),
+			},
+		}
+		tickers = append(tickers, dbDividend.Company)
+	}
+
+	for i, dbHistoric := range dbHistorics {
+		backup.Historic[i] = domain.BackupHistoricEntry{
+			Id:                   dbHistoric.ID,
+			Value:                dbHistoric.Value,
+			BuyValue:             dbHistoric.BuyValue,
+			ValueWithoutReinvest: dbHistoric.ValueWithoutReinvest,
+			Currency:             dbHistoric.Currency,
+			PortfolioId:          dbHistoric.PortfolioID,
+			Backfilled:           dbHistoric.Backfilled,
+			CreatedAt:            dbHistoric.CreatedAt,
+		}
+	}
+
+	tickers = utils.ArrayUnique(tickers)
+	if len(tickers) == 0 {
+		return backup, nil
+	}
+
+	// Manual tickers are shared by all the users, only the ones of the movements are kept
+	dbManualTickers := []ManualTicker{}
+	if err := r.db.Where("ticker IN ?", tickers).Order("ticker asc").Find(&dbManualTickers).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range dbManualTickers {
+		backup.ManualTickers = append(backup.ManualTickers, dbManualTickerToDomain(t))
+	}
+
+	dbTickers := []Ticker{}
+	if err := r.db.Raw(findLatestTickerSnapshotsQuery, tickers).Scan(&dbTickers).Error; err != nil {
+		return nil, err
+	}
+
+	for _, dbTicker := range dbTickers {
+		ticker, err := dbTickerToDomain(dbTicker)
+		if err != nil {
+			return nil, err
+		}
+		ticker.Currency = dbTicker.Currency
+
+		// The daily prices go with the snapshot so the restore stores them again
+		currency, history, err := findPriceHistory(r.db, ticker.Ticker)
+		if err != nil {
+			return nil, err
+		}
+		if len(history) > 0 && currency == ticker.Currency {
+			ticker.HistoricalData = history
+		}
+		backup.Tickers = append(backup.Tickers, domain.BackupTicker{
+			DateKey: dbTicker.DateKey,
+			Ticker:  ticker,
+		})
+	}
+
+	return backup, nil
+}
+
+// Restore upserts every row of the backup keeping their IDs, so restoring the same
+// archive twice leaves the database unchanged. Archives reusing the IDs of rows of another
+// user are rejected. The user row of this deployment is kept, only its preferences are
+// restored. Movements of archives without portfolios end up in the default portfolio.
+func (r *AccountRepository) Restore(backup domain.AccountBackup, userEmail string) error {
+	// The ownership is checked first, the condition only guards against a row of another
+	// user created meanwhile
+	upsert := clause.OnConflict{
+		UpdateAll: true,
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.Column{Name: "user_email"}, Value: userEmail}}},
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureOwnBackupRows(tx, backup, userEmail); err != nil {
+			return err
+		}
+
+		if backup.User.PreferredCurrency != nil {
+			err := tx.Model(&User{}).Where("email = ?", userEmail).Update("preferred_currency", *backup.User.PreferredCurrency).Error
+			if err != nil {
+				return err
+			}
+		}
+		if backup.User.CostBasisMethod != "" {
+			err := tx.Model(&User{}).Where("email = ?", userEmail).Update("cost_basis_method", backup.User.CostBasisMethod).Error
+			if err != nil {
+				return err
+			}
+		}
+		if backup.User.Benchmarks != nil {
+			err := tx.Model(&User{}).Where("email = ?", userEmail).Update("benchmarks", strings.Join(backup.User.Benchmarks, ",")).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var defaultPortfolio Portfolio
+		err := tx.Where("user_email = ? AND is_default = ?", userEmail, true).First(&defaultPortfolio).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		for _, p := range backup.Portfolios {
+			dbPortfolio := Portfolio{
+				ID:        p.Id,
+				UserEmail: userEmail,
+				Name:      p.Name,
+				Broker:    p.Broker,
+				Currency:  p.Currency,
+				// A user only has one default portfolio
+				IsDefault: p.IsDefault && (defaultPortfolio.ID == "" || defaultPortfolio.ID == p.Id),
+			}
+			if err := tx.Clauses(upsert).Create(&dbPortfolio).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, b := range backup.Buys {
+			dbBuy := Buy{
+				ID:             b.Id,
+				UserEmail:      userEmail,
+				Units:          b.Units,
+				Ticker:         b.Ticker,
+				Taxes:          b.Taxes,
+				Fee:            b.Fee,
+				Amount:         b.Amount,
+				Currency:       b.Currency,
+				IsReinvestment: b.IsReinvestment,
+				UseCashAccount: b.UseCashAccount,
+				PortfolioID:    b.PortfolioId,
+				DividendID:     b.DividendId,
+				Date:           time.Time(b.Date),
+			}
+			if err := tx.Clauses(upsert).Create(&dbBuy).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, s := range backup.Sells {
+			dbSell := Sell{
+				ID:               s.Id,
+				UserEmail:        userEmail,
+				Units:            s.Units,
+				Ticker:           s.Ticker,
+				Amount:           s.Amount,
+				Fees:             s.Fees,
+				AccumulatedFees:  s.AccumulatedFees,
+				UseCashAccount:   s.UseCashAccount,
+				AcquisitionValue: s.AcquisitionValue,
+				Currency:         s.Currency,
+				Date:             time.Time(s.Date),
+
+				CorporateActionID: s.CorporateActionId,
+				PortfolioID:       s.PortfolioId,
+				Lots:              s.Lots,
+			}
+			if err := tx.Clauses(upsert).Create(&dbSell).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, d := range backup.Dividends {
+			dbDividend := Dividend{
+				ID:                        d.Id,
+				UserEmail:                 userEmail,
+				Company:                   d.Company,
+				Country:                   d.Country,
+				Amount:                    d.Amount,
+				Currency:                  d.Currency,
+				DoubleTaxationOrigin:      d.DoubleTaxationOrigin,
+				DoubleTaxationDestination: d.DoubleTaxationDestination,
+				IsReinvested:              d.IsReinvested,
+				UseCashAccount:            d.UseCashAccount,
+				PortfolioID:               d.PortfolioId,
+				Status:                    d.Status,
+				ExDividendDate:            optionalTime(d.ExDividendDate),
+				Date:                      time.Time(d.Date),
+			}
+			if err := tx.Clauses(upsert).Create(&dbDividend).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, h := range backup.Historic {
+			dbHistoric := PortfolioHistoric{
+				ID:                   h.Id,
+				UserEmail:            userEmail,
+				Value:                h.Value,
+				BuyValue:             h.BuyValue,
+				ValueWithoutReinvest: h.ValueWithoutReinvest,
+				Currency:             h.Currency,
+				PortfolioID:          h.PortfolioId,
+				Backfilled:           h.Backfilled,
+				CreatedAt:            h.CreatedAt,
+			}
+			if err := tx.Clauses(upsert).Create(&dbHistoric).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, ca := range backup.CorporateActions {
+			dbAction := domainCorporateActionToDB(ca.CorporateAction, ca.Id, userEmail)
+			if err := tx.Clauses(upsert).Create(&dbAction).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, ct := range backup.CashTransactions {
+			dbTransaction := domainCashTransactionToDB(ct.CashTransaction, ct.Id, userEmail)
+			if err := tx.Clauses(upsert).Create(&dbTransaction).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := assignDefaultPortfolio(tx, userEmail); err != nil {
+			return err
+		}
+
+		for _, rate := range backup.TreatyRates {
+			dbRate := TreatyRate{UserEmail: userEmail, Country: rate.Country, Rate: rate.Rate}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&dbRate).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, target := range backup.AllocationTargets {
+			dbTarget := AllocationTarget{UserEmail: userEmail, Dimension: target.Dimension, Label: target.Key, Weight: target.Weight}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&dbTarget).Error; err != nil {
+				return err
+			}
+		}
+
+		// Manual tickers are shared by all the users, only the missing ones are restored
+		for _, t := range backup.ManualTickers {
+			dbTicker := domainManualTickerToDB(t)
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&dbTicker).Error; err != nil {
+				return err
+			}
+		}
+
+		// Ticker snapshots are shared by all the users, only the missing ones are restored
+		for _, t := range backup.Tickers {
+			dbTicker := domainTickerToDB(t.Ticker, t.DateKey)
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&dbTicker).Error; err != nil {
+				return err
+			}
+			if err := savePrices(tx, clause.OnConflict{DoNothing: true}, t.Ticker.Ticker, t.Ticker.Currency, t.Ticker.HistoricalData); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		r.l.Error("Failed to restore account", "error", err.Error())
+	}
+	return err
+}
+
+// ensureOwnBackupRows fails with domain.ErrBackupForeignRows when any row of the backup
+// has the ID of a row, even a deleted one, owned by another user
+func ensureOwnBackupRows(tx *gorm.DB, backup domain.AccountBackup, userEmail string) error {
+	ids := func(n int, id func(i int) string) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = id(i)
+		}
+		return result
+	}
+
+	tables := []struct {
+		model interface{}
+		ids   []string
+	}{
+		{&Portfolio{}, ids(len(backup.Portfolios), func(i int) string { return backup.Portfolios[i].Id })},
+		{&Buy{}, ids(len(backup.Buys), func(i int) string { return backup.Buys[i].Id })},
+		{&Sell{}, ids(len(backup.Sells), func(i int) string { return backup.Sells[i].Id })},
+		{&Dividend{}, ids(len(backup.Dividends), func(i int) string { return backup.Dividends[i].Id })},
+		{&PortfolioHistoric{}, ids(len(backup.Historic), func(i int) string { return backup.Historic[i].Id })},
+		{&CorporateAction{}, ids(len(backup.CorporateActions), func(i int) string { return backup.CorporateActions[i].Id })},
+		{&CashTransaction{}, ids(len(backup.CashTransactions), func(i int) string { return backup.CashTransactions[i].Id })},
+	}
+
+	for _, table := range tables {
+		for i := 0; i < len(table.ids); i += 500 {
+			chunk := table.ids[i:min(i+500, len(table.ids))]
+			var count int64
+			err := tx.Unscoped().Model(table.model).Where("id IN ? AND user_email <> ?", chunk, userEmail).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return domain.ErrBackupForeignRows
+			}
+		}
+	}
+	return nil
+}
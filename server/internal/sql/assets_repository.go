@@ -1,11 +1,16 @@
 package sql
 
 import (
+	"errors"
 	"log/slog"
+	"sort"
 	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/performance"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/google/uuid"
 	"github.com/judedaryl/go-arrayutils"
 	"gorm.io/gorm"
 )
@@ -20,15 +25,18 @@ type AssetsRepository struct {
 }
 
 type assetsIterimResult struct {
-	Ticker             string    `gorm:"column:TICKER"`
-	Currency           string    `gorm:"column:CURRENCY"`
-	BuyValue           float32   `gorm:"column:BUY_VALUE"`
-	ReinvestedBuyValue float32   `gorm:"column:REINVESTED_BUY_VALUE"`
-	Units              float32   `gorm:"column:UNITS"`
-	ReinvestUnits      float32   `gorm:"column:REINVEST_UNITS"`
-	BuyUnits           float32   `gorm:"column:BUY_UNITS"`
-	SoldUnits          float32   `gorm:"column:SOLD_UNITS"`
-	LastBuyDate        time.Time `gorm:"column:LAST_BUY_DATE"`
+	Ticker             string
+	Currency           string
+	BuyValue           float32
+	ReinvestedBuyValue float32
+	Units              float32
+	ReinvestUnits      float32
+	BuyUnits           float32
+	SoldUnits          float32
+	LastBuyDate        time.Time
+	// Lots of the ticker once the corporate actions are replayed
+	buys  domain.Buys
+	sells domain.Sells
 }
 
 type interimHistoricResult struct {
@@ -52,117 +60,41 @@ func NewAssetsRepository(db *gorm.DB, ur domain.UserRepository, tr domain.Ticker
 	}
 }
 
-func (r *AssetsRepository) FindAll(userEmail string) (domain.Assets, error) {
-	var results []assetsIterimResult
-
+// FindAll returns the assets of the user valued in its preferred currency or, when
+// filtering by portfolio, the assets of the portfolio valued in its base currency
+func (r *AssetsRepository) FindAll(userEmail string, portfolioId *string) (domain.Assets, error) {
 	user, err := r.ur.FindByEmail(userEmail)
 	if err != nil {
 		return nil, err
 	}
 
-	err = r.db.Raw(`
-	WITH _RATES AS (
-		SELECT
-			SOURCE_CURRENCY,
-			RATE
-		FROM EXCHANGE_RATES
-		WHERE TARGET_CURRENCY = ?
-	),
-
-	_SELLS_SINGLE_CURRENCY AS (
-		SELECT
-			SELLS.*,
-			USERS.PREFERRED_CURRENCY,
-			SELLS.AMOUNT * _RATES.RATE AS TOTAL_AMOUNT,
-			FEES * _RATES.RATE AS FEES,
-			SELLS.DATE
-		FROM SELLS
-		INNER JOIN USERS ON SELLS.USER_EMAIL = USERS.EMAIL
-		INNER JOIN _RATES ON _RATES.SOURCE_CURRENCY = SELLS.CURRENCY
-		WHERE USERS.EMAIL = ? AND SELLS.DELETED_AT IS NULL
-	),
-
-	_SELLS AS (
-		SELECT
-			USER_EMAIL,
-			TICKER,
-			SUM(UNITS) AS TOTAL_UNITS,
-			SUM(TOTAL_AMOUNT + FEES) AS TOTAL_AMOUNT
-		FROM _SELLS_SINGLE_CURRENCY
-		WHERE USER_EMAIL = ? AND DELETED_AT IS NULL
-		GROUP BY USER_EMAIL, TICKER
-	),
-	
-	_BUYS_SINGLE_CURRENCY AS (
-		SELECT
-			BUYS.*,
-			USERS.PREFERRED_CURRENCY,
-			BUYS.AMOUNT * _RATES.RATE AS TOTAL_AMOUNT,
-			FEE * _RATES.RATE AS FEE,
-			TAXES * _RATES.RATE AS TAXES,
-			BUYS.DATE
-		FROM BUYS
-		INNER JOIN USERS ON BUYS.USER_EMAIL = USERS.EMAIL
-		INNER JOIN _RATES ON _RATES.SOURCE_CURRENCY = BUYS.CURRENCY
-		WHERE USERS.EMAIL = ? AND BUYS.DELETED_AT IS NULL
-	),
-
-	_BUYS_DATE_W_RN AS (
-			SELECT
-					TICKER,
-					DATE,
-					ROW_NUMBER() OVER (PARTITION BY TICKER ORDER BY DATE DESC) AS RN
-			FROM _BUYS_SINGLE_CURRENCY
-	),
-
-	_BUYS_LAST_DATE AS (
-			SELECT TICKER, DATE
-			FROM _BUYS_DATE_W_RN
-			WHERE RN = 1
-	),
+	currency, err := r.valuationCurrency(user, portfolioId)
+	if err != nil {
+		return nil, err
+	}
 
-	_BUYS AS (
-		SELECT
-			USER_EMAIL,
-			TICKER,
-			PREFERRED_CURRENCY,
-			SUM(UNITS) AS TOTAL_UNITS,
-			SUM(TOTAL_AMOUNT + TAXES + FEE) AS TOTAL_AMOUNT
-		FROM _BUYS_SINGLE_CURRENCY
-		WHERE IS_REINVESTMENT = false
-		GROUP BY USER_EMAIL, TICKER, PREFERRED_CURRENCY
-	),
+	var userTickers []string
+	err = r.db.Model(&Buy{}).Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Distinct().Pluck("ticker", &userTickers).Error
+	if err != nil {
+		return nil, err
+	}
 
-	_REINVESTMENTS AS (
-		SELECT
-			USER_EMAIL,
-			TICKER,
-			PREFERRED_CURRENCY,
-			SUM(UNITS) AS TOTAL_UNITS,
-			SUM(TOTAL_AMOUNT + TAXES + FEE) AS TOTAL_AMOUNT
-		FROM _BUYS_SINGLE_CURRENCY
-		WHERE IS_REINVESTMENT = true
-		GROUP BY USER_EMAIL, TICKER, PREFERRED_CURRENCY
-	)
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return nil, err
+	}
 
-	SELECT
-		COALESCE(_BUYS.TICKER, _REINVESTMENTS.TICKER) AS TICKER,
-		COALESCE(_BUYS.PREFERRED_CURRENCY, _REINVESTMENTS.PREFERRED_CURRENCY) AS CURRENCY,
-		COALESCE(_BUYS.TOTAL_AMOUNT, 0) - COALESCE(_SELLS.TOTAL_AMOUNT, 0) AS BUY_VALUE,
-		COALESCE(_BUYS.TOTAL_UNITS, 0) AS BUY_UNITS,
-		COALESCE(_REINVESTMENTS.TOTAL_AMOUNT, 0) AS REINVESTED_BUY_VALUE,
-		COALESCE(_SELLS.TOTAL_UNITS, 0) AS SOLD_UNITS,
-		COALESCE(_REINVESTMENTS.TOTAL_UNITS, 0) AS REINVEST_UNITS,
-		COALESCE(_REINVESTMENTS.TOTAL_UNITS, 0) + COALESCE(_BUYS.TOTAL_UNITS, 0) AS UNITS,
-		_BUYS_LAST_DATE.DATE AS LAST_BUY_DATE
-	FROM _BUYS
-	FULL OUTER JOIN _REINVESTMENTS ON _BUYS.TICKER = _REINVESTMENTS.TICKER
-	LEFT JOIN _BUYS_LAST_DATE ON COALESCE(_BUYS.TICKER, _REINVESTMENTS.TICKER) = _BUYS_LAST_DATE.TICKER
-	LEFT JOIN _SELLS ON _BUYS.TICKER = _SELLS.TICKER
-	`, *user.PreferredCurrency, userEmail, userEmail, userEmail).Scan(&results).Error
+	// Lots are replayed in Go, the corporate actions can move them between tickers
+	lots, err := findRawLots(r.db, userEmail, userTickers, *currency, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+	lotBuys, lotSells := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, time.Now())
+	method, err := sells.NewCostBasisMethod(user.CostBasisMethod)
 	if err != nil {
 		return nil, err
 	}
+	results := aggregateLots(lotBuys, lotSells, *currency)
 
 	if len(results) == 0 {
 		return domain.Assets{}, nil
@@ -174,13 +106,13 @@ func (r *AssetsRepository) FindAll(userEmail string) (domain.Assets, error) {
 
 	var tickersInfo map[string]domain.Ticker
 	if len(allTickers) == 1 {
-		ticker, err := r.tr.FindByTicker(allTickers[0], user.PreferredCurrency)
+		ticker, err := r.tr.FindByTicker(allTickers[0], currency)
 		if err != nil {
 			return nil, err
 		}
 		tickersInfo = map[string]domain.Ticker{allTickers[0]: ticker}
 	} else {
-		tickersInfo, err = r.tr.FindMultipleTickers(allTickers, user.PreferredCurrency)
+		tickersInfo, err = r.tr.FindMultipleTickers(allTickers, currency)
 		if err != nil {
 			return nil, err
 		}
@@ -192,8 +124,8 @@ func (r *AssetsRepository) FindAll(userEmail string) (domain.Assets, error) {
 
 	assets := arrayutils.Map(airs, func(air assetsIterimResult) domain.Asset {
 		ownedUnits := air.Units - air.SoldUnits
-		averageStockPrice, _ := r.computeTickerAveragePurchasePrice(air, user, true)
-		averageStockPriceWithoutReinvest, _ := r.computeTickerAveragePurchasePrice(air, user, false)
+		averageStockPrice, _ := r.computeTickerAveragePurchasePrice(air, method, true)
+		averageStockPriceWithoutReinvest, _ := r.computeTickerAveragePurchasePrice(air, method, false)
 		buyValue := averageStockPriceWithoutReinvest * ownedUnits
 		buyValueWithoutReinvest := averageStockPrice * ownedUnits
 		buyReinvestedValue := buyValueWithoutReinvest - buyValue
@@ -228,7 +160,7 @@ func (r *AssetsRepository) FindAll(userEmail string) (domain.Assets, error) {
 			YieldWithRespectBuy:                yieldOnCost,
 			YieldWithRespectBuyWithoutReinvest: yieldOnCostWOR,
 			YieldWithRespectValue:              tickersInfo[air.Ticker].YearlyDividendValue / tickersInfo[air.Ticker].Price,
-			Currency:                           *user.PreferredCurrency,
+			Currency:                           *currency,
 			Country:                            tickersInfo[air.Ticker].Country,
 			Sector:                             tickersInfo[air.Ticker].Sector,
 		}
@@ -237,8 +169,25 @@ func (r *AssetsRepository) FindAll(userEmail string) (domain.Assets, error) {
 	return assets, nil
 }
 
-func (r *AssetsRepository) FindEvents(userEmail string) (domain.EventCalendar, error) {
-	assets, err := r.FindAll(userEmail)
+// valuationCurrency returns the preferred currency of the user or, when filtering by
+// portfolio, the base currency of the portfolio
+func (r *AssetsRepository) valuationCurrency(user *domain.UserWithId, portfolioId *string) (*string, error) {
+	if portfolioId == nil {
+		return user.PreferredCurrency, nil
+	}
+
+	var portfolio Portfolio
+	if err := r.db.Where("id = ? AND user_email = ?", *portfolioId, user.Email).First(&portfolio).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrPortfolioNotFound
+		}
+		return nil, err
+	}
+	return &portfolio.Currency, nil
+}
+
+func (r *AssetsRepository) FindEvents(userEmail string, portfolioId *string) (domain.EventCalendar, error) {
+	assets, err := r.FindAll(userEmail, portfolioId)
 	if err != nil {
 		return nil, err
 	}
@@ -286,9 +235,18 @@ func (r *AssetsRepository) FindEvents(userEmail string) (domain.EventCalendar, e
 	return events, nil
 }
 
-func (r *AssetsRepository) FindHistoric(userEmail string, startDate, endDate domain.Date) (domain.PortfolioHistoric, error) {
+// FindHistoric returns the daily snapshots of the whole account or, when filtering by
+// portfolio, the ones of the portfolio. Values are converted to the preferred currency.
+// Each snapshot also tells what the buys and sells up to it would be worth in the
+// benchmarks of the user.
+func (r *AssetsRepository) FindHistoric(userEmail string, startDate, endDate domain.Date, portfolioId *string) (domain.PortfolioHistoric, error) {
 	var results []interimHistoricResult
 
+	historicPortfolioId := ""
+	if portfolioId != nil {
+		historicPortfolioId = *portfolioId
+	}
+
 	if err := r.db.Raw(`
 	WITH _RATES AS (
 		SELECT
@@ -300,12 +258,15 @@ func (r *AssetsRepository) FindHistoric(userEmail string, startDate, endDate dom
 
 	_PORTFOLIO_HISTORICS_SINGLE_CURRENCY AS (
 		SELECT
-			PORTFOLIO_HISTORICS.*,
+			PORTFOLIO_HISTORICS.CREATED_AT,
+			PORTFOLIO_HISTORICS.VALUE * _RATES.RATE AS VALUE,
+			PORTFOLIO_HISTORICS.BUY_VALUE * _RATES.RATE AS BUY_VALUE,
+			PORTFOLIO_HISTORICS.VALUE_WITHOUT_REINVEST * _RATES.RATE AS VALUE_WITHOUT_REINVEST,
 			USERS.PREFERRED_CURRENCY AS CURRENCY
 		FROM PORTFOLIO_HISTORICS
 		INNER JOIN USERS ON PORTFOLIO_HISTORICS.USER_EMAIL = USERS.EMAIL
 		INNER JOIN _RATES ON _RATES.SOURCE_CURRENCY = PORTFOLIO_HISTORICS.CURRENCY
-		WHERE USERS.EMAIL = ?
+		WHERE USERS.EMAIL = ? AND COALESCE(PORTFOLIO_HISTORICS.PORTFOLIO_ID, '') = ?
 	),
 
 	_GROUPED_HISTORICS AS (
@@ -324,7 +285,7 @@ func (r *AssetsRepository) FindHistoric(userEmail string, startDate, endDate dom
 	SELECT *
 	FROM _GROUPED_HISTORICS
 	WHERE ROW_NUM = 1;
-	`, userEmail, userEmail, time.Time(startDate).Format("2006-01-01"), time.Time(endDate).Format("2006-01-01")).Scan(&results).Error; err != nil {
+	`, userEmail, userEmail, historicPortfolioId, time.Time(startDate).Format("2006-01-01"), time.Time(endDate).Format("2006-01-01")).Scan(&results).Error; err != nil {
 		return nil, err
 	}
 
@@ -340,10 +301,267 @@ func (r *AssetsRepository) FindHistoric(userEmail string, startDate, endDate dom
 			RateWithoutReinvest:  r.RateWithoutReinvest,
 		}
 	})
+
+	user, err := r.ur.FindByEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || len(user.Benchmarks) == 0 || len(historic) == 0 {
+		return historic, nil
+	}
+
+	if err := r.addBenchmarks(historic, user, portfolioId); err != nil {
+		return nil, err
+	}
 	return historic, nil
 }
 
-func (r *AssetsRepository) computeTickerAveragePurchasePrice(air assetsIterimResult, user *domain.UserWithId, reinvestmentsAsFree bool) (float32, error) {
+// addBenchmarks values at every date of the historic the buys and sells as if they had
+// gone into each benchmark of the user, at the current exchange rates. Reinvestments are
+// left out, the price of the benchmark already accounts for its own returns.
+func (r *AssetsRepository) addBenchmarks(historic domain.PortfolioHistoric, user *domain.UserWithId, portfolioId *string) error {
+	rates, err := findRatesTo(r.db, *user.PreferredCurrency)
+	if err != nil {
+		return err
+	}
+
+	dbBuys := []Buy{}
+	err = r.db.Scopes(withPortfolio(portfolioId)).
+		Where("user_email = ? AND is_reinvestment = ?", user.Email, false).
+		Find(&dbBuys).Error
+	if err != nil {
+		return err
+	}
+
+	dbSells := []Sell{}
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", user.Email).Find(&dbSells).Error; err != nil {
+		return err
+	}
+
+	flows := []performance.CashFlow{}
+	for _, b := range dbBuys {
+		flows = append(flows, performance.CashFlow{
+			Date:   b.Date,
+			Amount: -float64((b.Amount + b.Fee + b.Taxes) * rates[b.Currency]),
+		})
+	}
+	for _, s := range dbSells {
+		flows = append(flows, performance.CashFlow{
+			Date:   s.Date,
+			Amount: float64((s.Amount - s.Fees) * rates[s.Currency]),
+		})
+	}
+
+	dates := arrayutils.Map(historic, func(h domain.HistoricEntry) time.Time {
+		return time.Time(h.Date)
+	})
+	for _, benchmark := range user.Benchmarks {
+		currency, history, err := findPriceHistory(r.db, benchmark)
+		if err != nil {
+			return err
+		}
+		rate, present := rates[currency]
+		if !present {
+			r.l.Warn("Benchmark without prices", "benchmark", benchmark)
+			continue
+		}
+
+		prices := arrayutils.Map(history, func(e domain.HistoricalEntry) performance.Valuation {
+			return performance.Valuation{Date: time.Time(e.Date), Value: float64(e.Price * rate)}
+		})
+		for i, value := range performance.BenchmarkValues(flows, prices, dates) {
+			if historic[i].Benchmarks == nil {
+				historic[i].Benchmarks = map[string]float32{}
+			}
+			historic[i].Benchmarks[benchmark] = float32(value)
+		}
+	}
+	return nil
+}
+
+// FindPerformanceInputs returns the valuations and the cash flows of the whole account or,
+// when filtering by portfolio, of the portfolio. The holdings are valued with the daily
+// snapshots and the tickers with their price history, all at the current exchange rates.
+func (r *AssetsRepository) FindPerformanceInputs(userEmail string, portfolioId *string) (*domain.PerformanceInputs, error) {
+	user, err := r.ur.FindByEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := r.valuationCurrency(user, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := r.FindAll(userEmail, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := findRatesTo(r.db, *currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var userTickers []string
+	err = r.db.Model(&Buy{}).Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Distinct().Pluck("ticker", &userTickers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	lots, err := findRawLots(r.db, userEmail, userTickers, *currency, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	lotBuys, lotSells := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, now)
+
+	inputs := &domain.PerformanceInputs{
+		Currency:         *currency,
+		TickerValuations: map[string][]domain.PerformanceValuation{},
+		Flows:            []domain.PerformanceFlow{},
+	}
+	for _, b := range lotBuys {
+		inputs.Flows = append(inputs.Flows, domain.PerformanceFlow{
+			Ticker: b.Ticker,
+			Date:   b.Date,
+			Amount: -(b.Amount + b.Fee + b.Taxes),
+		})
+	}
+	for _, s := range lotSells {
+		inputs.Flows = append(inputs.Flows, domain.PerformanceFlow{
+			Ticker: s.Ticker,
+			Date:   s.Date,
+			Amount: (s.Amount - s.Fees) * rates[s.Currency],
+		})
+	}
+
+	dbDividends := []Dividend{}
+	err = r.db.Scopes(withPortfolio(portfolioId)).
+		Where("user_email = ?", userEmail).
+		Where("COALESCE(status, '') <> ?", domain.DividendPending).
+		Find(&dbDividends).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dbDividends {
+		net := d.Amount * (1 - d.DoubleTaxationOrigin/100) * (1 - d.DoubleTaxationDestination/100)
+		inputs.Flows = append(inputs.Flows, domain.PerformanceFlow{
+			Ticker: d.Company,
+			Date:   domain.Date(d.Date),
+			Amount: net * rates[d.Currency],
+		})
+	}
+
+	// One snapshot per day, today is valued with the current prices
+	historicPortfolioId := ""
+	if portfolioId != nil {
+		historicPortfolioId = *portfolioId
+	}
+	var dbHistorics []PortfolioHistoric
+	err = r.db.Where("user_email = ? AND COALESCE(portfolio_id, '') = ?", userEmail, historicPortfolioId).
+		Order("created_at asc").
+		Find(&dbHistorics).Error
+	if err != nil {
+		return nil, err
+	}
+
+	today := now.Truncate(24 * time.Hour)
+	seen := map[string]bool{}
+	for _, h := range dbHistorics {
+		day := h.CreatedAt.Format(time.DateOnly)
+		if seen[day] || !h.CreatedAt.Before(today) {
+			continue
+		}
+		seen[day] = true
+		inputs.Valuations = append(inputs.Valuations, domain.PerformanceValuation{
+			Date:  domain.Date(h.CreatedAt),
+			Value: h.Value * rates[h.Currency],
+		})
+	}
+
+	values := map[string]float32{}
+	var totalValue float32
+	for _, asset := range assets {
+		values[asset.Ticker.Ticker] = asset.Value
+		totalValue += asset.Value
+	}
+	inputs.Valuations = append(inputs.Valuations, domain.PerformanceValuation{Date: domain.Date(now), Value: totalValue})
+
+	tickers := utils.ArrayUnique(arrayutils.Map(inputs.Flows, func(f domain.PerformanceFlow) string {
+		return f.Ticker
+	}))
+	if len(tickers) == 0 {
+		return inputs, nil
+	}
+
+	tickersInfo, err := findLatestTickers(r.db, tickers)
+	if err != nil {
+		return nil, err
+	}
+	for _, ticker := range tickers {
+		inputs.TickerValuations[ticker] = tickerValuations(ticker, tickersInfo[ticker], lotBuys, lotSells, rates, today)
+		inputs.TickerValuations[ticker] = append(inputs.TickerValuations[ticker], domain.PerformanceValuation{
+			Date:  domain.Date(now),
+			Value: values[ticker],
+		})
+	}
+	return inputs, nil
+}
+
+// tickerValuations values the units held of the ticker at every price of its history
+// before today
+func tickerValuations(ticker string, info domain.Ticker, buys domain.Buys, sells domain.Sells, rates map[string]float32, today time.Time) []domain.PerformanceValuation {
+	rate, present := rates[info.Currency]
+	if !present {
+		return []domain.PerformanceValuation{}
+	}
+
+	tickerBuys := arrayutils.Filter(buys, func(b domain.BuyWithId) bool {
+		return b.Ticker == ticker
+	})
+	tickerSells := arrayutils.Filter(sells, func(s domain.SellWithId) bool {
+		return s.Ticker == ticker
+	})
+
+	history := append([]domain.HistoricalEntry{}, info.HistoricalData...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return time.Time(history[i].Date).Before(time.Time(history[j].Date))
+	})
+
+	valuations := []domain.PerformanceValuation{}
+	for _, entry := range history {
+		date := time.Time(entry.Date)
+		if !date.Before(today) {
+			continue
+		}
+
+		var units float32
+		for _, b := range tickerBuys {
+			if !time.Time(b.Date).After(date) {
+				units += b.Units
+			}
+		}
+		for _, s := range tickerSells {
+			if !time.Time(s.Date).After(date) {
+				units -= s.Units
+			}
+		}
+		valuations = append(valuations, domain.PerformanceValuation{
+			Date:  entry.Date,
+			Value: max(units, 0) * entry.Price * rate,
+		})
+	}
+	return valuations
+}
+
+func (r *AssetsRepository) computeTickerAveragePurchasePrice(air assetsIterimResult, method sells.CostBasisMethod, reinvestmentsAsFree bool) (float32, error) {
 	ownedUnits := air.Units - air.SoldUnits
 	buyValue := air.BuyValue
 	if !reinvestmentsAsFree {
@@ -352,19 +570,494 @@ func (r *AssetsRepository) computeTickerAveragePurchasePrice(air assetsIterimRes
 	if ownedUnits > 1e-4 && air.SoldUnits == 0 {
 		return buyValue / float32(ownedUnits), nil
 	} else if ownedUnits > 1e-4 && air.SoldUnits > 0 {
-		tbs, err := r.br.FindByTickerAndCurrency(air.Ticker, *user.PreferredCurrency, user.Email)
+		averageStockPrice, err := sells.ComputeAvgPurchasePrice(method, air.buys, air.sells, reinvestmentsAsFree)
 		if err != nil {
 			return 0, err
 		}
-		tss, err := r.sr.FindByTicker(air.Ticker, user.Email)
+		return averageStockPrice, nil
+	}
+	return 0, nil
+}
+
+// aggregateLots groups by ticker the buys, already converted to the currency, and the sells.
+// Tickers that only have sells are left out. Sells only account for the sold units, the
+// cost of what is still owned is then computed with the cost basis method of the user.
+func aggregateLots(buys domain.Buys, sells domain.Sells, currency string) []assetsIterimResult {
+	byTicker := map[string]*assetsIterimResult{}
+	tickers := []string{}
+	for _, b := range buys {
+		air, present := byTicker[b.Buy.Ticker]
+		if !present {
+			air = &assetsIterimResult{Ticker: b.Buy.Ticker, Currency: currency}
+			byTicker[b.Buy.Ticker] = air
+			tickers = append(tickers, b.Buy.Ticker)
+		}
+
+		total := b.Buy.Amount + b.Buy.Taxes + b.Buy.Fee
+		if b.Buy.IsReinvestment {
+			air.ReinvestedBuyValue += total
+			air.ReinvestUnits += b.Buy.Units
+		} else {
+			air.BuyValue += total
+			air.BuyUnits += b.Buy.Units
+		}
+		air.Units += b.Buy.Units
+		if date := time.Time(b.Buy.Date); date.After(air.LastBuyDate) {
+			air.LastBuyDate = date
+		}
+		air.buys = append(air.buys, b)
+	}
+
+	for _, s := range sells {
+		air, present := byTicker[s.Sell.Ticker]
+		if !present {
+			continue
+		}
+		air.SoldUnits += s.Sell.Units
+		air.sells = append(air.sells, s)
+	}
+
+	return arrayutils.Map(tickers, func(t string) assetsIterimResult {
+		return *byTicker[t]
+	})
+}
+
+// FindTradedTickers returns every ticker the user has bought, as named today once the
+// corporate actions are replayed, together with the date of its first buy
+func (r *AssetsRepository) FindTradedTickers(userEmail string) (map[string]domain.Date, error) {
+	user, err := r.ur.FindByEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return map[string]domain.Date{}, nil
+	}
+
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	lots, err := r.rawLots(userEmail, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshots value the lots as of each day, so both the tickers before and after
+	// the corporate actions are traded
+	buys, _ := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, time.Now())
+	tickers := map[string]domain.Date{}
+	for _, b := range append(lots.buys, buys...) {
+		if first, present := tickers[b.Ticker]; !present || time.Time(b.Date).Before(time.Time(first)) {
+			tickers[b.Ticker] = b.Date
+		}
+	}
+	return tickers, nil
+}
+
+// Backfill rebuilds one snapshot per day of the whole account and of each portfolio, from
+// the first buy until the day before until, replaying the lots against the daily prices of
+// each ticker and the exchange rates of each day. Days with a snapshot of the daily task
+// are kept and the snapshots of previous backfills are replaced. Lots are expressed as of
+// each day, with the corporate actions effective by then, and valued at the price quoted
+// that day. Both their value and cost are converted at the rates of the day. Days before
+// the price history of a holding starts are skipped. Returns the number of snapshots
+// written.
+func (r *AssetsRepository) Backfill(userEmail string, prices map[string]domain.Ticker, until domain.Date) (int, error) {
+	user, err := r.ur.FindByEmail(userEmail)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, nil
+	}
+
+	method, err := sells.NewCostBasisMethod(user.CostBasisMethod)
+	if err != nil {
+		return 0, err
+	}
+
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	var portfolios []Portfolio
+	if err := r.db.Where("user_email = ?", userEmail).Find(&portfolios).Error; err != nil {
+		return 0, err
+	}
+
+	snapshots, err := r.backfillSnapshots(user.Email, nil, *user.PreferredCurrency, method, actions, prices, time.Time(until))
+	if err != nil {
+		return 0, err
+	}
+	for _, portfolio := range portfolios {
+		portfolioSnapshots, err := r.backfillSnapshots(user.Email, &portfolio.ID, portfolio.Currency, method, actions, prices, time.Time(until))
 		if err != nil {
 			return 0, err
 		}
-		averageStockPrice, err := sells.ComputeFIFORuleAvgPurchasePrice(tbs, tss, reinvestmentsAsFree)
+		snapshots = append(snapshots, portfolioSnapshots...)
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_email = ? AND backfilled = ?", userEmail, true).Delete(&PortfolioHistoric{}).Error; err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(snapshots, 500).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(snapshots), nil
+}
+
+// backfillSnapshots values the lots of the user, or of the portfolio, at the end of every
+// day without a snapshot of the daily task. Tickers without prices are left out.
+func (r *AssetsRepository) backfillSnapshots(userEmail string, portfolioId *string, currency string, method sells.CostBasisMethod, actions domain.CorporateActions, prices map[string]domain.Ticker, until time.Time) ([]PortfolioHistoric, error) {
+	lots, err := r.rawLots(userEmail, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+	if len(lots.buys) == 0 {
+		return []PortfolioHistoric{}, nil
+	}
+
+	rates, err := findRateHistoryTo(r.db, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	historicPortfolioId := ""
+	if portfolioId != nil {
+		historicPortfolioId = *portfolioId
+	}
+	var taken []time.Time
+	err = r.db.Model(&PortfolioHistoric{}).
+		Where("user_email = ? AND COALESCE(portfolio_id, '') = ? AND backfilled = ?", userEmail, historicPortfolioId, false).
+		Pluck("created_at", &taken).Error
+	if err != nil {
+		return nil, err
+	}
+	takenDays := map[string]bool{}
+	for _, t := range taken {
+		takenDays[t.Format(time.DateOnly)] = true
+	}
+
+	// Replaying the corporate actions is only needed when a new one becomes effective
+	sortedActions := append(domain.CorporateActions{}, actions...)
+	sort.SliceStable(sortedActions, func(i, j int) bool {
+		return time.Time(sortedActions[i].Date).Before(time.Time(sortedActions[j].Date))
+	})
+	effective := -1
+	var buys domain.Buys
+	var lotSells domain.Sells
+
+	snapshots := []PortfolioHistoric{}
+	end := until.Truncate(24 * time.Hour)
+	for day := time.Time(lots.buys[0].Date).Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+		if takenDays[day.Format(time.DateOnly)] {
+			continue
+		}
+
+		dayEffective := sort.Search(len(sortedActions), func(i int) bool {
+			return time.Time(sortedActions[i].Date).After(day)
+		})
+		if dayEffective != effective {
+			effective = dayEffective
+			buys, lotSells = sells.ApplyCorporateActions(lots.buys, lots.sells, sortedActions, day)
+		}
+
+		// The cost of the buys is converted at the rates of the day, same as their value
+		dayBuys := domain.Buys{}
+		for _, b := range buys {
+			if time.Time(b.Date).After(day) {
+				continue
+			}
+			rate, found := rates.at(b.Currency, day)
+			if !found {
+				continue
+			}
+			b.Amount *= rate
+			b.Fee *= rate
+			b.Taxes *= rate
+			dayBuys = append(dayBuys, b)
+		}
+		daySells := arrayutils.Filter(lotSells, func(s domain.SellWithId) bool {
+			return !time.Time(s.Date).After(day)
+		})
+
+		snapshot := PortfolioHistoric{
+			ID:          uuid.New().String(),
+			UserEmail:   userEmail,
+			Currency:    currency,
+			PortfolioID: historicPortfolioId,
+			Backfilled:  true,
+			CreatedAt:   day,
+		}
+		quoted := true
+		for _, air := range aggregateLots(dayBuys, daySells, currency) {
+			ownedUnits := air.Units - air.SoldUnits
+			if ownedUnits <= 1e-4 {
+				continue
+			}
+
+			info, present := prices[air.Ticker]
+			if !present {
+				// Renamed tickers are usually quoted with the whole history under the new symbol
+				info, present = prices[renamedTicker(air.Ticker, sortedActions, day)]
+			}
+			if !present {
+				continue
+			}
+			price, found := priceOn(info.HistoricalData, day)
+			if !found {
+				quoted = false
+				break
+			}
+			price *= laterSplitsRatio(air.Ticker, sortedActions, day)
+			rate, found := rates.at(info.Currency, day)
+			if !found {
+				continue
+			}
+
+			averageStockPriceWithoutReinvest, _ := r.computeTickerAveragePurchasePrice(air, method, false)
+			snapshot.Value += ownedUnits * price * rate
+			snapshot.ValueWithoutReinvest += (ownedUnits - air.ReinvestUnits) * price * rate
+			snapshot.BuyValue += averageStockPriceWithoutReinvest * ownedUnits
+		}
+
+		// A holding with no price yet would show as a drop of the value, so the day is skipped
+		if quoted {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// rawLots returns the lots of the user, or of the portfolio, in the currency of each
+// movement and with no corporate action replayed
+func (r *AssetsRepository) rawLots(userEmail string, portfolioId *string) (rawLots, error) {
+	dbBuys := []Buy{}
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Order("date asc").Find(&dbBuys).Error; err != nil {
+		return rawLots{}, err
+	}
+
+	dbSells := []Sell{}
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Order("date asc, created_at asc").Find(&dbSells).Error; err != nil {
+		return rawLots{}, err
+	}
+	return rawLots{buys: arrayutils.Map(dbBuys, dbBuyToDomain), sells: arrayutils.Map(dbSells, dbSellToDomain)}, nil
+}
+
+// laterSplitsRatio returns the ratio of the splits of the ticker effective after the day.
+// Price histories are adjusted by the providers for every split, so multiplying by it gives
+// the price quoted that day, which matches the units owned that day. Actions are expected
+// in date order.
+func laterSplitsRatio(ticker string, actions domain.CorporateActions, day time.Time) float32 {
+	ratio := float32(1)
+	for _, ca := range actions {
+		if !time.Time(ca.Date).After(day) {
+			continue
+		}
+		switch ca.Type {
+		case domain.Split:
+			if ca.Ticker == ticker && ca.Ratio > 0 {
+				ratio *= ca.Ratio
+			}
+		case domain.SymbolChange:
+			if ca.Ticker == ticker {
+				ticker = ca.NewTicker
+			}
+		}
+	}
+	return ratio
+}
+
+// renamedTicker follows the symbol changes of the ticker effective after the day. Actions
+// are expected in date order.
+func renamedTicker(ticker string, actions domain.CorporateActions, day time.Time) string {
+	for _, ca := range actions {
+		if time.Time(ca.Date).After(day) && ca.Type == domain.SymbolChange && ca.Ticker == ticker {
+			ticker = ca.NewTicker
+		}
+	}
+	return ticker
+}
+
+// priceOn returns the last price on or before the day. Days before the history starts have
+// no price. History is expected in date order.
+func priceOn(history []domain.HistoricalEntry, day time.Time) (float32, bool) {
+	i := sort.Search(len(history), func(i int) bool {
+		return time.Time(history[i].Date).After(day)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return history[i-1].Price, true
+}
+
+// FindBenchmarkPrices returns the cached price history of the benchmark, or of the first
+// benchmark of the user when empty, converted to the currency at the current exchange
+// rate. Returns nil when there is no benchmark and no prices when it is not cached.
+func (r *AssetsRepository) FindBenchmarkPrices(userEmail string, benchmark string, currency string) (*domain.BenchmarkPrices, error) {
+	if benchmark == "" {
+		user, err := r.ur.FindByEmail(userEmail)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		return averageStockPrice, nil
+		if user == nil || len(user.Benchmarks) == 0 {
+			return nil, nil
+		}
+		benchmark = user.Benchmarks[0]
 	}
-	return 0, nil
+
+	tickerCurrency, history, err := findPriceHistory(r.db, benchmark)
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := findRatesTo(r.db, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := &domain.BenchmarkPrices{Ticker: benchmark, Prices: []domain.PerformanceValuation{}}
+	if rate, present := rates[tickerCurrency]; present {
+		prices.Prices = arrayutils.Map(history, func(e domain.HistoricalEntry) domain.PerformanceValuation {
+			return domain.PerformanceValuation{Date: e.Date, Value: e.Price * rate}
+		})
+	}
+	return prices, nil
+}
+
+// FindAllocation groups the value of the assets of the user, or of the portfolio, by the
+// dimension and compares the weight of each group with the target of the user. Groups
+// with a target but without assets are reported with no value.
+func (r *AssetsRepository) FindAllocation(userEmail string, by string, portfolioId *string) (*domain.Allocation, error) {
+	user, err := r.ur.FindByEmail(userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := r.valuationCurrency(user, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := r.FindAll(userEmail, portfolioId)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := r.FindAllocationTargets(userEmail, by)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tickers of the assets are already converted to the valuation currency, the one
+	// they trade in is kept in the cache
+	tickerCurrencies := map[string]string{}
+	if by == domain.AllocationByCurrency && len(assets) > 0 {
+		tickers, err := findLatestTickers(r.db, arrayutils.Map(assets, func(a domain.Asset) string {
+			return a.Ticker.Ticker
+		}))
+		if err != nil {
+			return nil, err
+		}
+		for ticker, info := range tickers {
+			tickerCurrencies[ticker] = info.Currency
+		}
+	}
+
+	allocation := &domain.Allocation{By: by, Currency: *currency, Entries: []domain.AllocationEntry{}}
+	values := map[string]float32{}
+	for _, asset := range assets {
+		var key string
+		switch by {
+		case domain.AllocationBySector:
+			key = asset.Sector
+		case domain.AllocationByCountry:
+			key = asset.Country
+		case domain.AllocationByIndustry:
+			key = asset.Ticker.Industry
+		case domain.AllocationByCurrency:
+			key = tickerCurrencies[asset.Ticker.Ticker]
+		case domain.AllocationByType:
+			key = domain.StockAsset
+			if asset.Ticker.IsEtf {
+				key = domain.EtfAsset
+			}
+		}
+		if key == "" {
+			key = domain.UnknownAllocationKey
+		}
+		values[key] += asset.Value
+		allocation.Total += asset.Value
+	}
+	for _, target := range targets {
+		if _, present := values[target.Key]; !present {
+			values[target.Key] = 0
+		}
+	}
+
+	targetWeights := map[string]float32{}
+	for _, target := range targets {
+		targetWeights[target.Key] = target.Weight
+	}
+	for key, value := range values {
+		entry := domain.AllocationEntry{Key: key, Value: value}
+		if allocation.Total > 0 {
+			entry.Weight = value / allocation.Total * 100
+		}
+		if target, present := targetWeights[key]; present {
+			drift := entry.Weight - target
+			entry.TargetWeight, entry.Drift = &target, &drift
+		}
+		allocation.Entries = append(allocation.Entries, entry)
+	}
+	sort.Slice(allocation.Entries, func(i, j int) bool {
+		if allocation.Entries[i].Value != allocation.Entries[j].Value {
+			return allocation.Entries[i].Value > allocation.Entries[j].Value
+		}
+		return allocation.Entries[i].Key < allocation.Entries[j].Key
+	})
+	return allocation, nil
+}
+
+// FindAllocationTargets returns the targets of the user for the dimension
+func (r *AssetsRepository) FindAllocationTargets(userEmail string, by string) (domain.AllocationTargets, error) {
+	dbTargets := []AllocationTarget{}
+	if err := r.db.Where("user_email = ? AND dimension = ?", userEmail, by).Order("label asc").Find(&dbTargets).Error; err != nil {
+		return nil, err
+	}
+
+	return arrayutils.Map(dbTargets, func(t AllocationTarget) domain.AllocationTarget {
+		return domain.AllocationTarget{Key: t.Label, Weight: t.Weight}
+	}), nil
+}
+
+// UpdateAllocationTargets replaces the targets of the user for the dimension
+func (r *AssetsRepository) UpdateAllocationTargets(targets domain.AllocationTargets, by string, userEmail string) (domain.AllocationTargets, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_email = ? AND dimension = ?", userEmail, by).Delete(&AllocationTarget{}).Error; err != nil {
+			return err
+		}
+
+		for _, target := range targets {
+			dbTarget := AllocationTarget{UserEmail: userEmail, Dimension: by, Label: target.Key, Weight: target.Weight}
+			if err := tx.Save(&dbTarget).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.l.Error("Failed to update allocation targets", "error", err.Error())
+		return nil, err
+	}
+
+	return r.FindAllocationTargets(userEmail, by)
 }
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"errors"
 	"log/slog"
 	"time"
 
@@ -26,6 +27,8 @@ type interimBuyResult struct {
 	Amount         float32   `gorm:"column:TOTAL_AMOUNT"`
 	Currency       string    `gorm:"column:CURRENCY"`
 	IsReinvestment bool      `gorm:"column:IS_REINVESTMENT"`
+	UseCashAccount bool      `gorm:"column:USE_CASH_ACCOUNT"`
+	PortfolioID    string    `gorm:"column:PORTFOLIO_ID"`
 	Date           time.Time `gorm:"column:DATE"`
 }
 
@@ -34,6 +37,12 @@ func NewBuysRepository(db *gorm.DB, tr domain.TickersRepository, logger *slog.Lo
 }
 
 func (r *BuysRepository) Create(buy domain.Buy, userEmail string) (*domain.BuyWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, buy.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	buy.PortfolioId = portfolioId
+
 	id := uuid.New().String()
 	dbBuy := Buy{
 		ID:             id,
@@ -45,9 +54,18 @@ func (r *BuysRepository) Create(buy domain.Buy, userEmail string) (*domain.BuyWi
 		Amount:         buy.Amount,
 		Currency:       buy.Currency,
 		IsReinvestment: buy.IsReinvestment,
+		UseCashAccount: buy.UseCashAccount,
+		PortfolioID:    buy.PortfolioId,
 		Date:           time.Time(buy.Date),
 	}
-	if err := r.db.Create(&dbBuy).Error; err != nil {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dbBuy).Error; err != nil {
+			return err
+		}
+		// Sells around the buy may match it depending on the cost basis method
+		return recomputeSellsCostBasis(tx, userEmail, buy.Ticker, dbBuy.Date)
+	})
+	if err != nil {
 		r.l.Error("Failed to create buy", "error", err.Error())
 		return nil, err
 	}
@@ -68,9 +86,9 @@ func (r *BuysRepository) Create(buy domain.Buy, userEmail string) (*domain.BuyWi
 	}, nil
 }
 
-func (r *BuysRepository) FindAll(userEmail string) (domain.Buys, error) {
+func (r *BuysRepository) FindAll(userEmail string, portfolioId *string) (domain.Buys, error) {
 	dbBuys := []Buy{}
-	if err := r.db.Where("user_email = ?", userEmail).Find(&dbBuys).Error; err != nil {
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Find(&dbBuys).Error; err != nil {
 		return nil, err
 	}
 
@@ -100,6 +118,9 @@ func (r *BuysRepository) FindAll(userEmail string) (domain.Buys, error) {
 				Amount:         dbBuy.Amount,
 				Currency:       dbBuy.Currency,
 				IsReinvestment: dbBuy.IsReinvestment,
+				UseCashAccount: dbBuy.UseCashAccount,
+				PortfolioId:    dbBuy.PortfolioID,
+				DividendId:     dbBuy.DividendID,
 				Date:           domain.Date(dbBuy.Date),
 			},
 		}
@@ -108,9 +129,9 @@ func (r *BuysRepository) FindAll(userEmail string) (domain.Buys, error) {
 	return buys, nil
 }
 
-func (r *BuysRepository) FindByTicker(ticker string, userEmail string) (domain.Buys, error) {
+func (r *BuysRepository) FindByTicker(ticker string, userEmail string, portfolioId *string) (domain.Buys, error) {
 	dbBuys := []Buy{}
-	if err := r.db.Where("user_email = ? AND ticker = ?", userEmail, ticker).Find(&dbBuys).Order("date asc").Error; err != nil {
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ? AND ticker = ?", userEmail, ticker).Find(&dbBuys).Order("date asc").Error; err != nil {
 		return nil, err
 	}
 
@@ -126,6 +147,9 @@ func (r *BuysRepository) FindByTicker(ticker string, userEmail string) (domain.B
 				Amount:         dbBuy.Amount,
 				Currency:       dbBuy.Currency,
 				IsReinvestment: dbBuy.IsReinvestment,
+				UseCashAccount: dbBuy.UseCashAccount,
+				PortfolioId:    dbBuy.PortfolioID,
+				DividendId:     dbBuy.DividendID,
 				Date:           domain.Date(dbBuy.Date),
 			},
 		}
@@ -134,9 +158,164 @@ func (r *BuysRepository) FindByTicker(ticker string, userEmail string) (domain.B
 	return buys, nil
 }
 
-func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail string) (domain.Buys, error) {
-	dbBuys := []interimBuyResult{}
+func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail string, portfolioId *string) (domain.Buys, error) {
+	return findBuysByTickerAndCurrency(r.db, ticker, currency, userEmail, portfolioId)
+}
+
+// FindAllTickers returns the tickers still traded by any user, following the symbol changes,
+// mergers and spin-offs of each user, and leaving out the ones the user saw acquired for
+// cash. Corporate actions are recorded per user, so a ticker is only retired for the users
+// who recorded the action.
+func (r *BuysRepository) FindAllTickers() ([]string, error) {
+	var tickers []string
 	err := r.db.Raw(`
+	WITH _HELD AS (
+		SELECT USER_EMAIL, TICKER FROM BUYS
+		WHERE TICKER <> ? AND DELETED_AT IS NULL
+		UNION
+		SELECT USER_EMAIL, NEW_TICKER AS TICKER FROM CORPORATE_ACTIONS
+		WHERE TYPE IN ? AND DELETED_AT IS NULL
+	)
+	SELECT DISTINCT TICKER FROM _HELD
+	WHERE NOT EXISTS (
+		SELECT 1 FROM CORPORATE_ACTIONS
+		WHERE CORPORATE_ACTIONS.USER_EMAIL = _HELD.USER_EMAIL
+			AND CORPORATE_ACTIONS.TICKER = _HELD.TICKER
+			AND CORPORATE_ACTIONS.TYPE IN ?
+			AND CORPORATE_ACTIONS.DELETED_AT IS NULL
+	)
+	`, "GCO.MC",
+		[]string{domain.SymbolChange, domain.Merger, domain.SpinOff},
+		[]string{domain.SymbolChange, domain.Merger, domain.CashAcquisition},
+	).Scan(&tickers).Error
+	return tickers, err
+}
+
+// FindFirstBuyDates returns the date of the first buy of every ticker, across all users
+func (r *BuysRepository) FindFirstBuyDates() (map[string]domain.Date, error) {
+	var buys []Buy
+	if err := r.db.Select("ticker", "date").Find(&buys).Error; err != nil {
+		return nil, err
+	}
+
+	firstDates := map[string]domain.Date{}
+	for _, b := range buys {
+		if first, present := firstDates[b.Ticker]; !present || b.Date.Before(time.Time(first)) {
+			firstDates[b.Ticker] = domain.Date(b.Date)
+		}
+	}
+	return firstDates, nil
+}
+
+func (r *BuysRepository) Update(id string, buy domain.Buy, userEmail string) (*domain.BuyWithId, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous Buy
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		portfolioId, err := resolvePortfolioID(tx, buy.PortfolioId, userEmail)
+		if err != nil {
+			return err
+		}
+		buy.PortfolioId = portfolioId
+
+		err = tx.Model(&Buy{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"units":            buy.Units,
+			"ticker":           buy.Ticker,
+			"taxes":            buy.Taxes,
+			"fee":              buy.Fee,
+			"amount":           buy.Amount,
+			"currency":         buy.Currency,
+			"is_reinvestment":  buy.IsReinvestment,
+			"use_cash_account": buy.UseCashAccount,
+			"portfolio_id":     buy.PortfolioId,
+			"date":             time.Time(buy.Date),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// Sells after the edited buy froze an acquisition value computed with the old packet
+		from := time.Time(buy.Date)
+		if previous.Ticker != buy.Ticker {
+			if err := recomputeSellsCostBasis(tx, userEmail, previous.Ticker, previous.Date); err != nil {
+				return err
+			}
+		} else if previous.Date.Before(from) {
+			from = previous.Date
+		}
+		return recomputeSellsCostBasis(tx, userEmail, buy.Ticker, from)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		r.l.Error("Failed to update buy", "error", err.Error())
+		return nil, err
+	}
+
+	nt, err := r.tr.FindByTicker(buy.Ticker, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.BuyWithId{
+		Id: id,
+		TickerData: &domain.SimplifiedTicker{
+			Name:    nt.Name,
+			Ticker:  nt.Ticker,
+			Website: nt.Website,
+		},
+		Buy: buy,
+	}, nil
+}
+
+func (r *BuysRepository) Delete(id string, userEmail string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous Buy
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&previous).Error; err != nil {
+			return err
+		}
+
+		// Sells after the deleted buy may have consumed its packet
+		return recomputeSellsCostBasis(tx, userEmail, previous.Ticker, previous.Date)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		r.l.Error("Failed to delete buy", "error", err.Error())
+	}
+	return err
+}
+
+func dbBuyToDomain(dbBuy Buy) domain.BuyWithId {
+	return domain.BuyWithId{
+		Id: dbBuy.ID,
+		Buy: domain.Buy{
+			Units:          dbBuy.Units,
+			Ticker:         dbBuy.Ticker,
+			Taxes:          dbBuy.Taxes,
+			Fee:            dbBuy.Fee,
+			Amount:         dbBuy.Amount,
+			Currency:       dbBuy.Currency,
+			IsReinvestment: dbBuy.IsReinvestment,
+			UseCashAccount: dbBuy.UseCashAccount,
+			PortfolioId:    dbBuy.PortfolioID,
+			DividendId:     dbBuy.DividendID,
+			Date:           domain.Date(dbBuy.Date),
+		},
+	}
+}
+
+func findBuysByTickerAndCurrency(db *gorm.DB, ticker, currency, userEmail string, portfolioId *string) (domain.Buys, error) {
+	dbBuys := []interimBuyResult{}
+	err := db.Raw(`
 	WITH _RATES AS (
 		SELECT
 			SOURCE_CURRENCY,
@@ -149,6 +328,8 @@ func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail str
 		BUYS.UNITS AS UNITS,
 		BUYS.TICKER AS TICKER,
 		BUYS.IS_REINVESTMENT AS IS_REINVESTMENT,
+		BUYS.USE_CASH_ACCOUNT AS USE_CASH_ACCOUNT,
+		BUYS.PORTFOLIO_ID AS PORTFOLIO_ID,
 		BUYS.AMOUNT * _RATES.RATE AS TOTAL_AMOUNT,
 		BUYS.FEE * _RATES.RATE AS TOTAL_FEES,
 		BUYS.TAXES * _RATES.RATE AS TOTAL_TAXES,
@@ -157,8 +338,9 @@ func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail str
 	FROM BUYS
 	INNER JOIN _RATES ON _RATES.SOURCE_CURRENCY = BUYS.CURRENCY
 	WHERE USER_EMAIL = ? AND BUYS.DELETED_AT IS NULL AND TICKER = ?
+		AND (? IS NULL OR BUYS.PORTFOLIO_ID = ?)
 	ORDER BY BUYS.DATE ASC
-	`, currency, currency, userEmail, ticker).Scan(&dbBuys).Error
+	`, currency, currency, userEmail, ticker, portfolioId, portfolioId).Scan(&dbBuys).Error
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +357,8 @@ func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail str
 				Amount:         dbBuy.Amount,
 				Currency:       dbBuy.Currency,
 				IsReinvestment: dbBuy.IsReinvestment,
+				UseCashAccount: dbBuy.UseCashAccount,
+				PortfolioId:    dbBuy.PortfolioID,
 				Date:           domain.Date(dbBuy.Date),
 			},
 		}
@@ -182,13 +366,3 @@ func (r *BuysRepository) FindByTickerAndCurrency(ticker, currency, userEmail str
 
 	return buys, nil
 }
-
-func (r *BuysRepository) FindAllTickers() ([]string, error) {
-	var tickers []string
-	err := r.db.Model(&Buy{}).Select("ticker").Where("ticker <> ?", "GCO.MC").Distinct().Pluck("ticker", &tickers).Error
-	return tickers, err
-}
-
-func (r *BuysRepository) Delete(id string, userEmail string) error {
-	return r.db.Where("id = ? AND user_email = ?", id, userEmail).Delete(&Buy{}).Error
-}
@@ -0,0 +1,197 @@
+package sql
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type cashBalanceResult struct {
+	Currency      string  `gorm:"column:CURRENCY"`
+	Balance       float32 `gorm:"column:BALANCE"`
+	Contributions float32 `gorm:"column:CONTRIBUTIONS"`
+}
+
+type CashRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewCashRepository(db *gorm.DB, logger *slog.Logger) *CashRepository {
+	return &CashRepository{db: db, l: logger}
+}
+
+func (r *CashRepository) Create(transaction domain.CashTransaction, userEmail string) (*domain.CashTransactionWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, transaction.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	transaction.PortfolioId = portfolioId
+
+	id := uuid.New().String()
+	dbTransaction := domainCashTransactionToDB(transaction, id, userEmail)
+	if err := r.db.Create(&dbTransaction).Error; err != nil {
+		r.l.Error("Failed to create cash transaction", "error", err.Error())
+		return nil, err
+	}
+
+	return &domain.CashTransactionWithId{Id: id, CashTransaction: transaction}, nil
+}
+
+func (r *CashRepository) FindAll(userEmail string, portfolioId *string) (domain.CashTransactions, error) {
+	dbTransactions := []CashTransaction{}
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Order("date asc").Find(&dbTransactions).Error; err != nil {
+		return nil, err
+	}
+	return dbCashTransactionsToDomain(dbTransactions), nil
+}
+
+// FindBalances returns the balance of every currency. Besides the cash transactions,
+// buys debit and sells credit their net amount when they use the cash account, and so
+// do dividends with the amount left after taxes. Contributions are the deposits minus
+// the withdrawals. A portfolio only counts its own movements.
+func (r *CashRepository) FindBalances(userEmail string, portfolioId *string) (domain.CashBalances, error) {
+	results := []cashBalanceResult{}
+	err := r.db.Raw(`
+	WITH _MOVEMENTS AS (
+		SELECT
+			CURRENCY,
+			CASE WHEN TYPE IN ? THEN AMOUNT ELSE -AMOUNT END AS AMOUNT,
+			CASE TYPE WHEN ? THEN AMOUNT WHEN ? THEN -AMOUNT ELSE 0 END AS CONTRIBUTION
+		FROM CASH_TRANSACTIONS
+		WHERE USER_EMAIL = ? AND DELETED_AT IS NULL AND (? IS NULL OR PORTFOLIO_ID = ?)
+
+		UNION ALL
+
+		SELECT
+			TARGET_CURRENCY AS CURRENCY,
+			TARGET_AMOUNT AS AMOUNT,
+			0 AS CONTRIBUTION
+		FROM CASH_TRANSACTIONS
+		WHERE USER_EMAIL = ? AND TYPE = ? AND DELETED_AT IS NULL AND (? IS NULL OR PORTFOLIO_ID = ?)
+
+		UNION ALL
+
+		SELECT
+			CURRENCY,
+			-(AMOUNT + FEE + TAXES) AS AMOUNT,
+			0 AS CONTRIBUTION
+		FROM BUYS
+		WHERE USER_EMAIL = ? AND USE_CASH_ACCOUNT = true AND DELETED_AT IS NULL AND (? IS NULL OR PORTFOLIO_ID = ?)
+
+		UNION ALL
+
+		SELECT
+			CURRENCY,
+			AMOUNT - FEES AS AMOUNT,
+			0 AS CONTRIBUTION
+		FROM SELLS
+		WHERE USER_EMAIL = ? AND USE_CASH_ACCOUNT = true AND DELETED_AT IS NULL AND (? IS NULL OR PORTFOLIO_ID = ?)
+
+		UNION ALL
+
+		SELECT
+			CURRENCY,
+			AMOUNT * (1 - DOUBLE_TAXATION_ORIGIN / 100) * (1 - DOUBLE_TAXATION_DESTINATION / 100) AS AMOUNT,
+			0 AS CONTRIBUTION
+		FROM DIVIDENDS
+		WHERE USER_EMAIL = ? AND USE_CASH_ACCOUNT = true AND DELETED_AT IS NULL AND COALESCE(STATUS, '') <> ? AND (? IS NULL OR PORTFOLIO_ID = ?)
+	)
+	SELECT
+		CURRENCY,
+		SUM(AMOUNT) AS BALANCE,
+		SUM(CONTRIBUTION) AS CONTRIBUTIONS
+	FROM _MOVEMENTS
+	GROUP BY CURRENCY
+	ORDER BY CURRENCY
+	`, []string{domain.Deposit, domain.Interest}, domain.Deposit, domain.Withdrawal,
+		userEmail, portfolioId, portfolioId,
+		userEmail, domain.FXConversion, portfolioId, portfolioId,
+		userEmail, portfolioId, portfolioId,
+		userEmail, portfolioId, portfolioId,
+		userEmail, domain.DividendPending, portfolioId, portfolioId,
+	).Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(domain.CashBalances, len(results))
+	for i, result := range results {
+		balances[i] = domain.CashBalance{
+			Currency:      result.Currency,
+			Balance:       result.Balance,
+			Contributions: result.Contributions,
+		}
+	}
+	return balances, nil
+}
+
+func (r *CashRepository) Update(id string, transaction domain.CashTransaction, userEmail string) (*domain.CashTransactionWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, transaction.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	transaction.PortfolioId = portfolioId
+
+	result := r.db.Model(&CashTransaction{}).Where("id = ? AND user_email = ?", id, userEmail).Updates(map[string]interface{}{
+		"type":            transaction.Type,
+		"amount":          transaction.Amount,
+		"currency":        transaction.Currency,
+		"target_amount":   transaction.TargetAmount,
+		"target_currency": transaction.TargetCurrency,
+		"description":     transaction.Description,
+		"portfolio_id":    transaction.PortfolioId,
+		"date":            time.Time(transaction.Date),
+	})
+	if result.Error != nil {
+		r.l.Error("Failed to update cash transaction", "error", result.Error.Error())
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &domain.CashTransactionWithId{Id: id, CashTransaction: transaction}, nil
+}
+
+func (r *CashRepository) Delete(id string, userEmail string) error {
+	return r.db.Where("id = ? AND user_email = ?", id, userEmail).Delete(&CashTransaction{}).Error
+}
+
+func domainCashTransactionToDB(transaction domain.CashTransaction, id, userEmail string) CashTransaction {
+	return CashTransaction{
+		ID:             id,
+		UserEmail:      userEmail,
+		Type:           transaction.Type,
+		Amount:         transaction.Amount,
+		Currency:       transaction.Currency,
+		TargetAmount:   transaction.TargetAmount,
+		TargetCurrency: transaction.TargetCurrency,
+		Description:    transaction.Description,
+		PortfolioID:    transaction.PortfolioId,
+		Date:           time.Time(transaction.Date),
+	}
+}
+
+func dbCashTransactionsToDomain(dbTransactions []CashTransaction) domain.CashTransactions {
+	transactions := make(domain.CashTransactions, len(dbTransactions))
+	for i, dbTransaction := range dbTransactions {
+		transactions[i] = domain.CashTransactionWithId{
+			Id: dbTransaction.ID,
+			CashTransaction: domain.CashTransaction{
+				Type:           dbTransaction.Type,
+				Amount:         dbTransaction.Amount,
+				Currency:       dbTransaction.Currency,
+				TargetAmount:   dbTransaction.TargetAmount,
+				TargetCurrency: dbTransaction.TargetCurrency,
+				Description:    dbTransaction.Description,
+				Date:           domain.Date(dbTransaction.Date),
+				PortfolioId:    dbTransaction.PortfolioID,
+			},
+		}
+	}
+	return transactions
+}
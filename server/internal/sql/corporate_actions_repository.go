@@ -0,0 +1,320 @@
+package sql
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"github.com/google/uuid"
+	"github.com/judedaryl/go-arrayutils"
+	"gorm.io/gorm"
+)
+
+type CorporateActionsRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewCorporateActionsRepository(db *gorm.DB, logger *slog.Logger) *CorporateActionsRepository {
+	return &CorporateActionsRepository{db: db, l: logger}
+}
+
+// Create stores the corporate action and recomputes the sells after it, since their
+// units are no longer comparable with the ones of the previous buys
+func (r *CorporateActionsRepository) Create(action domain.CorporateAction, userEmail string) (*domain.CorporateActionWithId, error) {
+	id := uuid.New().String()
+	dbAction := domainCorporateActionToDB(action, id, userEmail)
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dbAction).Error; err != nil {
+			return err
+		}
+		if err := closeAcquiredPosition(tx, dbAction); err != nil {
+			return err
+		}
+		return recomputeSellsCostBasis(tx, userEmail, action.Ticker, time.Time(action.Date))
+	})
+	if err != nil {
+		r.l.Error("Failed to create corporate action", "error", err.Error())
+		return nil, err
+	}
+
+	return &domain.CorporateActionWithId{Id: id, CorporateAction: action}, nil
+}
+
+func (r *CorporateActionsRepository) FindAll(userEmail string) (domain.CorporateActions, error) {
+	return findCorporateActions(r.db, userEmail)
+}
+
+func (r *CorporateActionsRepository) FindByTicker(ticker string, userEmail string) (domain.CorporateActions, error) {
+	dbActions := []CorporateAction{}
+	if err := r.db.Where("user_email = ? AND ticker = ?", userEmail, ticker).Order("date asc").Find(&dbActions).Error; err != nil {
+		return nil, err
+	}
+	return dbCorporateActionsToDomain(dbActions), nil
+}
+
+func (r *CorporateActionsRepository) Update(id string, action domain.CorporateAction, userEmail string) (*domain.CorporateActionWithId, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous CorporateAction
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(&CorporateAction{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"type":                  action.Type,
+			"ticker":                action.Ticker,
+			"new_ticker":            action.NewTicker,
+			"ratio":                 action.Ratio,
+			"cost_basis_percentage": action.CostBasisPercentage,
+			"price":                 action.Price,
+			"currency":              action.Currency,
+			"date":                  time.Time(action.Date),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// The sell closing the position is created again with the new price and date
+		if err := removeAcquisitionSells(tx, id); err != nil {
+			return err
+		}
+		if err := closeAcquiredPosition(tx, domainCorporateActionToDB(action, id, userEmail)); err != nil {
+			return err
+		}
+
+		from := time.Time(action.Date)
+		if previous.Date.Before(from) {
+			from = previous.Date
+		}
+		return recomputeActionSellsCostBasis(tx, userEmail, from, previous.Ticker, previous.NewTicker, action.Ticker, action.NewTicker)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		r.l.Error("Failed to update corporate action", "error", err.Error())
+		return nil, err
+	}
+
+	return &domain.CorporateActionWithId{Id: id, CorporateAction: action}, nil
+}
+
+func (r *CorporateActionsRepository) Delete(id string, userEmail string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous CorporateAction
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		if err := removeAcquisitionSells(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Delete(&previous).Error; err != nil {
+			return err
+		}
+		return recomputeActionSellsCostBasis(tx, userEmail, previous.Date, previous.Ticker, previous.NewTicker)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		r.l.Error("Failed to delete corporate action", "error", err.Error())
+	}
+	return err
+}
+
+// recomputeActionSellsCostBasis recomputes the sells of every ticker a corporate action
+// touched. Once the action changes or is gone, the new ticker is no longer derived from
+// the old one, so both have to be recomputed on their own.
+func recomputeActionSellsCostBasis(tx *gorm.DB, userEmail string, from time.Time, tickers ...string) error {
+	recomputed := map[string]bool{"": true}
+	for _, ticker := range tickers {
+		if recomputed[ticker] {
+			continue
+		}
+		recomputed[ticker] = true
+		if err := recomputeSellsCostBasis(tx, userEmail, ticker, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAcquisitionSells permanently deletes the sells closeAcquiredPosition stored for the
+// corporate action, they are not movements of the user
+func removeAcquisitionSells(tx *gorm.DB, corporateActionId string) error {
+	return tx.Unscoped().Where("corporate_action_id = ?", corporateActionId).Delete(&Sell{}).Error
+}
+
+// closeAcquiredPosition stores, for every portfolio, the sell of all the units held when
+// a position is acquired for cash. The acquisition value is left to the cost basis recomputation.
+func closeAcquiredPosition(tx *gorm.DB, action CorporateAction) error {
+	if action.Type != domain.CashAcquisition {
+		return nil
+	}
+
+	actions, err := findCorporateActions(tx, action.UserEmail)
+	if err != nil {
+		return err
+	}
+
+	buys, previousSells, err := findLots(tx, action.UserEmail, action.Ticker, action.Currency, actions, action.Date)
+	if err != nil {
+		return err
+	}
+
+	held := map[string]float32{}
+	portfolios := []string{}
+	for _, b := range buys {
+		if time.Time(b.Buy.Date).After(action.Date) {
+			continue
+		}
+		if _, present := held[b.Buy.PortfolioId]; !present {
+			portfolios = append(portfolios, b.Buy.PortfolioId)
+		}
+		held[b.Buy.PortfolioId] += b.Buy.Units
+	}
+	for _, s := range previousSells {
+		if time.Time(s.Sell.Date).After(action.Date) {
+			continue
+		}
+		held[s.Sell.PortfolioId] -= s.Sell.Units
+	}
+
+	for _, portfolioId := range portfolios {
+		units := held[portfolioId]
+		if units < 1e-4 {
+			continue
+		}
+
+		err := tx.Create(&Sell{
+			ID:                uuid.New().String(),
+			UserEmail:         action.UserEmail,
+			Units:             units,
+			Ticker:            action.Ticker,
+			Amount:            units * action.Price,
+			Currency:          action.Currency,
+			CorporateActionID: action.ID,
+			PortfolioID:       portfolioId,
+			Date:              action.Date,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawLots are the buys and sells of a set of tickers before replaying the corporate actions
+type rawLots struct {
+	buys  domain.Buys
+	sells domain.Sells
+}
+
+// findRawLots returns the buys of the tickers converted to the currency and their sells,
+// optionally only the ones of a portfolio
+func findRawLots(db *gorm.DB, userEmail string, tickers []string, currency string, portfolioId *string) (rawLots, error) {
+	lots := rawLots{buys: domain.Buys{}}
+	for _, ticker := range tickers {
+		tickerBuys, err := findBuysByTickerAndCurrency(db, ticker, currency, userEmail, portfolioId)
+		if err != nil {
+			return rawLots{}, err
+		}
+		lots.buys = append(lots.buys, tickerBuys...)
+	}
+
+	dbSells := []Sell{}
+	if err := db.Scopes(withPortfolio(portfolioId)).Where("user_email = ? AND ticker IN ?", userEmail, tickers).Order("date asc, created_at asc").Find(&dbSells).Error; err != nil {
+		return rawLots{}, err
+	}
+	lots.sells = arrayutils.Map(dbSells, dbSellToDomain)
+	return lots, nil
+}
+
+// findRawLotsAtBuyDate returns the buys of the tickers converted to the currency of the
+// rates at the rate of the day of each buy, and their sells. The ids of the buys converted
+// at the current rate, because the rate history begins later, are set in approximate.
+func findRawLotsAtBuyDate(db *gorm.DB, userEmail string, tickers []string, rates rateHistory, approximate map[string]bool) (rawLots, error) {
+	dbBuys := []Buy{}
+	if err := db.Where("user_email = ? AND ticker IN ?", userEmail, tickers).Order("date asc").Find(&dbBuys).Error; err != nil {
+		return rawLots{}, err
+	}
+
+	lots := rawLots{buys: make(domain.Buys, len(dbBuys))}
+	for i, dbBuy := range dbBuys {
+		rate, _ := rates.at(dbBuy.Currency, dbBuy.Date)
+		approximate[dbBuy.ID] = rates.approximate(dbBuy.Currency, dbBuy.Date)
+
+		b := dbBuyToDomain(dbBuy)
+		b.Buy.Amount *= rate
+		b.Buy.Fee *= rate
+		b.Buy.Taxes *= rate
+		lots.buys[i] = b
+	}
+
+	dbSells := []Sell{}
+	if err := db.Where("user_email = ? AND ticker IN ?", userEmail, tickers).Order("date asc, created_at asc").Find(&dbSells).Error; err != nil {
+		return rawLots{}, err
+	}
+	lots.sells = arrayutils.Map(dbSells, dbSellToDomain)
+	return lots, nil
+}
+
+// findLots returns the buys converted to the currency and the sells that end up in the
+// ticker as of asOf, once the corporate actions are replayed
+func findLots(db *gorm.DB, userEmail, ticker, currency string, actions domain.CorporateActions, asOf time.Time) (domain.Buys, domain.Sells, error) {
+	lots, err := findRawLots(db, userEmail, actions.SourceTickers(ticker), currency, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buys, previousSells := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, asOf)
+	buys, previousSells = sells.FilterByTicker(buys, previousSells, ticker)
+	return buys, previousSells, nil
+}
+
+func findCorporateActions(db *gorm.DB, userEmail string) (domain.CorporateActions, error) {
+	dbActions := []CorporateAction{}
+	if err := db.Where("user_email = ?", userEmail).Order("date asc").Find(&dbActions).Error; err != nil {
+		return nil, err
+	}
+	return dbCorporateActionsToDomain(dbActions), nil
+}
+
+func domainCorporateActionToDB(action domain.CorporateAction, id, userEmail string) CorporateAction {
+	return CorporateAction{
+		ID:                  id,
+		UserEmail:           userEmail,
+		Type:                action.Type,
+		Ticker:              action.Ticker,
+		NewTicker:           action.NewTicker,
+		Ratio:               action.Ratio,
+		CostBasisPercentage: action.CostBasisPercentage,
+		Price:               action.Price,
+		Currency:            action.Currency,
+		Date:                time.Time(action.Date),
+	}
+}
+
+func dbCorporateActionsToDomain(dbActions []CorporateAction) domain.CorporateActions {
+	actions := make(domain.CorporateActions, len(dbActions))
+	for i, dbAction := range dbActions {
+		actions[i] = domain.CorporateActionWithId{
+			Id: dbAction.ID,
+			CorporateAction: domain.CorporateAction{
+				Type:                dbAction.Type,
+				Ticker:              dbAction.Ticker,
+				NewTicker:           dbAction.NewTicker,
+				Ratio:               dbAction.Ratio,
+				CostBasisPercentage: dbAction.CostBasisPercentage,
+				Price:               dbAction.Price,
+				Currency:            dbAction.Currency,
+				Date:                domain.Date(dbAction.Date),
+			},
+		}
+	}
+	return actions
+}
@@ -19,9 +19,27 @@ func InitDB() {
 	db.AutoMigrate(&Dividend{})
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&ExchangeRate{})
+	db.AutoMigrate(&ExchangeRateHistoric{})
 	db.AutoMigrate(&PortfolioHistoric{})
 	db.AutoMigrate(&Sell{})
 	db.AutoMigrate(&Ticker{})
+	db.AutoMigrate(&CorporateAction{})
+	db.AutoMigrate(&CashTransaction{})
+	db.AutoMigrate(&Portfolio{})
+	db.AutoMigrate(&TreatyRate{})
+	db.AutoMigrate(&AllocationTarget{})
+	db.AutoMigrate(&ManualTicker{})
+	db.AutoMigrate(&TickerPrice{})
+
+	if err := db.Exec(createLatestTickersViewQuery).Error; err != nil {
+		log.Fatalf("Failed to create the latest tickers view: %v", err)
+	}
+
+	// Databases with several default portfolios per user are fixed by cmd/migrate_portfolios,
+	// which creates the index once done
+	if err := createDefaultPortfolioIndex(db); err != nil {
+		log.Printf("Failed to create the default portfolios index, run cmd/migrate_portfolios: %v", err)
+	}
 }
 
 func GetDB() *gorm.DB {
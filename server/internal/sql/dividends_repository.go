@@ -1,10 +1,12 @@
 package sql
 
 import (
+	"errors"
 	"log/slog"
 	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/google/uuid"
 	"github.com/judedaryl/go-arrayutils"
@@ -22,6 +24,12 @@ func NewDividendsRepository(db *gorm.DB, tr *TickersRepository, logger *slog.Log
 }
 
 func (r *DividendsRepository) Create(dividend domain.Dividend, userEmail string) (*domain.DividendWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, dividend.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	dividend.PortfolioId = portfolioId
+
 	id := uuid.New().String()
 	dbDividend := Dividend{
 		ID:                        id,
@@ -32,6 +40,10 @@ func (r *DividendsRepository) Create(dividend domain.Dividend, userEmail string)
 		Currency:                  dividend.Currency,
 		DoubleTaxationOrigin:      dividend.DoubleTaxationOrigin,
 		DoubleTaxationDestination: dividend.DoubleTaxationDestination,
+		UseCashAccount:            dividend.UseCashAccount,
+		PortfolioID:               dividend.PortfolioId,
+		Status:                    dividend.Status,
+		ExDividendDate:            optionalTime(dividend.ExDividendDate),
 		Date:                      time.Time(dividend.Date),
 	}
 	if err := r.db.Create(&dbDividend).Error; err != nil {
@@ -54,9 +66,9 @@ func (r *DividendsRepository) Create(dividend domain.Dividend, userEmail string)
 	}, nil
 }
 
-func (r *DividendsRepository) FindAll(userEmail string) (domain.Dividends, error) {
+func (r *DividendsRepository) FindAll(userEmail string, portfolioId *string) (domain.Dividends, error) {
 	dbDividends := []Dividend{}
-	if err := r.db.Where("user_email = ?", userEmail).Find(&dbDividends).Error; err != nil {
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Find(&dbDividends).Error; err != nil {
 		return nil, err
 	}
 
@@ -87,6 +99,10 @@ func (r *DividendsRepository) FindAll(userEmail string) (domain.Dividends, error
 				DoubleTaxationDestination: dbDividend.DoubleTaxationDestination,
 				Date:                      domain.Date(dbDividend.Date),
 				IsReinvested:              dbDividend.IsReinvested,
+				UseCashAccount:            dbDividend.UseCashAccount,
+				PortfolioId:               dbDividend.PortfolioID,
+				Status:                    dbDividend.Status,
+				ExDividendDate:            optionalDate(dbDividend.ExDividendDate),
 			},
 		}
 	}
@@ -94,7 +110,7 @@ func (r *DividendsRepository) FindAll(userEmail string) (domain.Dividends, error
 	return dividends, nil
 }
 
-func (r *DividendsRepository) FindAllPreferredCurrency(userEmail string) (domain.Dividends, error) {
+func (r *DividendsRepository) FindAllPreferredCurrency(userEmail string, portfolioId *string) (domain.Dividends, error) {
 	dbDividends := []Dividend{}
 	err := r.db.Raw(`
 	WITH _USER AS (
@@ -117,7 +133,9 @@ func (r *DividendsRepository) FindAllPreferredCurrency(userEmail string) (domain
 	FROM DIVIDENDS
 	INNER JOIN _RATES ON _RATES.SOURCE_CURRENCY = DIVIDENDS.CURRENCY
 	WHERE USER_EMAIL = ? AND DIVIDENDS.DELETED_AT IS NULL
-	`, userEmail, userEmail).Scan(&dbDividends).Error
+		AND (? IS NULL OR DIVIDENDS.PORTFOLIO_ID = ?)
+		AND COALESCE(DIVIDENDS.STATUS, '') <> ?
+	`, userEmail, userEmail, portfolioId, portfolioId, domain.DividendPending).Scan(&dbDividends).Error
 	if err != nil {
 		return nil, err
 	}
@@ -147,6 +165,10 @@ func (r *DividendsRepository) FindAllPreferredCurrency(userEmail string) (domain
 				DoubleTaxationOrigin:      dbDividend.DoubleTaxationOrigin,
 				DoubleTaxationDestination: dbDividend.DoubleTaxationDestination,
 				IsReinvested:              dbDividend.IsReinvested,
+				UseCashAccount:            dbDividend.UseCashAccount,
+				PortfolioId:               dbDividend.PortfolioID,
+				Status:                    dbDividend.Status,
+				ExDividendDate:            optionalDate(dbDividend.ExDividendDate),
 				Date:                      domain.Date(dbDividend.Date),
 			},
 		}
@@ -155,10 +177,501 @@ func (r *DividendsRepository) FindAllPreferredCurrency(userEmail string) (domain
 	return dividends, nil
 }
 
+func (r *DividendsRepository) Update(id string, dividend domain.Dividend, userEmail string) (*domain.DividendWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, dividend.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	dividend.PortfolioId = portfolioId
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Dividend{}).Where("id = ? AND user_email = ?", id, userEmail).Updates(map[string]interface{}{
+			"company":                     dividend.Company,
+			"country":                     dividend.Country,
+			"amount":                      dividend.Amount,
+			"currency":                    dividend.Currency,
+			"double_taxation_origin":      dividend.DoubleTaxationOrigin,
+			"double_taxation_destination": dividend.DoubleTaxationDestination,
+			"use_cash_account":            dividend.UseCashAccount,
+			"portfolio_id":                dividend.PortfolioId,
+			"date":                        time.Time(dividend.Date),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return setReinvested(tx, userEmail, id, dividend.IsReinvested)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		r.l.Error("Failed to update dividend", "error", err.Error())
+		return nil, err
+	}
+
+	nt, err := r.tr.FindByTicker(dividend.Company, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.DividendWithId{
+		Id: id,
+		TickerData: &domain.SimplifiedTicker{
+			Name:    nt.Name,
+			Website: nt.Website,
+			Ticker:  nt.Ticker,
+		},
+		Dividend: dividend,
+	}, nil
+}
+
+// Delete deletes the dividend together with its reinvestment buys
 func (r *DividendsRepository) Delete(id string, userEmail string) error {
-	return r.db.Where("id = ? AND user_email = ?", id, userEmail).Delete(&Dividend{}).Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteReinvestmentBuys(tx, userEmail, id); err != nil {
+			return err
+		}
+		return tx.Where("id = ? AND user_email = ?", id, userEmail).Delete(&Dividend{}).Error
+	})
+	if err != nil {
+		r.l.Error("Failed to delete dividend", "error", err.Error())
+	}
+	return err
+}
+
+// UpdateDividends marks the dividends as reinvested or not. The reinvestment buy of a
+// dividend is replaced when units are given and removed when it is no longer reinvested.
+func (r *DividendsRepository) UpdateDividends(userEmail string, reinvestments domain.DividendReinvestments) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, reinvestment := range reinvestments {
+			var dbDividend Dividend
+			err := tx.Where("id = ? AND user_email = ?", reinvestment.Id, userEmail).First(&dbDividend).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := setReinvested(tx, userEmail, dbDividend.ID, reinvestment.Reinvested); err != nil {
+				return err
+			}
+			if !reinvestment.Reinvested || reinvestment.Units == 0 {
+				continue
+			}
+
+			if err := deleteReinvestmentBuys(tx, userEmail, dbDividend.ID); err != nil {
+				return err
+			}
+
+			dbBuy := Buy{
+				ID:             uuid.New().String(),
+				UserEmail:      userEmail,
+				Units:          reinvestment.Units,
+				Ticker:         dbDividend.Company,
+				Amount:         reinvestment.Units * reinvestment.Price,
+				Currency:       dbDividend.Currency,
+				IsReinvestment: true,
+				PortfolioID:    dbDividend.PortfolioID,
+				DividendID:     dbDividend.ID,
+				Date:           dbDividend.Date,
+			}
+			if err := tx.Create(&dbBuy).Error; err != nil {
+				return err
+			}
+			if err := recomputeSellsCostBasis(tx, userEmail, dbBuy.Ticker, dbBuy.Date); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.l.Error("Failed to update dividends", "error", err.Error())
+	}
+	return err
 }
 
-func (r *DividendsRepository) UpdateDividends(userEmail string, ids []string, reinvested bool) error {
-	return r.db.Model(&Dividend{}).Where("user_email = ? AND id IN ?", userEmail, ids).Update("is_reinvested", reinvested).Error
+// FindOrphanedReinvestments returns the reinvestment buys and the reinvested dividends
+// that lost their counterpart
+func (r *DividendsRepository) FindOrphanedReinvestments(userEmail string) (*domain.OrphanedReinvestments, error) {
+	reinvestedDividends := r.db.Model(&Dividend{}).Select("id").Where("user_email = ? AND is_reinvested = ?", userEmail, true)
+	dbBuys := []Buy{}
+	err := r.db.Where("user_email = ? AND dividend_id <> '' AND dividend_id NOT IN (?)", userEmail, reinvestedDividends).
+		Order("date asc").
+		Find(&dbBuys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	reinvestmentBuys := r.db.Model(&Buy{}).Select("dividend_id").Where("user_email = ? AND dividend_id <> ''", userEmail)
+	dbDividends := []Dividend{}
+	err = r.db.Where("user_email = ? AND is_reinvested = ? AND id NOT IN (?)", userEmail, true, reinvestmentBuys).
+		Order("date asc").
+		Find(&dbDividends).Error
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := &domain.OrphanedReinvestments{
+		Buys:      make(domain.Buys, len(dbBuys)),
+		Dividends: make(domain.Dividends, len(dbDividends)),
+	}
+	for i, dbBuy := range dbBuys {
+		orphans.Buys[i] = dbBuyToDomain(dbBuy)
+	}
+	for i, dbDividend := range dbDividends {
+		orphans.Dividends[i] = dbDividendToDomain(dbDividend)
+	}
+	return orphans, nil
+}
+
+// FindPayments returns the confirmed dividends with the units held when they were paid and
+// the FIFO cost basis of those units. Amounts are in the preferred currency of the user, or
+// in the one of the portfolio, at the current exchange rates so the currency moves do not
+// distort the growth of the payments.
+func (r *DividendsRepository) FindPayments(userEmail string, portfolioId *string) (domain.PaidDividends, error) {
+	var user User
+	if err := r.db.Where("email = ?", userEmail).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	currency := user.PreferredCurrency
+	if portfolioId != nil {
+		var portfolio Portfolio
+		if err := r.db.Where("id = ? AND user_email = ?", *portfolioId, userEmail).First(&portfolio).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, domain.ErrPortfolioNotFound
+			}
+			return nil, err
+		}
+		currency = portfolio.Currency
+	}
+
+	dbDividends := []Dividend{}
+	err := r.db.Scopes(withPortfolio(portfolioId)).
+		Where("user_email = ?", userEmail).
+		Where("COALESCE(status, '') <> ?", domain.DividendPending).
+		Order("date asc").
+		Find(&dbDividends).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := findRatesTo(r.db, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	fifo, err := sells.NewCostBasisMethod(domain.FIFO)
+	if err != nil {
+		return nil, err
+	}
+
+	lotsByTicker := map[string]rawLots{}
+	payments := make(domain.PaidDividends, len(dbDividends))
+	for i, dbDividend := range dbDividends {
+		lots, present := lotsByTicker[dbDividend.Company]
+		if !present {
+			lots, err = findRawLots(r.db, userEmail, actions.SourceTickers(dbDividend.Company), currency, portfolioId)
+			if err != nil {
+				return nil, err
+			}
+			lotsByTicker[dbDividend.Company] = lots
+		}
+
+		// The units entitled to the dividend are the ones held before the ex-date, when
+		// it is unknown the ones held on the payment date
+		held := func(date domain.Date) bool {
+			return !time.Time(date).After(dbDividend.Date)
+		}
+		if dbDividend.ExDividendDate != nil {
+			held = func(date domain.Date) bool {
+				return time.Time(date).Before(*dbDividend.ExDividendDate)
+			}
+		}
+
+		lotBuys, lotSells := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, dbDividend.Date)
+		lotBuys, lotSells = sells.FilterByTicker(lotBuys, lotSells, dbDividend.Company)
+		heldBuys := arrayutils.Filter(lotBuys, func(b domain.BuyWithId) bool {
+			return held(b.Date)
+		})
+		heldSells := arrayutils.Filter(lotSells, func(s domain.SellWithId) bool {
+			return held(s.Date)
+		})
+
+		units := float32(0)
+		for _, b := range heldBuys {
+			units += b.Units
+		}
+		for _, s := range heldSells {
+			units -= s.Units
+		}
+		units = max(units, 0)
+		averagePrice, _ := sells.ComputeAvgPurchasePrice(fifo, heldBuys, heldSells, false)
+
+		payments[i] = domain.PaidDividend{
+			DividendId: dbDividend.ID,
+			Ticker:     dbDividend.Company,
+			Date:       domain.Date(dbDividend.Date),
+			Currency:   currency,
+			Amount:     dbDividend.Amount * rates[dbDividend.Currency],
+			Units:      units,
+			CostBasis:  averagePrice * units,
+		}
+	}
+	return payments, nil
+}
+
+// setReinvested marks the dividend as reinvested or not. The reinvestment buys of a
+// dividend no longer reinvested are deleted.
+func setReinvested(tx *gorm.DB, userEmail, dividendId string, reinvested bool) error {
+	err := tx.Model(&Dividend{}).Where("id = ? AND user_email = ?", dividendId, userEmail).Update("is_reinvested", reinvested).Error
+	if err != nil || reinvested {
+		return err
+	}
+	return deleteReinvestmentBuys(tx, userEmail, dividendId)
+}
+
+// deleteReinvestmentBuys deletes the buys reinvesting the dividend and recomputes the
+// cost basis of the sells that may have matched them
+func deleteReinvestmentBuys(tx *gorm.DB, userEmail, dividendId string) error {
+	dbBuys := []Buy{}
+	if err := tx.Where("user_email = ? AND dividend_id = ?", userEmail, dividendId).Find(&dbBuys).Error; err != nil {
+		return err
+	}
+
+	for _, dbBuy := range dbBuys {
+		if err := tx.Delete(&dbBuy).Error; err != nil {
+			return err
+		}
+		if err := recomputeSellsCostBasis(tx, userEmail, dbBuy.Ticker, dbBuy.Date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Confirm sets the real figures of a pending dividend and marks it as confirmed
+func (r *DividendsRepository) Confirm(id string, confirmation domain.DividendConfirmation, userEmail string) (*domain.DividendWithId, error) {
+	var dbDividend Dividend
+	err := r.db.Where("id = ? AND user_email = ?", id, userEmail).First(&dbDividend).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dbDividend.Status != domain.DividendPending {
+		return nil, domain.ErrDividendNotPending
+	}
+
+	date := dbDividend.Date
+	if confirmation.Date != nil {
+		date = time.Time(*confirmation.Date)
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&dbDividend).Updates(map[string]interface{}{
+			"amount":                      confirmation.Amount,
+			"double_taxation_origin":      confirmation.DoubleTaxationOrigin,
+			"double_taxation_destination": confirmation.DoubleTaxationDestination,
+			"use_cash_account":            confirmation.UseCashAccount,
+			"status":                      domain.DividendConfirmed,
+			"date":                        date,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return setReinvested(tx, userEmail, id, confirmation.IsReinvested)
+	})
+	if err != nil {
+		r.l.Error("Failed to confirm dividend", "error", err.Error())
+		return nil, err
+	}
+	dbDividend.Amount = confirmation.Amount
+	dbDividend.DoubleTaxationOrigin = confirmation.DoubleTaxationOrigin
+	dbDividend.DoubleTaxationDestination = confirmation.DoubleTaxationDestination
+	dbDividend.IsReinvested = confirmation.IsReinvested
+	dbDividend.UseCashAccount = confirmation.UseCashAccount
+	dbDividend.Status = domain.DividendConfirmed
+	dbDividend.Date = date
+
+	nt, err := r.tr.FindByTicker(dbDividend.Company, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.DividendWithId{
+		Id: id,
+		TickerData: &domain.SimplifiedTicker{
+			Name:    nt.Name,
+			Website: nt.Website,
+			Ticker:  nt.Ticker,
+		},
+		Dividend: dbDividendToDomain(dbDividend).Dividend,
+	}, nil
+}
+
+// GeneratePending creates a pending dividend for every holding of the user whose last
+// ex-dividend date is not after asOf. The gross amount is estimated from the units held
+// the day before the ex-date and the announced dividend per share, in the currency of the
+// portfolio. It returns the number of dividends created.
+func (r *DividendsRepository) GeneratePending(userEmail string, asOf time.Time) (int, error) {
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	var portfolios []Portfolio
+	if err := r.db.Where("user_email = ?", userEmail).Find(&portfolios).Error; err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, portfolio := range portfolios {
+		var boughtTickers []string
+		err := r.db.Model(&Buy{}).
+			Where("user_email = ? AND portfolio_id = ?", userEmail, portfolio.ID).
+			Distinct().Pluck("ticker", &boughtTickers).Error
+		if err != nil {
+			return created, err
+		}
+
+		tickers := []string{}
+		for _, ticker := range boughtTickers {
+			tickers = append(tickers, actions.DerivedTickers(ticker)...)
+		}
+		tickers = utils.ArrayUnique(tickers)
+		if len(tickers) == 0 {
+			continue
+		}
+
+		tickersInfo, err := r.tr.FindMultipleTickers(tickers, &portfolio.Currency)
+		if err != nil {
+			return created, err
+		}
+
+		for _, ticker := range tickers {
+			info, present := tickersInfo[ticker]
+			if !present || info.ExDividendDate == nil || info.NextDividendValue <= 0 {
+				continue
+			}
+			exDate := time.Time(*info.ExDividendDate)
+			if exDate.After(asOf) {
+				continue
+			}
+
+			var count int64
+			err := r.db.Unscoped().Model(&Dividend{}).
+				Where("user_email = ? AND company = ? AND ex_dividend_date = ? AND portfolio_id = ?", userEmail, ticker, exDate, portfolio.ID).
+				Count(&count).Error
+			if err != nil {
+				return created, err
+			}
+			if count > 0 {
+				continue
+			}
+
+			units, err := r.unitsHeldBefore(userEmail, ticker, portfolio, actions, exDate)
+			if err != nil {
+				return created, err
+			}
+			if units < 1e-4 {
+				continue
+			}
+
+			date := exDate
+			if info.DividendPaymentDate != nil && !time.Time(*info.DividendPaymentDate).Before(exDate) {
+				date = time.Time(*info.DividendPaymentDate)
+			}
+
+			err = r.db.Create(&Dividend{
+				ID:             uuid.New().String(),
+				UserEmail:      userEmail,
+				Company:        ticker,
+				Country:        info.Country,
+				Amount:         units * info.NextDividendValue,
+				Currency:       portfolio.Currency,
+				PortfolioID:    portfolio.ID,
+				Status:         domain.DividendPending,
+				ExDividendDate: &exDate,
+				Date:           date,
+			}).Error
+			if err != nil {
+				r.l.Error("Failed to create pending dividend", "ticker", ticker, "error", err.Error())
+				return created, err
+			}
+			created++
+		}
+	}
+	return created, nil
+}
+
+// unitsHeldBefore returns the units of the ticker held in the portfolio before the date,
+// the ones bought on the ex-date are not entitled to the dividend
+func (r *DividendsRepository) unitsHeldBefore(userEmail, ticker string, portfolio Portfolio, actions domain.CorporateActions, date time.Time) (float32, error) {
+	lots, err := findRawLots(r.db, userEmail, actions.SourceTickers(ticker), portfolio.Currency, &portfolio.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	buys, previousSells := sells.ApplyCorporateActions(lots.buys, lots.sells, actions, date)
+	buys, previousSells = sells.FilterByTicker(buys, previousSells, ticker)
+
+	units := float32(0)
+	for _, b := range buys {
+		if time.Time(b.Buy.Date).Before(date) {
+			units += b.Buy.Units
+		}
+	}
+	for _, s := range previousSells {
+		if time.Time(s.Sell.Date).Before(date) {
+			units -= s.Sell.Units
+		}
+	}
+	return units, nil
+}
+
+func dbDividendToDomain(dbDividend Dividend) domain.DividendWithId {
+	return domain.DividendWithId{
+		Id: dbDividend.ID,
+		Dividend: domain.Dividend{
+			Company:                   dbDividend.Company,
+			Country:                   dbDividend.Country,
+			Amount:                    dbDividend.Amount,
+			Currency:                  dbDividend.Currency,
+			DoubleTaxationOrigin:      dbDividend.DoubleTaxationOrigin,
+			DoubleTaxationDestination: dbDividend.DoubleTaxationDestination,
+			IsReinvested:              dbDividend.IsReinvested,
+			UseCashAccount:            dbDividend.UseCashAccount,
+			PortfolioId:               dbDividend.PortfolioID,
+			Status:                    dbDividend.Status,
+			ExDividendDate:            optionalDate(dbDividend.ExDividendDate),
+			Date:                      domain.Date(dbDividend.Date),
+		},
+	}
+}
+
+func optionalDate(t *time.Time) *domain.Date {
+	if t == nil {
+		return nil
+	}
+	d := domain.Date(*t)
+	return &d
+}
+
+func optionalTime(d *domain.Date) *time.Time {
+	if d == nil {
+		return nil
+	}
+	t := time.Time(*d)
+	return &t
 }
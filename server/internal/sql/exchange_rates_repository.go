@@ -2,7 +2,10 @@ package sql
 
 import (
 	"log/slog"
+	"sort"
+	"time"
 
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
 	"gorm.io/gorm"
 )
 
@@ -45,3 +48,105 @@ func (r *ExchangeRatesRepository) FindAllExchangeRates() (map[string]map[string]
 
 	return rates, nil
 }
+
+// FindExchangeRatesAt returns the exchange rates of the last day on or before date. Pairs
+// without rates back then take the current rate and are flagged as approximate.
+func (r *ExchangeRatesRepository) FindExchangeRatesAt(date time.Time) (domain.DatedExchangeRates, error) {
+	rates, err := r.FindAllExchangeRates()
+	if err != nil {
+		return domain.DatedExchangeRates{}, err
+	}
+
+	approximate := make(map[string]map[string]bool, len(rates))
+	for source, targets := range rates {
+		approximate[source] = make(map[string]bool, len(targets))
+		for target := range targets {
+			approximate[source][target] = true
+		}
+	}
+
+	var historics []ExchangeRateHistoric
+	err = r.db.Raw(`
+	SELECT H.*
+	FROM EXCHANGE_RATE_HISTORICS H
+	WHERE H.DATE = (
+		SELECT MAX(DATE)
+		FROM EXCHANGE_RATE_HISTORICS
+		WHERE SOURCE_CURRENCY = H.SOURCE_CURRENCY AND TARGET_CURRENCY = H.TARGET_CURRENCY AND DATE <= ?
+	)
+	`, date).Scan(&historics).Error
+	if err != nil {
+		return domain.DatedExchangeRates{}, err
+	}
+
+	for _, rate := range historics {
+		if rates[rate.SourceCurrency] == nil {
+			rates[rate.SourceCurrency] = make(map[string]float32)
+		}
+		rates[rate.SourceCurrency][rate.TargetCurrency] = rate.Rate
+		delete(approximate[rate.SourceCurrency], rate.TargetCurrency)
+	}
+	return domain.DatedExchangeRates{Rates: rates, Approximate: approximate}, nil
+}
+
+// findRatesTo returns the current rates from every currency to the target one
+func findRatesTo(db *gorm.DB, currency string) (map[string]float32, error) {
+	var exchangeRates []ExchangeRate
+	if err := db.Where("target_currency = ?", currency).Find(&exchangeRates).Error; err != nil {
+		return nil, err
+	}
+
+	rates := map[string]float32{currency: 1}
+	for _, rate := range exchangeRates {
+		rates[rate.SourceCurrency] = rate.Rate
+	}
+	return rates, nil
+}
+
+// rateHistory holds the daily rates from every currency to a target one
+type rateHistory struct {
+	target  string
+	current map[string]float32
+	daily   map[string][]ExchangeRateHistoric
+}
+
+// findRateHistoryTo returns the daily rates from every currency to the target one, in
+// date order
+func findRateHistoryTo(db *gorm.DB, currency string) (rateHistory, error) {
+	current, err := findRatesTo(db, currency)
+	if err != nil {
+		return rateHistory{}, err
+	}
+
+	var historics []ExchangeRateHistoric
+	if err := db.Where("target_currency = ?", currency).Order("date asc").Find(&historics).Error; err != nil {
+		return rateHistory{}, err
+	}
+
+	daily := map[string][]ExchangeRateHistoric{}
+	for _, h := range historics {
+		daily[h.SourceCurrency] = append(daily[h.SourceCurrency], h)
+	}
+	return rateHistory{target: currency, current: current, daily: daily}, nil
+}
+
+// at returns the rate of the last day on or before date, falling back to the current rate
+// when there is none back then
+func (h rateHistory) at(source string, date time.Time) (float32, bool) {
+	rates := h.daily[source]
+	i := sort.Search(len(rates), func(i int) bool {
+		return rates[i].Date.After(date)
+	})
+	if i > 0 {
+		return rates[i-1].Rate, true
+	}
+	rate, present := h.current[source]
+	return rate, present
+}
+
+// approximate tells whether the rate at date falls back to the current rate, because the
+// rate history of the currency begins later
+func (h rateHistory) approximate(source string, date time.Time) bool {
+	rates := h.daily[source]
+	return source != h.target && (len(rates) == 0 || rates[0].Date.After(date))
+}
@@ -0,0 +1,144 @@
+package sql
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// errDryRun is used to roll back the import transaction when only previewing
+var errDryRun = errors.New("dry run")
+
+type ImportsRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewImportsRepository(db *gorm.DB, logger *slog.Logger) *ImportsRepository {
+	return &ImportsRepository{db: db, l: logger}
+}
+
+// Import stores all the movements in a single transaction. Sells get their acquisition
+// value following the cost basis method of the user, which also updates the already
+// existing later sells. When dryRun is true, the transaction is rolled back after the
+// cost basis computation.
+func (r *ImportsRepository) Import(buys []domain.Buy, sells []domain.Sell, dividends []domain.Dividend, userEmail string, dryRun bool) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		portfolios := map[string]string{}
+		portfolioID := func(portfolioId string) (string, error) {
+			if resolved, present := portfolios[portfolioId]; present {
+				return resolved, nil
+			}
+			resolved, err := resolvePortfolioID(tx, portfolioId, userEmail)
+			if err != nil {
+				return "", err
+			}
+			portfolios[portfolioId] = resolved
+			return resolved, nil
+		}
+
+		recomputeFrom := map[string]time.Time{}
+		for _, buy := range buys {
+			portfolioId, err := portfolioID(buy.PortfolioId)
+			if err != nil {
+				return err
+			}
+
+			dbBuy := Buy{
+				ID:             uuid.New().String(),
+				UserEmail:      userEmail,
+				Units:          buy.Units,
+				Ticker:         buy.Ticker,
+				Taxes:          buy.Taxes,
+				Fee:            buy.Fee,
+				Amount:         buy.Amount,
+				Currency:       buy.Currency,
+				IsReinvestment: buy.IsReinvestment,
+				UseCashAccount: buy.UseCashAccount,
+				PortfolioID:    portfolioId,
+				Date:           time.Time(buy.Date),
+			}
+			if err := tx.Create(&dbBuy).Error; err != nil {
+				return err
+			}
+			updateRecomputeFrom(recomputeFrom, buy.Ticker, dbBuy.Date)
+		}
+
+		for _, sell := range sells {
+			portfolioId, err := portfolioID(sell.PortfolioId)
+			if err != nil {
+				return err
+			}
+			dbSell := Sell{
+				ID:        uuid.New().String(),
+				UserEmail: userEmail,
+				Units:     sell.Units,
+				Ticker:    sell.Ticker,
+				Amount:    sell.Amount,
+				Currency:  sell.Currency,
+				Fees:      sell.Fees,
+				Date:      time.Time(sell.Date),
+
+				UseCashAccount: sell.UseCashAccount,
+				PortfolioID:    portfolioId,
+			}
+			if err := tx.Create(&dbSell).Error; err != nil {
+				return err
+			}
+			updateRecomputeFrom(recomputeFrom, sell.Ticker, dbSell.Date)
+		}
+
+		for _, dividend := range dividends {
+			portfolioId, err := portfolioID(dividend.PortfolioId)
+			if err != nil {
+				return err
+			}
+			dbDividend := Dividend{
+				ID:                        uuid.New().String(),
+				UserEmail:                 userEmail,
+				Company:                   dividend.Company,
+				Country:                   dividend.Country,
+				Amount:                    dividend.Amount,
+				Currency:                  dividend.Currency,
+				DoubleTaxationOrigin:      dividend.DoubleTaxationOrigin,
+				DoubleTaxationDestination: dividend.DoubleTaxationDestination,
+				IsReinvested:              dividend.IsReinvested,
+				UseCashAccount:            dividend.UseCashAccount,
+				PortfolioID:               portfolioId,
+				Date:                      time.Time(dividend.Date),
+			}
+			if err := tx.Create(&dbDividend).Error; err != nil {
+				return err
+			}
+		}
+
+		for ticker, from := range recomputeFrom {
+			if err := recomputeSellsCostBasis(tx, userEmail, ticker, from); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	if err != nil {
+		r.l.Error("Failed to import movements", "error", err.Error())
+	}
+	return err
+}
+
+func updateRecomputeFrom(recomputeFrom map[string]time.Time, ticker string, date time.Time) {
+	if from, present := recomputeFrom[ticker]; !present || date.Before(from) {
+		recomputeFrom[ticker] = date
+	}
+}
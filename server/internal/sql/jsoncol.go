@@ -0,0 +1,48 @@
+package sql
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+)
+
+// SellLots is a custom type storing the lots chosen in a sell as a JSON string
+type SellLots []domain.SellLot
+
+// Value converts []domain.SellLot to a JSON string for the DB
+func (c SellLots) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan converts the DB string back into []domain.SellLot
+func (c *SellLots) Scan(value interface{}) error {
+	if value == nil {
+		*c = nil
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		b, ok := value.([]byte)
+		if !ok {
+			return errors.New("failed to scan SellLots: invalid type")
+		}
+		s = string(b)
+	}
+
+	if s == "" {
+		*c = nil
+		return nil
+	}
+	return json.Unmarshal([]byte(s), c)
+}
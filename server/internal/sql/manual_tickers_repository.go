@@ -0,0 +1,211 @@
+package sql
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ManualTickersRepository stores the tickers entered by hand and serves them as a market
+// data provider
+type ManualTickersRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewManualTickersRepository(db *gorm.DB, logger *slog.Logger) *ManualTickersRepository {
+	return &ManualTickersRepository{db: db, l: logger}
+}
+
+func (r *ManualTickersRepository) Source() string {
+	return domain.ManualProvider
+}
+
+func (r *ManualTickersRepository) FindAll() (domain.ManualTickers, error) {
+	var dbTickers []ManualTicker
+	if err := r.db.Order("ticker asc").Find(&dbTickers).Error; err != nil {
+		r.l.Error("Failed to fetch manual tickers", "error", err.Error())
+		return nil, err
+	}
+
+	tickers := make(domain.ManualTickers, 0, len(dbTickers))
+	for _, t := range dbTickers {
+		tickers = append(tickers, dbManualTickerToDomain(t))
+	}
+	return tickers, nil
+}
+
+func (r *ManualTickersRepository) Save(ticker domain.ManualTicker) (*domain.ManualTicker, error) {
+	dbTicker := domainManualTickerToDB(ticker)
+	if err := r.db.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{
+			"name", "price", "currency", "sector", "country", "industry", "is_etf", "website",
+			"isin", "exchange", "yearly_dividend_value", "updated_at",
+		}),
+	}).Create(&dbTicker).Error; err != nil {
+		r.l.Error("Failed to save manual ticker", "error", err.Error())
+		return nil, err
+	}
+
+	saved := dbManualTickerToDomain(dbTicker)
+	return &saved, nil
+}
+
+func (r *ManualTickersRepository) Delete(ticker string) error {
+	result := r.db.Where("ticker = ?", ticker).Delete(&ManualTicker{})
+	if result.Error != nil {
+		r.l.Error("Failed to delete manual ticker", "error", result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrManualTickerNotFound
+	}
+	return nil
+}
+
+func (r *ManualTickersRepository) FindByTicker(ticker string, currency *string) (domain.Ticker, error) {
+	tickers, err := r.FindMultipleTickers([]string{ticker}, currency)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+
+	t, ok := tickers[ticker]
+	if !ok {
+		return domain.Ticker{}, fmt.Errorf("manual ticker %s not found", ticker)
+	}
+	return t, nil
+}
+
+func (r *ManualTickersRepository) FindMultipleTickers(tickers []string, currency *string) (map[string]domain.Ticker, error) {
+	var dbTickers []ManualTicker
+	if err := r.db.Where("ticker IN ?", tickers).Find(&dbTickers).Error; err != nil {
+		r.l.Error("Failed to fetch manual tickers", "error", err.Error())
+		return nil, err
+	}
+
+	rates := map[string]float32{}
+	if currency != nil {
+		var err error
+		if rates, err = findRatesTo(r.db, *currency); err != nil {
+			return nil, err
+		}
+	}
+
+	tickersMap := make(map[string]domain.Ticker, len(dbTickers))
+	for _, dbTicker := range dbTickers {
+		ticker := manualTickerToTicker(dbTicker)
+		if currency != nil {
+			rate, ok := rates[ticker.Currency]
+			if !ok {
+				return nil, fmt.Errorf("no exchange rate from %s to %s", ticker.Currency, *currency)
+			}
+			ticker.Currency = *currency
+			ticker.Price *= rate
+			ticker.YearlyDividendValue *= rate
+			ticker.HistoricalData[0].Price *= rate
+		}
+		tickersMap[ticker.Ticker] = ticker
+	}
+	return tickersMap, nil
+}
+
+// FindPriceHistory returns the price entered for the ticker, dated when it was last updated
+func (r *ManualTickersRepository) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	return r.FindByTicker(ticker, nil)
+}
+
+func (r *ManualTickersRepository) Search(query string, limit int) (domain.TickerSearchResults, error) {
+	var dbTickers []ManualTicker
+	query = strings.ToUpper(strings.TrimSpace(query))
+	pattern := escapeLike(query)
+	if err := r.db.
+		Where(`UPPER(ticker) LIKE ? ESCAPE '\' OR UPPER(name) LIKE ? ESCAPE '\' OR UPPER(isin) = ?`,
+			pattern+"%", "%"+pattern+"%", query).
+		Order("ticker asc").
+		Limit(limit).
+		Find(&dbTickers).Error; err != nil {
+		r.l.Error("Failed to search manual tickers", "error", err.Error())
+		return nil, err
+	}
+
+	results := make(domain.TickerSearchResults, 0, len(dbTickers))
+	for _, t := range dbTickers {
+		results = append(results, domain.TickerSearchResult{
+			Ticker:   t.Ticker,
+			Name:     t.Name,
+			Isin:     t.Isin,
+			Exchange: t.Exchange,
+			Currency: t.Currency,
+			Type:     assetType(t.IsEtf),
+		})
+	}
+	return results, nil
+}
+
+func manualTickerToTicker(t ManualTicker) domain.Ticker {
+	var yield float32
+	if t.Price > 0 {
+		yield = t.YearlyDividendValue / t.Price
+	}
+
+	return domain.Ticker{
+		Ticker:              t.Ticker,
+		Name:                t.Name,
+		Price:               t.Price,
+		Currency:            t.Currency,
+		Sector:              t.Sector,
+		Country:             t.Country,
+		Industry:            t.Industry,
+		IsEtf:               t.IsEtf,
+		Website:             t.Website,
+		Isin:                t.Isin,
+		Exchange:            t.Exchange,
+		YearlyDividendValue: t.YearlyDividendValue,
+		YearlyDividendYield: yield,
+		MonthlyPriceRange:   domain.PriceRange{Min: t.Price, Max: t.Price},
+		YearlyPriceRange:    domain.PriceRange{Min: t.Price, Max: t.Price},
+		EarningDates:        []domain.DateWithTime{},
+		HistoricalData: []domain.HistoricalEntry{
+			{Date: domain.Date(t.UpdatedAt.Truncate(24 * time.Hour)), Price: t.Price},
+		},
+	}
+}
+
+func domainManualTickerToDB(t domain.ManualTicker) ManualTicker {
+	return ManualTicker{
+		Ticker:              t.Ticker,
+		Name:                t.Name,
+		Price:               t.Price,
+		Currency:            t.Currency,
+		Sector:              t.Sector,
+		Country:             t.Country,
+		Industry:            t.Industry,
+		IsEtf:               t.IsEtf,
+		Website:             t.Website,
+		Isin:                t.Isin,
+		Exchange:            t.Exchange,
+		YearlyDividendValue: t.YearlyDividendValue,
+	}
+}
+
+func dbManualTickerToDomain(t ManualTicker) domain.ManualTicker {
+	return domain.ManualTicker{
+		Ticker:              t.Ticker,
+		Name:                t.Name,
+		Price:               t.Price,
+		Currency:            t.Currency,
+		Sector:              t.Sector,
+		Country:             t.Country,
+		Industry:            t.Industry,
+		IsEtf:               t.IsEtf,
+		Website:             t.Website,
+		Isin:                t.Isin,
+		Exchange:            t.Exchange,
+		YearlyDividendValue: t.YearlyDividendValue,
+	}
+}
@@ -16,10 +16,14 @@ type Buy struct {
 	Amount         float32
 	Currency       string
 	IsReinvestment bool
-	Date           time.Time
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	UseCashAccount bool   `gorm:"default:false"`
+	PortfolioID    string `gorm:"index"`
+	// Buys reinvesting a dividend are owned by it
+	DividendID string `gorm:"index"`
+	Date       time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
 }
 
 type Sell struct {
@@ -32,10 +36,16 @@ type Sell struct {
 	AccumulatedFees  float32
 	AcquisitionValue float32
 	Currency         string
-	Date             time.Time
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
-	DeletedAt        gorm.DeletedAt `gorm:"index"`
+	UseCashAccount   bool `gorm:"default:false"`
+	// Sells closing a position acquired for cash are owned by the corporate action
+	CorporateActionID string `gorm:"index"`
+	PortfolioID       string `gorm:"index"`
+	// Lots chosen when selling with the specific lot method
+	Lots      SellLots `gorm:"type:text"`
+	Date      time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
 type Dividend struct {
@@ -47,7 +57,11 @@ type Dividend struct {
 	Currency                  string
 	DoubleTaxationOrigin      float32
 	DoubleTaxationDestination float32
-	IsReinvested              bool `gorm:"default:false"`
+	IsReinvested              bool   `gorm:"default:false"`
+	UseCashAccount            bool   `gorm:"default:false"`
+	PortfolioID               string `gorm:"index"`
+	Status                    string `gorm:"default:confirmed;index"`
+	ExDividendDate            *time.Time
 	Date                      time.Time
 	CreatedAt                 time.Time
 	UpdatedAt                 time.Time
@@ -58,6 +72,8 @@ type User struct {
 	ID                string `gorm:"primarykey"`
 	Email             string `gorm:"unique"`
 	PreferredCurrency string
+	CostBasisMethod   string `gorm:"default:fifo"`
+	Benchmarks        string
 	Picture           string
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
@@ -71,6 +87,34 @@ type ExchangeRate struct {
 	CreatedAt      time.Time
 }
 
+// TreatyRate overrides the default treaty rate of a country for the user
+type TreatyRate struct {
+	UserEmail string `gorm:"primarykey"`
+	Country   string `gorm:"primarykey"`
+	Rate      float32
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AllocationTarget is the weight the user aims for a group of an allocation dimension
+type AllocationTarget struct {
+	UserEmail string `gorm:"primarykey"`
+	Dimension string `gorm:"primarykey"`
+	Label     string `gorm:"primarykey"`
+	Weight    float32
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ExchangeRateHistoric keeps the exchange rate of every day, so amounts can be converted
+// at the rate of the date they happened
+type ExchangeRateHistoric struct {
+	SourceCurrency string    `gorm:"primarykey"`
+	TargetCurrency string    `gorm:"primarykey"`
+	Date           time.Time `gorm:"primarykey"`
+	Rate           float32
+}
+
 type PortfolioHistoric struct {
 	ID                   string `gorm:"primarykey"`
 	UserEmail            string
@@ -78,7 +122,11 @@ type PortfolioHistoric struct {
 	BuyValue             float32
 	ValueWithoutReinvest float32
 	Currency             string
-	CreatedAt            time.Time
+	// Snapshots of the whole account have no portfolio
+	PortfolioID string `gorm:"index;default:''"`
+	// Snapshots rebuilt from the transactions, replaced on every backfill
+	Backfilled bool `gorm:"default:false"`
+	CreatedAt  time.Time
 }
 
 type Ticker struct {
@@ -106,4 +154,81 @@ type Ticker struct {
 	YearlyPriceRangeMin  float32
 	YearlyPriceRangeMax  float32
 	HistoricalData       string `gorm:"type:text"`
+	Source               string
+	Isin                 string
+	Exchange             string
+}
+
+// TickerPrice is the daily bar of a ticker, in the currency of the ticker
+type TickerPrice struct {
+	Ticker   string    `gorm:"primarykey"`
+	Date     time.Time `gorm:"primarykey"`
+	Open     float32
+	High     float32
+	Low      float32
+	Close    float32
+	AdjClose float32
+	Volume   int64
+	Currency string
+}
+
+type ManualTicker struct {
+	Ticker              string `gorm:"primarykey"`
+	Name                string
+	Price               float32
+	Currency            string
+	Sector              string
+	Country             string
+	Industry            string
+	IsEtf               bool
+	Website             string
+	Isin                string
+	Exchange            string
+	YearlyDividendValue float32
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+type CorporateAction struct {
+	ID                  string `gorm:"primarykey"`
+	UserEmail           string
+	Type                string
+	Ticker              string
+	NewTicker           string
+	Ratio               float32
+	CostBasisPercentage float32
+	Price               float32
+	Currency            string
+	Date                time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           gorm.DeletedAt `gorm:"index"`
+}
+
+type CashTransaction struct {
+	ID             string `gorm:"primarykey"`
+	UserEmail      string
+	Type           string
+	Amount         float32
+	Currency       string
+	TargetAmount   float32
+	TargetCurrency string
+	Description    string
+	PortfolioID    string `gorm:"index"`
+	Date           time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+}
+
+type Portfolio struct {
+	ID        string `gorm:"primarykey"`
+	UserEmail string `gorm:"index"`
+	Name      string
+	Broker    string
+	Currency  string
+	IsDefault bool `gorm:"default:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
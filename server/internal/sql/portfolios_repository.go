@@ -0,0 +1,269 @@
+package sql
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultPortfolioName is the name of the portfolio created for every user, which
+// receives the movements registered before portfolios existed
+const defaultPortfolioName = "Default"
+
+type PortfoliosRepository struct {
+	db *gorm.DB
+	l  *slog.Logger
+}
+
+func NewPortfoliosRepository(db *gorm.DB, logger *slog.Logger) *PortfoliosRepository {
+	return &PortfoliosRepository{db: db, l: logger}
+}
+
+func (r *PortfoliosRepository) Create(portfolio domain.Portfolio, userEmail string) (*domain.PortfolioWithId, error) {
+	id := uuid.New().String()
+	dbPortfolio := Portfolio{
+		ID:        id,
+		UserEmail: userEmail,
+		Name:      portfolio.Name,
+		Broker:    portfolio.Broker,
+		Currency:  portfolio.Currency,
+	}
+	if err := r.db.Create(&dbPortfolio).Error; err != nil {
+		r.l.Error("Failed to create portfolio", "error", err.Error())
+		return nil, err
+	}
+
+	return &domain.PortfolioWithId{Id: id, Portfolio: portfolio}, nil
+}
+
+func (r *PortfoliosRepository) FindAll(userEmail string) (domain.Portfolios, error) {
+	dbPortfolios := []Portfolio{}
+	if err := r.db.Where("user_email = ?", userEmail).Order("is_default desc, created_at asc").Find(&dbPortfolios).Error; err != nil {
+		return nil, err
+	}
+
+	portfolios := make(domain.Portfolios, len(dbPortfolios))
+	for i, dbPortfolio := range dbPortfolios {
+		portfolios[i] = dbPortfolioToDomain(dbPortfolio)
+	}
+	return portfolios, nil
+}
+
+func (r *PortfoliosRepository) FindByID(id string, userEmail string) (*domain.PortfolioWithId, error) {
+	dbPortfolio := Portfolio{}
+	if err := r.db.Where("id = ? AND user_email = ?", id, userEmail).First(&dbPortfolio).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	portfolio := dbPortfolioToDomain(dbPortfolio)
+	return &portfolio, nil
+}
+
+func (r *PortfoliosRepository) Update(id string, portfolio domain.Portfolio, userEmail string) (*domain.PortfolioWithId, error) {
+	result := r.db.Model(&Portfolio{}).Where("id = ? AND user_email = ?", id, userEmail).Updates(map[string]interface{}{
+		"name":     portfolio.Name,
+		"broker":   portfolio.Broker,
+		"currency": portfolio.Currency,
+	})
+	if result.Error != nil {
+		r.l.Error("Failed to update portfolio", "error", result.Error.Error())
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return r.FindByID(id, userEmail)
+}
+
+// Delete removes an empty portfolio. The default portfolio and the ones still holding
+// movements are kept and domain.ErrPortfolioInUse is returned.
+func (r *PortfoliosRepository) Delete(id string, userEmail string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var dbPortfolio Portfolio
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&dbPortfolio).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if dbPortfolio.IsDefault {
+			return domain.ErrPortfolioInUse
+		}
+
+		for _, model := range []interface{}{&Buy{}, &Sell{}, &Dividend{}} {
+			var count int64
+			if err := tx.Model(model).Where("portfolio_id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return domain.ErrPortfolioInUse
+			}
+		}
+
+		if err := tx.Where("portfolio_id = ?", id).Delete(&PortfolioHistoric{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&dbPortfolio).Error
+	})
+}
+
+// MigrateDefaultPortfolios leaves every user with a single default portfolio and moves to
+// it the buys, sells, dividends and cash transactions that do not belong to any portfolio.
+// Users with several defaults, created before they were unique, keep the oldest one, which
+// receives the movements of the others. Once done, the default portfolios are made unique.
+func MigrateDefaultPortfolios(db *gorm.DB) error {
+	var users []User
+	if err := db.Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := mergeDefaultPortfolios(tx, user); err != nil {
+				return err
+			}
+			return assignDefaultPortfolio(tx, user.Email)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return createDefaultPortfolioIndex(db)
+}
+
+// mergeDefaultPortfolios keeps the oldest default portfolio of the user, creating it when
+// there is none, and moves to it the movements of the other defaults before deleting them
+func mergeDefaultPortfolios(tx *gorm.DB, user User) error {
+	var defaults []Portfolio
+	if err := tx.Where("user_email = ? AND is_default = ?", user.Email, true).Order("created_at asc").Find(&defaults).Error; err != nil {
+		return err
+	}
+	if len(defaults) == 0 {
+		_, err := createDefaultPortfolio(tx, user.Email, user.PreferredCurrency)
+		return err
+	}
+
+	for _, duplicate := range defaults[1:] {
+		for _, model := range []interface{}{&Buy{}, &Sell{}, &Dividend{}, &CashTransaction{}} {
+			if err := tx.Model(model).Where("portfolio_id = ?", duplicate.ID).Update("portfolio_id", defaults[0].ID).Error; err != nil {
+				return err
+			}
+		}
+		// The snapshots of the duplicate are not comparable with the ones of the kept
+		// portfolio, the backfill rebuilds them
+		if err := tx.Where("portfolio_id = ?", duplicate.ID).Delete(&PortfolioHistoric{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&duplicate).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDefaultPortfolioIndex makes sure a user has no more than one default portfolio
+func createDefaultPortfolioIndex(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE UNIQUE INDEX IF NOT EXISTS IDX_PORTFOLIOS_DEFAULT
+	ON PORTFOLIOS (USER_EMAIL)
+	WHERE IS_DEFAULT AND DELETED_AT IS NULL
+	`).Error
+}
+
+// assignDefaultPortfolio moves the movements of the user without portfolio to the default one
+func assignDefaultPortfolio(db *gorm.DB, userEmail string) error {
+	portfolio, err := findDefaultPortfolio(db, userEmail)
+	if err != nil {
+		return err
+	}
+
+	for _, model := range []interface{}{&Buy{}, &Sell{}, &Dividend{}, &CashTransaction{}} {
+		err := db.Model(model).
+			Where("user_email = ? AND (portfolio_id IS NULL OR portfolio_id = '')", userEmail).
+			Update("portfolio_id", portfolio.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findDefaultPortfolio returns the default portfolio of the user, created when the user
+// signs up. Returns domain.ErrPortfolioNotFound when the user has none.
+func findDefaultPortfolio(db *gorm.DB, userEmail string) (Portfolio, error) {
+	var portfolio Portfolio
+	err := db.Where("user_email = ? AND is_default = ?", userEmail, true).First(&portfolio).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Portfolio{}, domain.ErrPortfolioNotFound
+	}
+	if err != nil {
+		return Portfolio{}, err
+	}
+	return portfolio, nil
+}
+
+// createDefaultPortfolio creates the default portfolio of the user in the currency
+func createDefaultPortfolio(db *gorm.DB, userEmail string, currency string) (Portfolio, error) {
+	portfolio := Portfolio{
+		ID:        uuid.New().String(),
+		UserEmail: userEmail,
+		Name:      defaultPortfolioName,
+		Currency:  currency,
+		IsDefault: true,
+	}
+	if err := db.Create(&portfolio).Error; err != nil {
+		return Portfolio{}, err
+	}
+	return portfolio, nil
+}
+
+// resolvePortfolioID returns the portfolio a movement is stored in. An empty id stands
+// for the default portfolio, any other one must belong to the user.
+func resolvePortfolioID(db *gorm.DB, portfolioId, userEmail string) (string, error) {
+	if portfolioId == "" {
+		portfolio, err := findDefaultPortfolio(db, userEmail)
+		if err != nil {
+			return "", err
+		}
+		return portfolio.ID, nil
+	}
+
+	var count int64
+	if err := db.Model(&Portfolio{}).Where("id = ? AND user_email = ?", portfolioId, userEmail).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", domain.ErrPortfolioNotFound
+	}
+	return portfolioId, nil
+}
+
+// withPortfolio filters the query by portfolio, a nil portfolio leaves it untouched
+func withPortfolio(portfolioId *string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if portfolioId == nil {
+			return db
+		}
+		return db.Where("portfolio_id = ?", *portfolioId)
+	}
+}
+
+func dbPortfolioToDomain(dbPortfolio Portfolio) domain.PortfolioWithId {
+	return domain.PortfolioWithId{
+		Id:        dbPortfolio.ID,
+		IsDefault: dbPortfolio.IsDefault,
+		Portfolio: domain.Portfolio{
+			Name:     dbPortfolio.Name,
+			Broker:   dbPortfolio.Broker,
+			Currency: dbPortfolio.Currency,
+		},
+	}
+}
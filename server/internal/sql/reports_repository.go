@@ -0,0 +1,283 @@
+package sql
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
+	"gorm.io/gorm"
+)
+
+type ReportsRepository struct {
+	db *gorm.DB
+	er *ExchangeRatesRepository
+	l  *slog.Logger
+}
+
+func NewReportsRepository(db *gorm.DB, er *ExchangeRatesRepository, logger *slog.Logger) *ReportsRepository {
+	return &ReportsRepository{db: db, er: er, l: logger}
+}
+
+// FindRealizedGains returns the realized gains of the sells dated in the year. The lots
+// each sell comes from are matched again with the cost basis method of the user, so the
+// units can be split by holding period. Proceeds are converted to the preferred currency at
+// the rate of the sell date and lots at the rate of the buy date.
+func (r *ReportsRepository) FindRealizedGains(userEmail string, year int, portfolioId *string) (domain.RealizedGains, error) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	var user User
+	if err := r.db.Where("email = ?", userEmail).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	dbSells := []Sell{}
+	err := r.db.Scopes(withPortfolio(portfolioId)).
+		Where("user_email = ? AND date >= ? AND date < ?", userEmail, start, end).
+		Find(&dbSells).Error
+	if err != nil {
+		return nil, err
+	}
+
+	inReport := map[string]bool{}
+	tickers := []string{}
+	for _, dbSell := range dbSells {
+		if !inReport[dbSell.Ticker] {
+			tickers = append(tickers, dbSell.Ticker)
+		}
+		inReport[dbSell.Ticker] = true
+		inReport[dbSell.ID] = true
+	}
+
+	actions, err := findCorporateActions(r.db, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := sells.NewCostBasisMethod(user.CostBasisMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := findRateHistoryTo(r.db, user.PreferredCurrency)
+	if err != nil {
+		return nil, err
+	}
+	// The cost basis is fixed when buying, so it does not move with the rates
+	approximateBuys := map[string]bool{}
+	lotsIn := func(tickers []string, _ string) (rawLots, error) {
+		return findRawLotsAtBuyDate(r.db, userEmail, tickers, history, approximateBuys)
+	}
+
+	ratesByDate := map[time.Time]domain.DatedExchangeRates{}
+	gains := domain.RealizedGains{}
+	for _, ticker := range tickers {
+		err := computeTickerSellsCost(r.db, userEmail, ticker, start, actions, method, lotsIn, func(dbSell Sell, cost sells.SellCostOutput) error {
+			if !inReport[dbSell.ID] {
+				return nil
+			}
+
+			rates, present := ratesByDate[dbSell.Date]
+			if !present {
+				var err error
+				rates, err = r.er.FindExchangeRatesAt(dbSell.Date)
+				if err != nil {
+					return err
+				}
+				ratesByDate[dbSell.Date] = rates
+			}
+
+			rate, approximate := rates.Rate(dbSell.Currency, user.PreferredCurrency)
+			for _, m := range cost.Matches {
+				approximate = approximate || approximateBuys[m.Buy.Id]
+			}
+			for _, gain := range splitRealizedGain(dbSell, cost, user.PreferredCurrency, rate) {
+				gain.ApproximateRate = approximate
+				gains = append(gains, gain)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gains, nil
+}
+
+// splitRealizedGain builds one gain per holding period of the lots matched with the sell.
+// The lots are in the preferred currency, rate converts the sell to it.
+func splitRealizedGain(dbSell Sell, cost sells.SellCostOutput, preferredCurrency string, rate float32) domain.RealizedGains {
+	type termLots struct {
+		units           float32
+		cost            float32
+		acquisitionDate time.Time
+	}
+
+	byTerm := map[string]*termLots{}
+	for _, m := range cost.Matches {
+		b := m.Buy.Buy
+		buyDate := time.Time(b.Date)
+		term := domain.ShortTerm
+		if buyDate.AddDate(1, 0, 0).Before(dbSell.Date) {
+			term = domain.LongTerm
+		}
+
+		tl, present := byTerm[term]
+		if !present {
+			tl = &termLots{acquisitionDate: buyDate}
+			byTerm[term] = tl
+		}
+		tl.units += m.Units
+		tl.cost += m.Units * (b.Amount + b.Fee + b.Taxes) / b.Units
+		if buyDate.Before(tl.acquisitionDate) {
+			tl.acquisitionDate = buyDate
+		}
+	}
+
+	gains := domain.RealizedGains{}
+	for _, term := range []string{domain.LongTerm, domain.ShortTerm} {
+		tl, present := byTerm[term]
+		if !present {
+			continue
+		}
+
+		share := tl.units / dbSell.Units
+		proceeds := dbSell.Amount * share
+		fees := dbSell.Fees * share
+		// The cost in the currency of the sell is the preferred one at the rate of the sell date
+		var costBasis float32
+		if rate != 0 {
+			costBasis = tl.cost / rate
+		}
+		preferredGain := (proceeds-fees)*rate - tl.cost
+		gains = append(gains, domain.RealizedGain{
+			SellId:          dbSell.ID,
+			Ticker:          dbSell.Ticker,
+			Term:            term,
+			Units:           tl.units,
+			AcquisitionDate: domain.Date(tl.acquisitionDate),
+			Date:            domain.Date(dbSell.Date),
+			Currency:        dbSell.Currency,
+			Proceeds:        proceeds,
+			CostBasis:       costBasis,
+			Fees:            fees,
+			Gain:            proceeds - costBasis - fees,
+
+			PreferredCurrency:  preferredCurrency,
+			ExchangeRate:       rate,
+			PreferredProceeds:  proceeds * rate,
+			PreferredCostBasis: tl.cost,
+			PreferredFees:      fees * rate,
+			PreferredGain:      preferredGain,
+		})
+	}
+	return gains
+}
+
+// FindDividendTaxes returns the taxes withheld from the dividends paid in the year, in
+// the preferred currency of the user at the exchange rate of the payment date
+func (r *ReportsRepository) FindDividendTaxes(userEmail string, year int, portfolioId *string) (domain.DividendTaxes, error) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	var user User
+	if err := r.db.Where("email = ?", userEmail).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	dbDividends := []Dividend{}
+	err := r.db.Scopes(withPortfolio(portfolioId)).
+		Where("user_email = ? AND date >= ? AND date < ?", userEmail, start, end).
+		Where("COALESCE(status, '') <> ?", domain.DividendPending).
+		Order("date asc").
+		Find(&dbDividends).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ratesByDate := map[time.Time]domain.DatedExchangeRates{}
+	taxes := make(domain.DividendTaxes, len(dbDividends))
+	for i, dbDividend := range dbDividends {
+		rates, present := ratesByDate[dbDividend.Date]
+		if !present {
+			rates, err = r.er.FindExchangeRatesAt(dbDividend.Date)
+			if err != nil {
+				return nil, err
+			}
+			ratesByDate[dbDividend.Date] = rates
+		}
+
+		rate, approximate := rates.Rate(dbDividend.Currency, user.PreferredCurrency)
+
+		gross := dbDividend.Amount * rate
+		sourceTax := gross * dbDividend.DoubleTaxationOrigin / 100
+		destinationTax := (gross - sourceTax) * dbDividend.DoubleTaxationDestination / 100
+		taxes[i] = domain.DividendTax{
+			DividendId:     dbDividend.ID,
+			Company:        dbDividend.Company,
+			Country:        dbDividend.Country,
+			Date:           domain.Date(dbDividend.Date),
+			Currency:       dbDividend.Currency,
+			ExchangeRate:   rate,
+			Gross:          gross,
+			SourceTax:      sourceTax,
+			DestinationTax: destinationTax,
+			Net:            gross - sourceTax - destinationTax,
+
+			ApproximateRate: approximate,
+		}
+	}
+	return taxes, nil
+}
+
+// FindTreatyRates returns the default treaty rates with the ones set by the user
+func (r *ReportsRepository) FindTreatyRates(userEmail string) (domain.TreatyRates, error) {
+	dbRates := []TreatyRate{}
+	if err := r.db.Where("user_email = ?", userEmail).Find(&dbRates).Error; err != nil {
+		return nil, err
+	}
+
+	byCountry := make(map[string]float32, len(domain.DefaultTreatyRates)+len(dbRates))
+	for country, rate := range domain.DefaultTreatyRates {
+		byCountry[country] = rate
+	}
+	for _, dbRate := range dbRates {
+		byCountry[dbRate.Country] = dbRate.Rate
+	}
+
+	rates := make(domain.TreatyRates, 0, len(byCountry))
+	for country, rate := range byCountry {
+		rates = append(rates, domain.TreatyRate{Country: country, Rate: rate})
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Country < rates[j].Country
+	})
+	return rates, nil
+}
+
+// UpdateTreatyRates replaces the treaty rates set by the user, the countries left out
+// go back to the default rates
+func (r *ReportsRepository) UpdateTreatyRates(rates domain.TreatyRates, userEmail string) (domain.TreatyRates, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_email = ?", userEmail).Delete(&TreatyRate{}).Error; err != nil {
+			return err
+		}
+
+		for _, rate := range rates {
+			dbRate := TreatyRate{UserEmail: userEmail, Country: rate.Country, Rate: rate.Rate}
+			if err := tx.Save(&dbRate).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.l.Error("Failed to update treaty rates", "error", err.Error())
+		return nil, err
+	}
+
+	return r.FindTreatyRates(userEmail)
+}
@@ -1,10 +1,12 @@
 package sql
 
 import (
+	"errors"
 	"log/slog"
 	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/sells"
 	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/google/uuid"
 	"github.com/judedaryl/go-arrayutils"
@@ -22,6 +24,12 @@ func NewSellsRepository(db *gorm.DB, tr *TickersRepository, logger *slog.Logger)
 }
 
 func (r *SellsRepository) Create(sell domain.Sell, userEmail string) (*domain.SellWithId, error) {
+	portfolioId, err := resolvePortfolioID(r.db, sell.PortfolioId, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	sell.PortfolioId = portfolioId
+
 	id := uuid.New().String()
 	dbSell := Sell{
 		ID:               id,
@@ -30,13 +38,30 @@ func (r *SellsRepository) Create(sell domain.Sell, userEmail string) (*domain.Se
 		Ticker:           sell.Ticker,
 		Amount:           sell.Amount,
 		AcquisitionValue: sell.AcquisitionValue,
+		AccumulatedFees:  sell.AccumulatedFees,
 		Currency:         sell.Currency,
 		Fees:             sell.Fees,
+		UseCashAccount:   sell.UseCashAccount,
+		PortfolioID:      sell.PortfolioId,
+		Lots:             sell.Lots,
 		Date:             time.Time(sell.Date),
 	}
-	if err := r.db.Create(&dbSell).Error; err != nil {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dbSell).Error; err != nil {
+			return err
+		}
+		// A backdated sell consumes lots the later sells were matched with
+		if err := recomputeSellsCostBasis(tx, userEmail, sell.Ticker, dbSell.Date); err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).First(&dbSell).Error
+	})
+	if err != nil {
+		r.l.Error("Failed to create sell", "error", err.Error())
 		return nil, err
 	}
+	sell.AcquisitionValue = dbSell.AcquisitionValue
+	sell.AccumulatedFees = dbSell.AccumulatedFees
 
 	nt, err := r.tr.FindByTicker(sell.Ticker, nil)
 	if err != nil {
@@ -53,9 +78,9 @@ func (r *SellsRepository) Create(sell domain.Sell, userEmail string) (*domain.Se
 	}, nil
 }
 
-func (r *SellsRepository) FindAll(userEmail string) (domain.Sells, error) {
+func (r *SellsRepository) FindAll(userEmail string, portfolioId *string) (domain.Sells, error) {
 	dbSells := []Sell{}
-	if err := r.db.Where("user_email = ?", userEmail).Find(&dbSells).Error; err != nil {
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ?", userEmail).Find(&dbSells).Error; err != nil {
 		return nil, err
 	}
 
@@ -85,16 +110,21 @@ func (r *SellsRepository) FindAll(userEmail string) (domain.Sells, error) {
 				Fees:             dbSell.Fees,
 				AccumulatedFees:  dbSell.AccumulatedFees,
 				Currency:         dbSell.Currency,
+				UseCashAccount:   dbSell.UseCashAccount,
 				Date:             domain.Date(dbSell.Date),
+
+				CorporateActionId: dbSell.CorporateActionID,
+				PortfolioId:       dbSell.PortfolioID,
+				Lots:              dbSell.Lots,
 			},
 		}
 	}
 	return sells, nil
 }
 
-func (r *SellsRepository) FindByTicker(ticker string, userEmail string) (domain.Sells, error) {
+func (r *SellsRepository) FindByTicker(ticker string, userEmail string, portfolioId *string) (domain.Sells, error) {
 	dbSells := []Sell{}
-	if err := r.db.Where("user_email = ? AND ticker = ?", userEmail, ticker).Find(&dbSells).Order("date asc").Error; err != nil {
+	if err := r.db.Scopes(withPortfolio(portfolioId)).Where("user_email = ? AND ticker = ?", userEmail, ticker).Find(&dbSells).Order("date asc").Error; err != nil {
 		return nil, err
 	}
 
@@ -110,16 +140,227 @@ func (r *SellsRepository) FindByTicker(ticker string, userEmail string) (domain.
 				AcquisitionValue: dbSell.AcquisitionValue,
 				Currency:         dbSell.Currency,
 				Fees:             dbSell.Fees,
+				UseCashAccount:   dbSell.UseCashAccount,
 				Date:             domain.Date(dbSell.Date),
+
+				CorporateActionId: dbSell.CorporateActionID,
+				PortfolioId:       dbSell.PortfolioID,
+				Lots:              dbSell.Lots,
 			},
 		}
 	}
 	return sells, nil
 }
 
+func (r *SellsRepository) Update(id string, sell domain.Sell, userEmail string) (*domain.SellWithId, error) {
+	var updated Sell
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous Sell
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		portfolioId, err := resolvePortfolioID(tx, sell.PortfolioId, userEmail)
+		if err != nil {
+			return err
+		}
+		sell.PortfolioId = portfolioId
+
+		err = tx.Model(&Sell{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"units":            sell.Units,
+			"ticker":           sell.Ticker,
+			"amount":           sell.Amount,
+			"fees":             sell.Fees,
+			"currency":         sell.Currency,
+			"use_cash_account": sell.UseCashAccount,
+			"portfolio_id":     sell.PortfolioId,
+			"lots":             SellLots(sell.Lots),
+			"date":             time.Time(sell.Date),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// The edited sell is recomputed as well, together with all the sells after it
+		from := time.Time(sell.Date)
+		if previous.Ticker != sell.Ticker {
+			if err := recomputeSellsCostBasis(tx, userEmail, previous.Ticker, previous.Date); err != nil {
+				return err
+			}
+		} else if previous.Date.Before(from) {
+			from = previous.Date
+		}
+		if err := recomputeSellsCostBasis(tx, userEmail, sell.Ticker, from); err != nil {
+			return err
+		}
+
+		return tx.Where("id = ?", id).First(&updated).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		r.l.Error("Failed to update sell", "error", err.Error())
+		return nil, err
+	}
+
+	nt, err := r.tr.FindByTicker(sell.Ticker, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sell.AcquisitionValue = updated.AcquisitionValue
+	sell.AccumulatedFees = updated.AccumulatedFees
+	return &domain.SellWithId{
+		Id: id,
+		TickerData: &domain.SimplifiedTicker{
+			Ticker:  nt.Ticker,
+			Website: nt.Website,
+			Name:    nt.Name,
+		},
+		Sell: sell,
+	}, nil
+}
+
 func (r *SellsRepository) Delete(id string, userEmail string) error {
-	if err := r.db.Where("id = ? AND user_email = ?", id, userEmail).Delete(&Sell{}).Error; err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var previous Sell
+		if err := tx.Where("id = ? AND user_email = ?", id, userEmail).First(&previous).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&previous).Error; err != nil {
+			return err
+		}
+
+		// The units the deleted sell consumed are available again for the sells after it
+		return recomputeSellsCostBasis(tx, userEmail, previous.Ticker, previous.Date)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		r.l.Error("Failed to delete sell", "error", err.Error())
+	}
+	return err
+}
+
+// recomputeSellsCostBasis recomputes the acquisition value and accumulated fees frozen on
+// every sell dated on or after from of the ticker and of the tickers its lots end up in,
+// so they reflect the current buys, corporate actions and cost basis method of the user.
+// Sells up to 30 days before from are recomputed too, they may match later buys.
+func recomputeSellsCostBasis(tx *gorm.DB, userEmail, ticker string, from time.Time) error {
+	from = from.Add(-sells.BedAndBreakfastWindow)
+
+	actions, err := findCorporateActions(tx, userEmail)
+	if err != nil {
+		return err
+	}
+
+	method, err := findCostBasisMethod(tx, userEmail)
+	if err != nil {
+		return err
+	}
+
+	for _, derived := range actions.DerivedTickers(ticker) {
+		if err := recomputeTickerSellsCostBasis(tx, userEmail, derived, from, actions, method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recomputeTickerSellsCostBasis(tx *gorm.DB, userEmail, ticker string, from time.Time, actions domain.CorporateActions, method sells.CostBasisMethod) error {
+	// Buys are converted to the currency of each sell, same as when the sell was created
+	lotsIn := func(tickers []string, currency string) (rawLots, error) {
+		return findRawLots(tx, userEmail, tickers, currency, nil)
+	}
+	return computeTickerSellsCost(tx, userEmail, ticker, from, actions, method, lotsIn, func(dbSell Sell, cost sells.SellCostOutput) error {
+		return tx.Model(&Sell{}).Where("id = ?", dbSell.ID).Updates(map[string]interface{}{
+			"acquisition_value": cost.MeanAcquisitionValue,
+			"accumulated_fees":  cost.AccumulatedFees,
+		}).Error
+	})
+}
+
+// computeTickerSellsCost matches every sell of the ticker dated on or after from with the
+// lots it comes from, following the cost basis method, and calls fn with each of them in
+// chronological order. lotsIn returns the lots of the tickers with the buys in the currency
+// the cost of the sells in the given currency is computed in.
+func computeTickerSellsCost(tx *gorm.DB, userEmail, ticker string, from time.Time, actions domain.CorporateActions, method sells.CostBasisMethod, lotsIn func(tickers []string, currency string) (rawLots, error), fn func(dbSell Sell, cost sells.SellCostOutput) error) error {
+	dbSells := []Sell{}
+	if err := tx.Where("user_email = ? AND ticker = ?", userEmail, ticker).Order("date asc, created_at asc").Find(&dbSells).Error; err != nil {
 		return err
 	}
+
+	lotsByCurrency := map[string]rawLots{}
+	for i, dbSell := range dbSells {
+		if dbSell.Date.Before(from) {
+			continue
+		}
+
+		lots, present := lotsByCurrency[dbSell.Currency]
+		if !present {
+			var err error
+			lots, err = lotsIn(actions.SourceTickers(ticker), dbSell.Currency)
+			if err != nil {
+				return err
+			}
+			lotsByCurrency[dbSell.Currency] = lots
+		}
+
+		// This sell and the ones after it, even on the same date, are not consumed yet. Buys
+		// after the sell are kept, the cost basis method decides whether they are matched.
+		pending := map[string]bool{}
+		for _, s := range dbSells[i:] {
+			pending[s.ID] = true
+		}
+		previousSells := arrayutils.Filter(lots.sells, func(s domain.SellWithId) bool {
+			return !pending[s.Id] && !time.Time(s.Sell.Date).After(dbSell.Date)
+		})
+
+		// Units are expressed as of the sell date, so corporate actions in between are taken into account
+		buys, previousSells := sells.ApplyCorporateActions(lots.buys, previousSells, actions, dbSell.Date)
+		buys, previousSells = sells.FilterByTicker(buys, previousSells, ticker)
+		cost, err := sells.ComputeSellCost(method, buys, previousSells, dbSellToDomain(dbSell).Sell)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(dbSell, cost); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// findCostBasisMethod returns the cost basis method chosen by the user
+func findCostBasisMethod(db *gorm.DB, userEmail string) (sells.CostBasisMethod, error) {
+	var user User
+	if err := db.Where("email = ?", userEmail).First(&user).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return sells.NewCostBasisMethod(user.CostBasisMethod)
+}
+
+func dbSellToDomain(dbSell Sell) domain.SellWithId {
+	return domain.SellWithId{
+		Id: dbSell.ID,
+		Sell: domain.Sell{
+			Units:            dbSell.Units,
+			Ticker:           dbSell.Ticker,
+			Amount:           dbSell.Amount,
+			AccumulatedFees:  dbSell.AccumulatedFees,
+			AcquisitionValue: dbSell.AcquisitionValue,
+			Currency:         dbSell.Currency,
+			Fees:             dbSell.Fees,
+			UseCashAccount:   dbSell.UseCashAccount,
+			Date:             domain.Date(dbSell.Date),
+
+			CorporateActionId: dbSell.CorporateActionID,
+			PortfolioId:       dbSell.PortfolioID,
+			Lots:              dbSell.Lots,
+		},
+	}
+}
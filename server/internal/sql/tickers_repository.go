@@ -1,10 +1,10 @@
 package sql
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,62 +22,40 @@ func NewTickersRepository(db *gorm.DB, logger *slog.Logger) *TickersRepository {
 	return &TickersRepository{db: db, l: logger}
 }
 
+// Create stores a snapshot of the ticker. Its price history goes to the daily prices,
+// keeping the full bars already stored on the same dates.
 func (r *TickersRepository) Create(ticker domain.Ticker) error {
-	b := &bytes.Buffer{}
-	err := json.NewEncoder(b).Encode(ticker.HistoricalData)
-	if err != nil {
-		return err
-	}
-
 	dateFmt := "2006-01-02 15:04"
 	dateKey, _ := time.Parse(dateFmt, time.Now().Format(dateFmt))
-	dbTicker := Ticker{
-		Ticker:               ticker.Ticker,
-		DateKey:              dateKey,
-		Name:                 ticker.Name,
-		ChangeRate:           ticker.ChangeRate,
-		Price:                ticker.Price,
-		YearlyDividendValue:  ticker.YearlyDividendValue,
-		YearlyDividendYield:  ticker.YearlyDividendYield,
-		NextDividendValue:    ticker.NextDividendValue,
-		NextDividendYield:    ticker.NextDividendYield,
-		Website:              ticker.Website,
-		Sector:               ticker.Sector,
-		Country:              ticker.Country,
-		Industry:             ticker.Industry,
-		IsEtf:                ticker.IsEtf,
-		MonthlyPriceRangeMin: ticker.MonthlyPriceRange.Min,
-		MonthlyPriceRangeMax: ticker.MonthlyPriceRange.Max,
-		YearlyPriceRangeMin:  ticker.YearlyPriceRange.Min,
-		YearlyPriceRangeMax:  ticker.YearlyPriceRange.Max,
-		HistoricalData:       b.String(),
-		Currency:             ticker.Currency,
-	}
+	dbTicker := domainTickerToDB(ticker, dateKey)
 
-	if ticker.ExDividendDate != nil {
-		edd := time.Time(*ticker.ExDividendDate)
-		dbTicker.ExDividendDate = &edd
-	}
-	if ticker.DividendPaymentDate != nil {
-		dpd := time.Time(*ticker.DividendPaymentDate)
-		dbTicker.DividendPaymentDate = &dpd
-	}
-	earningDates := []time.Time{}
-	for _, ed := range ticker.EarningDates {
-		t := time.Time(ed)
-		earningDates = append(earningDates, t)
-	}
-	dbTicker.EarningDates = earningDates
-
-	if err := r.db.Clauses(clause.OnConflict{
-		UpdateAll: true,
-	}).Create(&dbTicker).Error; err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&dbTicker).Error; err != nil {
+			return err
+		}
+		return savePrices(tx, clause.OnConflict{DoNothing: true}, ticker.Ticker, ticker.Currency, ticker.HistoricalData)
+	})
+	if err != nil {
 		r.l.Error("Failed to create ticker", "error", err.Error())
 		return err
 	}
 	return nil
 }
 
+// createLatestTickersViewQuery creates the view of the newest snapshot of every ticker. The
+// newest date key of a ticker is a seek on the primary key, so filtering the view by ticker
+// does not go through the older snapshots.
+const createLatestTickersViewQuery = `
+CREATE VIEW IF NOT EXISTS LATEST_TICKERS AS
+SELECT TICKERS.*
+FROM TICKERS
+WHERE TICKERS.DATE_KEY = (
+	SELECT MAX(SNAPSHOTS.DATE_KEY)
+	FROM TICKERS AS SNAPSHOTS
+	WHERE SNAPSHOTS.TICKER = TICKERS.TICKER
+);
+`
+
 const findTickersQuery = `
 WITH _RATES AS (
 	SELECT
@@ -85,40 +63,37 @@ WITH _RATES AS (
 		RATE
 	FROM EXCHANGE_RATES
 	WHERE TARGET_CURRENCY = ?
-),
-_TICKERS_W_RN AS (
-	SELECT
-		TICKERS.*,
-		ROW_NUMBER() OVER (PARTITION BY TICKER ORDER BY DATE_KEY DESC) AS RN
-	FROM TICKERS
 )
 SELECT
-	_TICKERS_W_RN.TICKER AS ticker,
-	_TICKERS_W_RN.DATE_KEY AS date_key,
-	_TICKERS_W_RN.PRICE * _RATES.RATE AS price,
-	_TICKERS_W_RN.NAME AS name,
-	_TICKERS_W_RN.CHANGE_RATE AS change_rate,
-	_TICKERS_W_RN.YEARLY_DIVIDEND_YIELD AS yearly_dividend_yield,
-	_TICKERS_W_RN.NEXT_DIVIDEND_YIELD AS next_dividend_yield,
-	_TICKERS_W_RN.NEXT_DIVIDEND_VALUE * _RATES.RATE AS next_dividend_value,
-	_TICKERS_W_RN.YEARLY_DIVIDEND_VALUE * _RATES.RATE AS yearly_dividend_value,
-	_TICKERS_W_RN.WEBSITE AS website,
+	LATEST_TICKERS.TICKER AS ticker,
+	LATEST_TICKERS.DATE_KEY AS date_key,
+	LATEST_TICKERS.PRICE * _RATES.RATE AS price,
+	LATEST_TICKERS.NAME AS name,
+	LATEST_TICKERS.CHANGE_RATE AS change_rate,
+	LATEST_TICKERS.YEARLY_DIVIDEND_YIELD AS yearly_dividend_yield,
+	LATEST_TICKERS.NEXT_DIVIDEND_YIELD AS next_dividend_yield,
+	LATEST_TICKERS.NEXT_DIVIDEND_VALUE * _RATES.RATE AS next_dividend_value,
+	LATEST_TICKERS.YEARLY_DIVIDEND_VALUE * _RATES.RATE AS yearly_dividend_value,
+	LATEST_TICKERS.WEBSITE AS website,
 	? AS currency,
-	_TICKERS_W_RN.EX_DIVIDEND_DATE AS ex_dividend_date,
-	_TICKERS_W_RN.DIVIDEND_PAYMENT_DATE AS dividend_payment_date,
-	_TICKERS_W_RN.EARNING_DATES AS earning_dates,
-	_TICKERS_W_RN.SECTOR AS sector,
-	_TICKERS_W_RN.COUNTRY AS country,
-	_TICKERS_W_RN.INDUSTRY AS industry,
-	_TICKERS_W_RN.IS_ETF AS is_ETF,
-	_TICKERS_W_RN.MONTHLY_PRICE_RANGE_MIN * _RATES.RATE AS monthly_price_range_min,
-	_TICKERS_W_RN.MONTHLY_PRICE_RANGE_MAX * _RATES.RATE AS monthly_price_range_max,
-	_TICKERS_W_RN.YEARLY_PRICE_RANGE_MIN * _RATES.RATE AS yearly_price_range_min,
-	_TICKERS_W_RN.YEARLY_PRICE_RANGE_MAX * _RATES.RATE AS yearly_price_range_max,
-	_TICKERS_W_RN.HISTORICAL_DATA AS historical_data
-FROM _TICKERS_W_RN
-LEFT JOIN _RATES ON _RATES.SOURCE_CURRENCY = _TICKERS_W_RN.CURRENCY
-WHERE _TICKERS_W_RN.TICKER IN ? AND _TICKERS_W_RN.RN = 1;
+	LATEST_TICKERS.EX_DIVIDEND_DATE AS ex_dividend_date,
+	LATEST_TICKERS.DIVIDEND_PAYMENT_DATE AS dividend_payment_date,
+	LATEST_TICKERS.EARNING_DATES AS earning_dates,
+	LATEST_TICKERS.SECTOR AS sector,
+	LATEST_TICKERS.COUNTRY AS country,
+	LATEST_TICKERS.INDUSTRY AS industry,
+	LATEST_TICKERS.IS_ETF AS is_etf,
+	LATEST_TICKERS.MONTHLY_PRICE_RANGE_MIN * _RATES.RATE AS monthly_price_range_min,
+	LATEST_TICKERS.MONTHLY_PRICE_RANGE_MAX * _RATES.RATE AS monthly_price_range_max,
+	LATEST_TICKERS.YEARLY_PRICE_RANGE_MIN * _RATES.RATE AS yearly_price_range_min,
+	LATEST_TICKERS.YEARLY_PRICE_RANGE_MAX * _RATES.RATE AS yearly_price_range_max,
+	LATEST_TICKERS.HISTORICAL_DATA AS historical_data,
+	LATEST_TICKERS.SOURCE AS source,
+	LATEST_TICKERS.ISIN AS isin,
+	LATEST_TICKERS.EXCHANGE AS exchange
+FROM LATEST_TICKERS
+LEFT JOIN _RATES ON _RATES.SOURCE_CURRENCY = LATEST_TICKERS.CURRENCY
+WHERE LATEST_TICKERS.TICKER IN ?;
 `
 
 func (r *TickersRepository) FindByTicker(ticker string, preferredCurrency *string) (domain.Ticker, error) {
@@ -130,9 +105,11 @@ func (r *TickersRepository) FindByTicker(ticker string, preferredCurrency *strin
 		return domain.Ticker{}, fmt.Errorf("ticker %s not found", ticker)
 	}
 
-	dbTicker := dbTickers[0]
-
-	return dbTickerToDomain(dbTicker)
+	tickers, err := withMonthlyHistory(r.db, dbTickers, preferredCurrency)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+	return tickers[ticker], nil
 }
 
 func (r *TickersRepository) FindMultipleTickers(tickers []string, preferredCurrency *string) (map[string]domain.Ticker, error) {
@@ -140,6 +117,143 @@ func (r *TickersRepository) FindMultipleTickers(tickers []string, preferredCurre
 	if err := r.db.Raw(findTickersQuery, preferredCurrency, preferredCurrency, tickers).Scan(&dbTickers).Error; err != nil {
 		return nil, err
 	}
+	return withMonthlyHistory(r.db, dbTickers, preferredCurrency)
+}
+
+// searchTickersQuery matches the cached tickers whose symbol starts with the query, whose
+// name contains it or whose ISIN is the query, the exact symbol first
+const searchTickersQuery = `
+SELECT
+	LATEST_TICKERS.TICKER AS ticker,
+	LATEST_TICKERS.NAME AS name,
+	LATEST_TICKERS.ISIN AS isin,
+	LATEST_TICKERS.EXCHANGE AS exchange,
+	LATEST_TICKERS.CURRENCY AS currency,
+	LATEST_TICKERS.IS_ETF AS is_etf
+FROM LATEST_TICKERS
+WHERE UPPER(LATEST_TICKERS.TICKER) LIKE @prefix ESCAPE '\'
+	OR UPPER(LATEST_TICKERS.NAME) LIKE @contains ESCAPE '\'
+	OR UPPER(LATEST_TICKERS.ISIN) = @query
+ORDER BY
+	CASE
+		WHEN UPPER(LATEST_TICKERS.TICKER) = @query THEN 0
+		WHEN UPPER(LATEST_TICKERS.TICKER) LIKE @prefix ESCAPE '\' THEN 1
+		ELSE 2
+	END,
+	LATEST_TICKERS.TICKER
+LIMIT @limit;
+`
+
+func (r *TickersRepository) Search(query string, limit int) (domain.TickerSearchResults, error) {
+	var dbTickers []Ticker
+	query = strings.ToUpper(strings.TrimSpace(query))
+	pattern := escapeLike(query)
+	if err := r.db.Raw(searchTickersQuery, map[string]interface{}{
+		"query":    query,
+		"prefix":   pattern + "%",
+		"contains": "%" + pattern + "%",
+		"limit":    limit,
+	}).Scan(&dbTickers).Error; err != nil {
+		r.l.Error("Failed to search tickers", "error", err.Error())
+		return nil, err
+	}
+
+	results := make(domain.TickerSearchResults, 0, len(dbTickers))
+	for _, t := range dbTickers {
+		results = append(results, domain.TickerSearchResult{
+			Ticker:   t.Ticker,
+			Name:     t.Name,
+			Isin:     t.Isin,
+			Exchange: t.Exchange,
+			Currency: t.Currency,
+			Type:     assetType(t.IsEtf),
+			Cached:   true,
+		})
+	}
+	return results, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, to be used with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func assetType(isEtf bool) string {
+	if isEtf {
+		return domain.EtfAsset
+	}
+	return domain.StockAsset
+}
+
+// FindLastUpdate returns when the newest snapshot of the ticker was fetched, nil when the
+// ticker is not cached
+func (r *TickersRepository) FindLastUpdate(ticker string) (*time.Time, error) {
+	var dbTickers []Ticker
+	if err := r.db.Select("ticker", "date_key").Where("ticker = ?", ticker).Order("date_key desc").Limit(1).Find(&dbTickers).Error; err != nil {
+		return nil, err
+	}
+	if len(dbTickers) == 0 {
+		return nil, nil
+	}
+
+	// The date keys store the local time as if it were UTC, see Create
+	dk := dbTickers[0].DateKey
+	lastUpdate := time.Date(dk.Year(), dk.Month(), dk.Day(), dk.Hour(), dk.Minute(), 0, 0, time.Local)
+	return &lastUpdate, nil
+}
+
+// findLatestTickers returns the last data stored of the tickers as it was fetched, in the
+// currency of the ticker
+func findLatestTickers(db *gorm.DB, tickers []string) (map[string]domain.Ticker, error) {
+	var dbTickers []Ticker
+	err := db.Raw("SELECT * FROM LATEST_TICKERS WHERE TICKER IN ?", tickers).Scan(&dbTickers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return withMonthlyHistory(db, dbTickers, nil)
+}
+
+// withMonthlyHistory maps the tickers with the first daily price of each month since a year
+// ago as their history, same as the providers return it, converted to the currency at the
+// current exchange rate or in the currency of each ticker when it is nil. Snapshots stored
+// before the daily prices existed keep the history they were stored with.
+func withMonthlyHistory(db *gorm.DB, dbTickers []Ticker, currency *string) (map[string]domain.Ticker, error) {
+	tickers := make([]string, len(dbTickers))
+	for i, dbTicker := range dbTickers {
+		tickers[i] = dbTicker.Ticker
+	}
+
+	var rates map[string]float32
+	if currency != nil {
+		var err error
+		if rates, err = findRatesTo(db, *currency); err != nil {
+			return nil, err
+		}
+	}
+
+	lastYear := time.Now().AddDate(-1, 0, 0)
+	since := time.Date(lastYear.Year(), lastYear.Month(), 1, 0, 0, 0, 0, lastYear.Location())
+	histories := map[string][]domain.HistoricalEntry{}
+	for i := 0; i < len(tickers); i += 500 {
+		var prices []TickerPrice
+		err := db.Where("ticker IN ? AND date >= ?", tickers[i:min(i+500, len(tickers))], since).Order("ticker, date asc").Find(&prices).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range prices {
+			rate := float32(1)
+			if rates != nil {
+				rate = rates[p.Currency]
+			}
+			history := histories[p.Ticker]
+			if len(history) > 0 && time.Time(history[len(history)-1].Date).Month() == p.Date.Month() {
+				continue
+			}
+			histories[p.Ticker] = append(history, domain.HistoricalEntry{Date: domain.Date(p.Date), Price: p.Close * rate})
+		}
+	}
 
 	tickersMap := make(map[string]domain.Ticker, len(dbTickers))
 	for _, dbTicker := range dbTickers {
@@ -147,23 +261,21 @@ func (r *TickersRepository) FindMultipleTickers(tickers []string, preferredCurre
 		if err != nil {
 			return nil, err
 		}
+		if history, present := histories[ticker.Ticker]; present {
+			ticker.HistoricalData = history
+		}
 		tickersMap[ticker.Ticker] = ticker
 	}
 	return tickersMap, nil
 }
 
-func (r *TickersRepository) Exists(ticker string) (bool, error) {
-	var count int64
-	if err := r.db.Model(&Ticker{}).Where("ticker = ?", ticker).Count(&count).Error; err != nil {
-		return false, err
-	}
-	return count > 0, nil
-}
-
 func dbTickerToDomain(dbTicker Ticker) (domain.Ticker, error) {
-	var historicalData []domain.HistoricalEntry
-	if err := json.NewDecoder(strings.NewReader(dbTicker.HistoricalData)).Decode(&historicalData); err != nil {
-		return domain.Ticker{}, err
+	// The history is only stored in the snapshots taken before the daily prices existed
+	historicalData := []domain.HistoricalEntry{}
+	if dbTicker.HistoricalData != "" {
+		if err := json.NewDecoder(strings.NewReader(dbTicker.HistoricalData)).Decode(&historicalData); err != nil {
+			return domain.Ticker{}, err
+		}
 	}
 
 	var exDividendDate *domain.Date
@@ -187,6 +299,7 @@ func dbTickerToDomain(dbTicker Ticker) (domain.Ticker, error) {
 		Ticker:              dbTicker.Ticker,
 		Name:                dbTicker.Name,
 		Price:               dbTicker.Price,
+		Currency:            dbTicker.Currency,
 		ChangeRate:          dbTicker.ChangeRate,
 		YearlyDividendValue: dbTicker.YearlyDividendValue,
 		YearlyDividendYield: dbTicker.YearlyDividendYield,
@@ -203,5 +316,331 @@ func dbTickerToDomain(dbTicker Ticker) (domain.Ticker, error) {
 		ExDividendDate:      exDividendDate,
 		DividendPaymentDate: dividendPaymentDate,
 		EarningDates:        earningDates,
+		Source:              dbTicker.Source,
+		Isin:                dbTicker.Isin,
+		Exchange:            dbTicker.Exchange,
 	}, nil
 }
+
+// domainTickerToDB maps the ticker to a snapshot. The price history is not part of it, it
+// is stored in the daily prices.
+func domainTickerToDB(ticker domain.Ticker, dateKey time.Time) Ticker {
+	dbTicker := Ticker{
+		Ticker:               ticker.Ticker,
+		DateKey:              dateKey,
+		Name:                 ticker.Name,
+		ChangeRate:           ticker.ChangeRate,
+		Price:                ticker.Price,
+		YearlyDividendValue:  ticker.YearlyDividendValue,
+		YearlyDividendYield:  ticker.YearlyDividendYield,
+		NextDividendValue:    ticker.NextDividendValue,
+		NextDividendYield:    ticker.NextDividendYield,
+		Website:              ticker.Website,
+		Sector:               ticker.Sector,
+		Country:              ticker.Country,
+		Industry:             ticker.Industry,
+		IsEtf:                ticker.IsEtf,
+		MonthlyPriceRangeMin: ticker.MonthlyPriceRange.Min,
+		MonthlyPriceRangeMax: ticker.MonthlyPriceRange.Max,
+		YearlyPriceRangeMin:  ticker.YearlyPriceRange.Min,
+		YearlyPriceRangeMax:  ticker.YearlyPriceRange.Max,
+		Currency:             ticker.Currency,
+		Source:               ticker.Source,
+		Isin:                 ticker.Isin,
+		Exchange:             ticker.Exchange,
+	}
+
+	if ticker.ExDividendDate != nil {
+		edd := time.Time(*ticker.ExDividendDate)
+		dbTicker.ExDividendDate = &edd
+	}
+	if ticker.DividendPaymentDate != nil {
+		dpd := time.Time(*ticker.DividendPaymentDate)
+		dbTicker.DividendPaymentDate = &dpd
+	}
+	earningDates := []time.Time{}
+	for _, ed := range ticker.EarningDates {
+		t := time.Time(ed)
+		earningDates = append(earningDates, t)
+	}
+	dbTicker.EarningDates = earningDates
+
+	return dbTicker
+}
+
+// findPriceHistory returns the daily closes stored for the ticker, in date order and in the
+// currency of the ticker. Returns no prices when there are none.
+func findPriceHistory(db *gorm.DB, ticker string) (string, []domain.HistoricalEntry, error) {
+	var dailyPrices []TickerPrice
+	if err := db.Where("ticker = ?", ticker).Order("date asc").Find(&dailyPrices).Error; err != nil {
+		return "", nil, err
+	}
+
+	currency := ""
+	history := make([]domain.HistoricalEntry, 0, len(dailyPrices))
+	for _, p := range dailyPrices {
+		// Prices of a ticker that changed its currency are not comparable, the latest win
+		if p.Currency != currency {
+			currency = p.Currency
+			history = history[:0]
+		}
+		history = append(history, domain.HistoricalEntry{Date: domain.Date(p.Date), Price: p.Close})
+	}
+	return currency, history, nil
+}
+
+// FindPriceHistory returns the ticker with the daily closes stored since start, in the
+// currency of the ticker
+func (r *TickersRepository) FindPriceHistory(ticker string, start domain.Date) (domain.Ticker, error) {
+	currency, history, err := findPriceHistory(r.db, ticker)
+	if err != nil {
+		return domain.Ticker{}, err
+	}
+
+	i := sort.Search(len(history), func(i int) bool {
+		return !time.Time(history[i].Date).Before(time.Time(start))
+	})
+	return domain.Ticker{Ticker: ticker, Currency: currency, HistoricalData: history[i:]}, nil
+}
+
+// FindLastPriceDate returns the date of the last daily price stored for the ticker, nil
+// when there is none
+func (r *TickersRepository) FindLastPriceDate(ticker string) (*domain.Date, error) {
+	var prices []TickerPrice
+	if err := r.db.Where("ticker = ?", ticker).Order("date desc").Limit(1).Find(&prices).Error; err != nil {
+		return nil, err
+	}
+	if len(prices) == 0 {
+		return nil, nil
+	}
+
+	last := domain.Date(prices[0].Date)
+	return &last, nil
+}
+
+// SavePrices stores the daily prices of the ticker, replacing the ones of the same dates.
+// The bars missing from prices with only a close are flat at it.
+func (r *TickersRepository) SavePrices(ticker string, currency string, prices []domain.HistoricalEntry) error {
+	if err := savePrices(r.db, clause.OnConflict{UpdateAll: true}, ticker, currency, prices); err != nil {
+		r.l.Error("Failed to save ticker prices", "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+// savePrices stores the daily prices of the ticker, onConflict deciding what happens to
+// the ones already stored on the same dates
+func savePrices(db *gorm.DB, onConflict clause.OnConflict, ticker string, currency string, prices []domain.HistoricalEntry) error {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	dbPrices := make([]TickerPrice, 0, len(prices))
+	for _, p := range prices {
+		price := TickerPrice{
+			Ticker:   ticker,
+			Date:     time.Time(p.Date),
+			Open:     p.Open,
+			High:     p.High,
+			Low:      p.Low,
+			Close:    p.Price,
+			AdjClose: p.AdjClose,
+			Volume:   p.Volume,
+			Currency: currency,
+		}
+		if price.Open == 0 {
+			price.Open = price.Close
+		}
+		if price.High == 0 {
+			price.High = max(price.Open, price.Close)
+		}
+		if price.Low == 0 {
+			price.Low = min(price.Open, price.Close)
+		}
+		if price.AdjClose == 0 {
+			price.AdjClose = price.Close
+		}
+		dbPrices = append(dbPrices, price)
+	}
+
+	return db.Clauses(onConflict).CreateInBatches(dbPrices, 500).Error
+}
+
+// FindPrices returns the prices of the ticker between from and to, both included, grouped
+// by the interval. Each day is converted to the currency at the exchange rate of the day,
+// the prices are in the currency of the ticker when it is nil.
+func (r *TickersRepository) FindPrices(ticker string, from domain.Date, to domain.Date, interval string, currency *string) (domain.PriceHistory, error) {
+	var dbPrices []TickerPrice
+	err := r.db.Where("ticker = ? AND date >= ? AND date <= ?", ticker, time.Time(from), time.Time(to)).
+		Order("date asc").
+		Find(&dbPrices).Error
+	if err != nil {
+		return domain.PriceHistory{}, err
+	}
+
+	history := domain.PriceHistory{
+		Ticker:   ticker,
+		Interval: interval,
+		Prices:   []domain.PriceBar{},
+	}
+	if len(dbPrices) == 0 {
+		if currency != nil {
+			history.Currency = *currency
+		}
+		return history, nil
+	}
+
+	history.Currency = dbPrices[0].Currency
+	var rates rateHistory
+	if currency != nil && *currency != history.Currency {
+		if rates, err = findRateHistoryTo(r.db, *currency); err != nil {
+			return domain.PriceHistory{}, err
+		}
+	}
+
+	bars := make([]domain.PriceBar, 0, len(dbPrices))
+	for _, p := range dbPrices {
+		rate := float32(1)
+		if currency != nil && *currency != p.Currency {
+			var present bool
+			if rate, present = rates.at(p.Currency, p.Date); !present {
+				return domain.PriceHistory{}, fmt.Errorf("no exchange rate from %s to %s", p.Currency, *currency)
+			}
+		}
+		bars = append(bars, domain.PriceBar{
+			Date:     domain.Date(p.Date),
+			Open:     p.Open * rate,
+			High:     p.High * rate,
+			Low:      p.Low * rate,
+			Close:    p.Close * rate,
+			AdjClose: p.AdjClose * rate,
+			Volume:   p.Volume,
+		})
+	}
+	if currency != nil {
+		history.Currency = *currency
+	}
+
+	history.Prices = resamplePrices(bars, interval)
+	return history, nil
+}
+
+// resamplePrices groups the daily bars, in date order, by week or by month. Each group
+// opens with its first bar, closes with its last one and is dated its first day of trading.
+func resamplePrices(bars []domain.PriceBar, interval string) []domain.PriceBar {
+	if interval == domain.PriceIntervalDay {
+		return bars
+	}
+
+	period := func(bar domain.PriceBar) string {
+		date := time.Time(bar.Date)
+		if interval == domain.PriceIntervalWeek {
+			year, week := date.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}
+		return date.Format("2006-01")
+	}
+
+	resampled := []domain.PriceBar{}
+	lastPeriod := ""
+	for _, bar := range bars {
+		if p := period(bar); p != lastPeriod {
+			lastPeriod = p
+			resampled = append(resampled, bar)
+			continue
+		}
+
+		current := &resampled[len(resampled)-1]
+		current.High = max(current.High, bar.High)
+		current.Low = min(current.Low, bar.Low)
+		current.Close = bar.Close
+		current.AdjClose = bar.AdjClose
+		current.Volume += bar.Volume
+	}
+	return resampled
+}
+
+// snapshotSizeQuery returns the snapshots of a ticker, newest first, with the size of their
+// data, taking 8 bytes for each numeric or date column
+const snapshotSizeQuery = `
+SELECT
+	DATE_KEY AS date_key,
+	LENGTH(TICKER) + LENGTH(NAME) + LENGTH(WEBSITE) + LENGTH(CURRENCY) + LENGTH(SECTOR) +
+	LENGTH(COUNTRY) + LENGTH(INDUSTRY) + COALESCE(LENGTH(EARNING_DATES), 0) +
+	COALESCE(LENGTH(HISTORICAL_DATA), 0) + COALESCE(LENGTH(SOURCE), 0) + 8 * 16 AS size
+FROM TICKERS
+WHERE TICKER = ?
+ORDER BY DATE_KEY DESC
+`
+
+// Compact deletes the snapshots of every ticker the retention no longer keeps, the newest
+// one of each ticker is always kept. With dryRun nothing is deleted and the report is what
+// would be.
+func (r *TickersRepository) Compact(retention domain.SnapshotRetention, now time.Time, dryRun bool) (domain.CompactionReport, error) {
+	report := domain.CompactionReport{}
+
+	var tickers []string
+	if err := r.db.Model(&Ticker{}).Distinct().Pluck("ticker", &tickers).Error; err != nil {
+		return report, err
+	}
+
+	// The date keys store the local time as if it were UTC, see Create
+	now = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
+
+	for _, ticker := range tickers {
+		var snapshots []struct {
+			DateKey time.Time
+			Size    int64
+		}
+		if err := r.db.Raw(snapshotSizeQuery, ticker).Scan(&snapshots).Error; err != nil {
+			return report, err
+		}
+
+		expired := []time.Time{}
+		var reclaimed int64
+		kept := map[string]bool{}
+		for i, snapshot := range snapshots {
+			age := now.Sub(snapshot.DateKey)
+			if age <= retention.KeepAll {
+				continue
+			}
+
+			bucket := snapshot.DateKey.Format("2006-01")
+			if age <= retention.KeepDaily {
+				bucket = snapshot.DateKey.Format(time.DateOnly)
+			}
+			if i == 0 || !kept[bucket] {
+				kept[bucket] = true
+				continue
+			}
+			expired = append(expired, snapshot.DateKey)
+			reclaimed += snapshot.Size
+		}
+
+		if len(expired) == 0 {
+			continue
+		}
+
+		if !dryRun {
+			err := r.db.Transaction(func(tx *gorm.DB) error {
+				for i := 0; i < len(expired); i += 500 {
+					chunk := expired[i:min(i+500, len(expired))]
+					if err := tx.Where("ticker = ? AND date_key IN ?", ticker, chunk).Delete(&Ticker{}).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				r.l.Error("Failed to delete ticker snapshots", "ticker", ticker, "error", err.Error())
+				return report, err
+			}
+		}
+
+		r.l.Debug("Compacted ticker snapshots", "ticker", ticker, "deleted", len(expired), "kept", len(snapshots)-len(expired))
+		report.Tickers++
+		report.RowsDeleted += int64(len(expired))
+		report.BytesReclaimed += reclaimed
+	}
+	return report, nil
+}
@@ -3,8 +3,11 @@ package sql
 import (
 	"errors"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -33,7 +36,15 @@ func (r *UsersRepository) Create(user domain.User) (*domain.UserWithId, error) {
 		PreferredCurrency: preferredCurrency,
 	}
 
-	if err := r.db.Create(&dbUser).Error; err != nil {
+	// Every user has a default portfolio, receiving the movements with no portfolio
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dbUser).Error; err != nil {
+			return err
+		}
+		_, err := createDefaultPortfolio(tx, user.Email, preferredCurrency)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &domain.UserWithId{
@@ -56,6 +67,8 @@ func (r *UsersRepository) FindByEmail(email string) (*domain.UserWithId, error)
 			Email:             dbUser.Email,
 			Picture:           dbUser.Picture,
 			PreferredCurrency: &dbUser.PreferredCurrency,
+			CostBasisMethod:   dbUser.CostBasisMethod,
+			Benchmarks:        splitBenchmarks(dbUser.Benchmarks),
 		},
 	}, nil
 }
@@ -74,10 +87,73 @@ func (r *UsersRepository) FindByID(id string) (*domain.UserWithId, error) {
 			Email:             dbUser.Email,
 			Picture:           dbUser.Picture,
 			PreferredCurrency: &dbUser.PreferredCurrency,
+			CostBasisMethod:   dbUser.CostBasisMethod,
+			Benchmarks:        splitBenchmarks(dbUser.Benchmarks),
 		},
 	}, nil
 }
 
-func (r *UsersRepository) UpdatePreferences(id string, preferredCurrency string) error {
-	return r.db.Model(&User{}).Where("id = ?", id).Update("preferred_currency", preferredCurrency).Error
+// UpdatePreferences updates the preferences given, empty ones are left unchanged. A nil
+// list of benchmarks keeps them and an empty one clears them. Changing the cost basis
+// method recomputes the acquisition value of every sell of the user.
+func (r *UsersRepository) UpdatePreferences(id string, preferredCurrency string, costBasisMethod string, benchmarks []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var dbUser User
+		if err := tx.Where("id = ?", id).First(&dbUser).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if preferredCurrency != "" {
+			updates["preferred_currency"] = preferredCurrency
+		}
+		if costBasisMethod != "" {
+			updates["cost_basis_method"] = costBasisMethod
+		}
+		if benchmarks != nil {
+			updates["benchmarks"] = strings.Join(utils.ArrayUnique(benchmarks), ",")
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if costBasisMethod == "" || costBasisMethod == dbUser.CostBasisMethod {
+			return nil
+		}
+
+		var tickers []string
+		if err := tx.Model(&Sell{}).Where("user_email = ?", dbUser.Email).Distinct().Pluck("ticker", &tickers).Error; err != nil {
+			return err
+		}
+		for _, ticker := range tickers {
+			if err := recomputeSellsCostBasis(tx, dbUser.Email, ticker, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindAllBenchmarks returns the benchmarks chosen by any user
+func (r *UsersRepository) FindAllBenchmarks() ([]string, error) {
+	var values []string
+	if err := r.db.Model(&User{}).Where("COALESCE(benchmarks, '') <> ''").Pluck("benchmarks", &values).Error; err != nil {
+		return nil, err
+	}
+
+	benchmarks := []string{}
+	for _, value := range values {
+		benchmarks = append(benchmarks, splitBenchmarks(value)...)
+	}
+	return utils.ArrayUnique(benchmarks), nil
+}
+
+func splitBenchmarks(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
 }
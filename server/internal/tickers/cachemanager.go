@@ -1,34 +1,114 @@
 package tickers
 
-import "github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+)
 
 type tickersCache interface {
 	domain.TickersRepository
 	domain.WritableTickersRepository
+	domain.TickerSearchRepository
+}
+
+// FreshnessPolicy is how long the cached data of a ticker is trusted. Stale prices are
+// served while the ticker is fetched again in the background, stale fundamentals (sector,
+// dividends, earning dates) are fetched again before answering. On Lambda, where nothing
+// runs once the response is sent, stale prices are also fetched again before answering.
+type FreshnessPolicy struct {
+	PricesMaxAge       time.Duration
+	FundamentalsMaxAge time.Duration
+}
+
+var DefaultFreshnessPolicy = FreshnessPolicy{
+	PricesMaxAge:       24 * time.Hour,
+	FundamentalsMaxAge: 7 * 24 * time.Hour,
+}
+
+// FreshnessPolicyFromEnv reads the max ages from TICKER_PRICES_MAX_AGE and
+// TICKER_FUNDAMENTALS_MAX_AGE as durations (e.g. 12h), defaulting to DefaultFreshnessPolicy
+func FreshnessPolicyFromEnv() (FreshnessPolicy, error) {
+	policy := DefaultFreshnessPolicy
+	if value, present := os.LookupEnv("TICKER_PRICES_MAX_AGE"); present {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil {
+			return FreshnessPolicy{}, err
+		}
+		policy.PricesMaxAge = maxAge
+	}
+	if value, present := os.LookupEnv("TICKER_FUNDAMENTALS_MAX_AGE"); present {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil {
+			return FreshnessPolicy{}, err
+		}
+		policy.FundamentalsMaxAge = maxAge
+	}
+	return policy, nil
 }
 
 type CacheManager struct {
 	externalRepository domain.TickersRepository
 	cache              tickersCache
+	policy             FreshnessPolicy
+	l                  *slog.Logger
+
+	mu         sync.Mutex
+	refreshing map[string]bool
 }
 
-func NewCacheManager(externalRepo domain.TickersRepository, cache tickersCache) *CacheManager {
+func NewCacheManager(externalRepo domain.TickersRepository, cache tickersCache, policy FreshnessPolicy, logger *slog.Logger) *CacheManager {
 	return &CacheManager{
 		externalRepository: externalRepo,
 		cache:              cache,
+		policy:             policy,
+		l:                  logger,
+		refreshing:         map[string]bool{},
 	}
 }
 
+// WriteToCache makes sure the ticker is cached. A ticker not cached yet or with stale
+// fundamentals is fetched right away, although a failure only fails when there is no
+// snapshot to fall back to. A ticker with stale prices is fetched in the background, or
+// right away on Lambda since the background work is frozen with the invocation.
 func (cm *CacheManager) WriteToCache(ticker string) error {
-	exists, err := cm.cache.Exists(ticker)
+	lastUpdate, err := cm.cache.FindLastUpdate(ticker)
 	if err != nil {
 		return err
 	}
 
-	if exists {
+	if lastUpdate == nil {
+		return cm.Refresh(ticker)
+	}
+
+	age := time.Since(*lastUpdate)
+	if age > cm.policy.FundamentalsMaxAge || (age > cm.policy.PricesMaxAge && utils.IsRunningInLambdaEnv()) {
+		if err := cm.Refresh(ticker); err != nil {
+			cm.l.Warn("Failed to refresh ticker, serving the stale one", "ticker", ticker, "age", age, "error", err.Error())
+		}
 		return nil
 	}
 
+	if age > cm.policy.PricesMaxAge {
+		cm.revalidate(ticker)
+	}
+	return nil
+}
+
+// Read returns the cached ticker in the currency, caching it first as WriteToCache does
+func (cm *CacheManager) Read(ticker string, currency *string) (domain.Ticker, error) {
+	if err := cm.WriteToCache(ticker); err != nil {
+		return domain.Ticker{}, err
+	}
+	return cm.cache.FindByTicker(ticker, currency)
+}
+
+// Refresh fetches the ticker and caches a new snapshot, whatever the age of the cached one
+func (cm *CacheManager) Refresh(ticker string) error {
 	tickerData, err := cm.externalRepository.FindByTicker(ticker, nil)
 	if err != nil {
 		return err
@@ -40,3 +120,45 @@ func (cm *CacheManager) WriteToCache(ticker string) error {
 
 	return nil
 }
+
+// Search looks the query up in the cached tickers and, when none matches, in the market
+// data providers that can search
+func (cm *CacheManager) Search(query string, limit int) (domain.TickerSearchResults, error) {
+	results, err := cm.cache.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > 0 {
+		return results, nil
+	}
+
+	searcher, ok := cm.externalRepository.(domain.TickerSearchRepository)
+	if !ok {
+		return results, nil
+	}
+	return searcher.Search(query, limit)
+}
+
+// revalidate refreshes the ticker in the background, unless it is already being refreshed
+func (cm *CacheManager) revalidate(ticker string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.refreshing[ticker] {
+		return
+	}
+	cm.refreshing[ticker] = true
+
+	go func() {
+		defer func() {
+			cm.mu.Lock()
+			delete(cm.refreshing, ticker)
+			cm.mu.Unlock()
+		}()
+
+		if err := cm.Refresh(ticker); err != nil {
+			cm.l.Warn("Failed to revalidate ticker", "ticker", ticker, "error", err.Error())
+			return
+		}
+		cm.l.Debug("Revalidated ticker", "ticker", ticker)
+	}()
+}
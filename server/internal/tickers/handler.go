@@ -0,0 +1,280 @@
+package tickers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Guillem96/portfolio-analyzer-server/internal/auth"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/domain"
+	"github.com/Guillem96/portfolio-analyzer-server/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+type Handler struct {
+	manualRepository domain.ManualTickersRepository
+	pricesRepository domain.TickerPricesRepository
+	cacheManager     *CacheManager
+	l                *slog.Logger
+}
+
+func New(manualRepository domain.ManualTickersRepository, pricesRepository domain.TickerPricesRepository, cacheManager *CacheManager, logger *slog.Logger) *Handler {
+	return &Handler{
+		manualRepository: manualRepository,
+		pricesRepository: pricesRepository,
+		cacheManager:     cacheManager,
+		l:                logger,
+	}
+}
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// SearchTickersHandler returns the tickers whose symbol, name or ISIN match the q query
+// parameter. The cached tickers are searched first and the market data providers only when
+// none matches.
+func (h *Handler) SearchTickersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing q query parameter")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			utils.SendHTTPMessage(w, http.StatusBadRequest, "Limit must be a number between 1 and 50")
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := h.cacheManager.Search(q, limit)
+	if err != nil {
+		h.l.Error("Failed to search tickers", "query", q, "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadGateway, "Failed to search tickers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := results.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize ticker search", "error", err.Error())
+	}
+}
+
+// RetrieveTickerHandler returns the cached ticker in the preferred currency of the user,
+// fetching it when it is not cached yet or its data is stale
+func (h *Handler) RetrieveTickerHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	ticker, present := vars["ticker"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing ticker parameter")
+		return
+	}
+
+	tickerData, err := h.cacheManager.Read(ticker, preferredCurrency(user))
+	if err != nil {
+		h.l.Error("Failed to read ticker", "ticker", ticker, "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusNotFound, "Ticker not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := tickerData.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize ticker", "error", err.Error())
+	}
+}
+
+// PriceHistoryHandler returns the daily prices of the ticker, or grouped by week or month
+// with the interval query parameter, in the preferred currency of the user. The from and to
+// query parameters default to the last year.
+func (h *Handler) PriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	ticker, present := vars["ticker"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing ticker parameter")
+		return
+	}
+
+	query := r.URL.Query()
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = domain.PriceIntervalDay
+	}
+	if !slices.Contains(domain.PriceIntervals, interval) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Interval must be one of day, week or month")
+		return
+	}
+
+	from := query.Get("from")
+	if from == "" {
+		from = time.Now().AddDate(-1, 0, 0).Format(time.DateOnly)
+	}
+
+	to := query.Get("to")
+	if to == "" {
+		to = time.Now().Format(time.DateOnly)
+	}
+
+	parsedFrom, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		h.l.Error("Failed to parse from date", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse from date")
+		return
+	}
+
+	parsedTo, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		h.l.Error("Failed to parse to date", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse to date")
+		return
+	}
+
+	if parsedTo.Before(parsedFrom) {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "The from date must not be after the to date")
+		return
+	}
+
+	history, err := h.pricesRepository.FindPrices(ticker, domain.Date(parsedFrom), domain.Date(parsedTo), interval, preferredCurrency(user))
+	if err != nil {
+		h.l.Error("Failed to retrieve price history", "ticker", ticker, "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to retrieve price history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := history.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize price history", "error", err.Error())
+	}
+}
+
+// RefreshTickerHandler fetches the ticker from the market data providers and caches a new
+// snapshot, regardless of the age of the cached one
+func (h *Handler) RefreshTickerHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(auth.UserKeyContext).(*auth.Claims)
+	user := claims.User
+
+	vars := mux.Vars(r)
+	ticker, present := vars["ticker"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing ticker parameter")
+		return
+	}
+
+	if err := h.cacheManager.Refresh(ticker); err != nil {
+		h.l.Error("Failed to refresh ticker", "ticker", ticker, "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadGateway, "Failed to fetch ticker")
+		return
+	}
+
+	tickerData, err := h.cacheManager.Read(ticker, preferredCurrency(user))
+	if err != nil {
+		h.l.Error("Failed to read ticker", "ticker", ticker, "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to read ticker")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := tickerData.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize ticker", "error", err.Error())
+	}
+}
+
+// ListManualTickersHandler returns the tickers entered by hand
+func (h *Handler) ListManualTickersHandler(w http.ResponseWriter, r *http.Request) {
+	tickers, err := h.manualRepository.FindAll()
+	if err != nil {
+		h.l.Error("Failed to get manual tickers", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to get manual tickers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := tickers.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize manual tickers", "error", err.Error())
+	}
+}
+
+// SaveManualTickerHandler creates or replaces the data of a ticker entered by hand, which
+// the manual market data provider serves
+func (h *Handler) SaveManualTickerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ticker, present := vars["ticker"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing ticker parameter")
+		return
+	}
+
+	manualTicker := &domain.ManualTicker{}
+	if err := manualTicker.FromJSON(r.Body); err != nil {
+		h.l.Error("Failed to parse request body", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+	defer r.Body.Close()
+
+	manualTicker.Ticker = ticker
+	if err := manualTicker.Validate(); err != nil {
+		h.l.Error("Invalid manual ticker", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	saved, err := h.manualRepository.Save(*manualTicker)
+	if err != nil {
+		h.l.Error("Failed to save manual ticker", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to save manual ticker")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := saved.ToJSON(w); err != nil {
+		h.l.Error("Failed to serialize manual ticker", "error", err.Error())
+	}
+}
+
+// DeleteManualTickerHandler deletes a ticker entered by hand, the snapshots already cached
+// from it are kept
+func (h *Handler) DeleteManualTickerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ticker, present := vars["ticker"]
+	if !present {
+		utils.SendHTTPMessage(w, http.StatusBadRequest, "Missing ticker parameter")
+		return
+	}
+
+	err := h.manualRepository.Delete(ticker)
+	if errors.Is(err, domain.ErrManualTickerNotFound) {
+		utils.SendHTTPMessage(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		h.l.Error("Failed to delete manual ticker", "error", err.Error())
+		utils.SendHTTPMessage(w, http.StatusInternalServerError, "Failed to delete manual ticker")
+		return
+	}
+
+	utils.SendHTTPMessage(w, http.StatusOK, "Manual ticker deleted successfully")
+}
+
+func preferredCurrency(user *domain.UserWithId) *string {
+	if user.PreferredCurrency == nil || *user.PreferredCurrency == "" {
+		currency := domain.EUR
+		return &currency
+	}
+	return user.PreferredCurrency
+}
@@ -21,3 +21,13 @@ func SendHTTPMessage(w http.ResponseWriter, statusCode int, message string) {
 	w.WriteHeader(statusCode)
 	w.Write(jsonResponse)
 }
+
+// PortfolioQuery returns the portfolio the request is filtered by, nil when the
+// request is about all the portfolios of the user
+func PortfolioQuery(r *http.Request) *string {
+	portfolioId := r.URL.Query().Get("portfolio")
+	if portfolioId == "" {
+		return nil
+	}
+	return &portfolioId
+}